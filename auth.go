@@ -38,6 +38,8 @@ type OperationPermissions struct {
 	AllowedRootQueryFields        AllowedFields `json:"query"`
 	AllowedRootMutationFields     AllowedFields `json:"mutation"`
 	AllowedRootSubscriptionFields AllowedFields `json:"subscription"`
+	// Limits override the gateway operation limits
+	Limits OperationLimits `json:"limits"`
 }
 
 type fieldList []string
@@ -94,7 +96,10 @@ func (a *AllowedFields) UnmarshalJSON(input []byte) error {
 
 // MarshalJSON marshals to a JSON representation.
 func (o OperationPermissions) MarshalJSON() ([]byte, error) {
-	m := make(map[string]AllowedFields)
+	m := make(map[string]interface{})
+	if o.Limits != (OperationLimits{}) {
+		m["limits"] = o.Limits
+	}
 	if o.AllowedRootQueryFields.AllowAll || o.AllowedRootQueryFields.AllowedSubfields != nil {
 		m["query"] = o.AllowedRootQueryFields
 	}
@@ -149,6 +154,16 @@ func (o *OperationPermissions) FilterSchema(schema *ast.Schema) *ast.Schema {
 		newSchema.Types["Subscription"] = newSchema.Subscription
 	}
 
+	// keep the types of directive arguments, so that introspection can
+	// describe every directive
+	for _, directive := range schema.Directives {
+		for _, arg := range directive.Arguments {
+			if typ, ok := schema.Types[arg.Type.Name()]; ok {
+				newSchema.Types[typ.Name] = typ
+			}
+		}
+	}
+
 	return &newSchema
 }
 
@@ -294,17 +309,20 @@ func MergePermissions(perms ...OperationPermissions) OperationPermissions {
 	var queries []AllowedFields
 	var mutations []AllowedFields
 	var subscriptions []AllowedFields
+	var limits []OperationLimits
 
 	for _, p := range perms {
 		queries = append(queries, p.AllowedRootQueryFields)
 		mutations = append(mutations, p.AllowedRootMutationFields)
 		subscriptions = append(subscriptions, p.AllowedRootSubscriptionFields)
+		limits = append(limits, p.Limits)
 	}
 
 	return OperationPermissions{
 		AllowedRootQueryFields:        MergeAllowedFields(queries...),
 		AllowedRootMutationFields:     MergeAllowedFields(mutations...),
 		AllowedRootSubscriptionFields: MergeAllowedFields(subscriptions...),
+		Limits:                        mergeOperationLimits(limits...),
 	}
 }
 
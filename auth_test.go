@@ -34,6 +34,14 @@ func TestUnmarshalRoles(t *testing.T) {
 				AllowAll: false,
 			},
 		},
+		"limited_role": {
+			AllowedRootQueryFields: AllowedFields{
+				AllowAll: true,
+			},
+			Limits: OperationLimits{
+				MaxComplexity: 100,
+			},
+		},
 	}
 
 	var newroles map[string]OperationPermissions
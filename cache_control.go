@@ -0,0 +1,369 @@
+package bramble
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/felixge/httpsnoop"
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+const (
+	cacheControlDirectiveName = "cacheControl"
+	cacheControlScopeTypeName = "CacheControlScope"
+
+	defaultResponseCacheSize = 1000
+)
+
+// CacheScope is the scope of a cache policy, private responses must only be
+// cached by the client
+type CacheScope string
+
+const (
+	CacheScopePublic  CacheScope = "PUBLIC"
+	CacheScopePrivate CacheScope = "PRIVATE"
+)
+
+// CachePolicy is the cache policy of a response
+type CachePolicy struct {
+	MaxAge time.Duration
+	Scope  CacheScope
+}
+
+// HeaderValue returns the value of the Cache-Control header for the policy
+func (p CachePolicy) HeaderValue() string {
+	seconds := int64(p.MaxAge / time.Second)
+	if seconds <= 0 {
+		return "no-store"
+	}
+	scope := "public"
+	if p.Scope == CacheScopePrivate {
+		scope = "private"
+	}
+	return fmt.Sprintf("max-age=%d, %s", seconds, scope)
+}
+
+// restrict returns the most restrictive of both policies
+func (p CachePolicy) restrict(maxAge time.Duration, scope CacheScope) CachePolicy {
+	if maxAge < p.MaxAge {
+		p.MaxAge = maxAge
+	}
+	if scope == CacheScopePrivate {
+		p.Scope = CacheScopePrivate
+	}
+	return p
+}
+
+// cacheable returns whether a response with the policy can be shared
+func (p CachePolicy) cacheable() bool {
+	return p.MaxAge >= time.Second && p.Scope != CacheScopePrivate
+}
+
+// CacheControlConfig is the configuration of the cache policies
+type CacheControlConfig struct {
+	// DefaultMaxAge is the max age of root fields and fields returning
+	// objects without @cacheControl hint
+	DefaultMaxAge string `json:"default-max-age"`
+	// ResponseCache enables the caching of public responses
+	ResponseCache bool `json:"response-cache"`
+	// ResponseCacheSize is the maximum number of cached responses
+	ResponseCacheSize int `json:"response-cache-size"`
+}
+
+// CacheControl computes the cache policy of query responses from the
+// @cacheControl(maxAge:, scope:) hints of the merged schema. The policy is the
+// minimum max age of the fields of the operation, and is private if any of
+// the fields is private.
+type CacheControl struct {
+	// DefaultMaxAge is the max age of root fields and fields returning
+	// objects without hint. Other fields don't restrict the policy.
+	DefaultMaxAge time.Duration
+	// ResponseCache caches public responses, disabled when nil
+	ResponseCache *ResponseCache
+}
+
+// NewCacheControl creates the cache control from its configuration
+func NewCacheControl(cfg CacheControlConfig) (*CacheControl, error) {
+	c := &CacheControl{}
+	if cfg.DefaultMaxAge != "" {
+		maxAge, err := time.ParseDuration(cfg.DefaultMaxAge)
+		if err != nil {
+			return nil, fmt.Errorf("invalid default max age: %w", err)
+		}
+		c.DefaultMaxAge = maxAge
+	}
+	if cfg.ResponseCache {
+		c.ResponseCache = NewResponseCache(cfg.ResponseCacheSize)
+	}
+	return c, nil
+}
+
+// policy returns the cache policy of the selection set
+func (c *CacheControl) policy(schema *ast.Schema, selectionSet ast.SelectionSet) CachePolicy {
+	policy := CachePolicy{MaxAge: math.MaxInt64, Scope: CacheScopePublic}
+	policy = c.selectionSetPolicy(schema, policy, selectionSet, true, make(map[string]bool))
+	if policy.MaxAge == math.MaxInt64 {
+		// only introspection fields
+		policy.MaxAge = c.DefaultMaxAge
+	}
+	return policy
+}
+
+func (c *CacheControl) selectionSetPolicy(schema *ast.Schema, policy CachePolicy, selectionSet ast.SelectionSet, root bool, visitedFragments map[string]bool) CachePolicy {
+	for _, selection := range selectionSet {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			if selection.Definition == nil || isIntrospectionField(selection.Name) {
+				continue
+			}
+			policy = c.fieldPolicy(schema, policy, selection.Definition, root)
+			policy = c.selectionSetPolicy(schema, policy, selection.SelectionSet, false, visitedFragments)
+		case *ast.InlineFragment:
+			policy = c.selectionSetPolicy(schema, policy, selection.SelectionSet, root, visitedFragments)
+		case *ast.FragmentSpread:
+			if visitedFragments[selection.Name] || selection.Definition == nil {
+				continue
+			}
+			visitedFragments[selection.Name] = true
+			policy = c.selectionSetPolicy(schema, policy, selection.Definition.SelectionSet, root, visitedFragments)
+		}
+	}
+	return policy
+}
+
+// fieldPolicy restricts the policy with the hint of the field, or of the type
+// returned by the field
+func (c *CacheControl) fieldPolicy(schema *ast.Schema, policy CachePolicy, field *ast.FieldDefinition, root bool) CachePolicy {
+	typeDef := schema.Types[field.Type.Name()]
+	maxAge, hasMaxAge, scope := cacheControlHint(field.Directives)
+	var (
+		typeMaxAge    time.Duration
+		typeHasMaxAge bool
+		typeScope     CacheScope
+	)
+	if typeDef != nil {
+		typeMaxAge, typeHasMaxAge, typeScope = cacheControlHint(typeDef.Directives)
+	}
+	if typeScope == CacheScopePrivate {
+		scope = CacheScopePrivate
+	}
+
+	switch {
+	case hasMaxAge:
+	case typeHasMaxAge:
+		maxAge = typeMaxAge
+	case root || (typeDef != nil && typeDef.IsCompositeType()):
+		maxAge = c.DefaultMaxAge
+	default:
+		// leaf fields inherit the policy of their parent
+		return policy.restrict(policy.MaxAge, scope)
+	}
+	return policy.restrict(maxAge, scope)
+}
+
+func isIntrospectionField(name string) bool {
+	return name == "__typename" || name == "__schema" || name == "__type"
+}
+
+// cacheControlHint returns the max age and scope of the @cacheControl
+// directive. The max age is only set if the directive has a valid maxAge
+// argument.
+func cacheControlHint(directives ast.DirectiveList) (time.Duration, bool, CacheScope) {
+	directive := directives.ForName(cacheControlDirectiveName)
+	if directive == nil {
+		return 0, false, ""
+	}
+
+	var scope CacheScope
+	if arg := directive.Arguments.ForName("scope"); arg != nil && arg.Value != nil {
+		scope = CacheScope(arg.Value.Raw)
+	}
+
+	arg := directive.Arguments.ForName("maxAge")
+	if arg == nil || arg.Value == nil {
+		return 0, false, scope
+	}
+	seconds, err := strconv.Atoi(arg.Value.Raw)
+	if err != nil || seconds < 0 {
+		return 0, false, scope
+	}
+	return time.Duration(seconds) * time.Second, true, scope
+}
+
+// mergeCacheControlDirectives returns the most restrictive of the
+// @cacheControl directives of both definitions of a boundary type
+func mergeCacheControlDirectives(a, b ast.DirectiveList) ast.DirectiveList {
+	da, db := a.ForName(cacheControlDirectiveName), b.ForName(cacheControlDirectiveName)
+	if da == nil || db == nil {
+		if da != nil {
+			return ast.DirectiveList{da}
+		}
+		if db != nil {
+			return ast.DirectiveList{db}
+		}
+		return nil
+	}
+
+	maxAgeA, hasMaxAgeA, scopeA := cacheControlHint(a)
+	maxAgeB, hasMaxAgeB, scopeB := cacheControlHint(b)
+	merged := &ast.Directive{Name: cacheControlDirectiveName, Position: da.Position, Definition: da.Definition}
+	if hasMaxAgeA || hasMaxAgeB {
+		maxAge := maxAgeA
+		if !hasMaxAgeA || (hasMaxAgeB && maxAgeB < maxAgeA) {
+			maxAge = maxAgeB
+		}
+		merged.Arguments = append(merged.Arguments, &ast.Argument{
+			Name:  "maxAge",
+			Value: &ast.Value{Kind: ast.IntValue, Raw: strconv.Itoa(int(maxAge / time.Second))},
+		})
+	}
+	if scopeA == CacheScopePrivate || scopeB == CacheScopePrivate {
+		merged.Arguments = append(merged.Arguments, &ast.Argument{
+			Name:  "scope",
+			Value: &ast.Value{Kind: ast.EnumValue, Raw: string(CacheScopePrivate)},
+		})
+	}
+	return ast.DirectiveList{merged}
+}
+
+// cachePolicyHolder holds the cache policy of the response, it is set during
+// the execution and read when the response is written
+type cachePolicyHolder struct {
+	mutex  sync.Mutex
+	policy *CachePolicy
+}
+
+// setCachePolicy sets the cache policy of the response
+func setCachePolicy(ctx context.Context, policy CachePolicy) {
+	holder, ok := ctx.Value(cachePolicyContextKey).(*cachePolicyHolder)
+	if !ok {
+		return
+	}
+	holder.mutex.Lock()
+	holder.policy = &policy
+	holder.mutex.Unlock()
+}
+
+// cacheControlMiddleware sets the Cache-Control header of the response to
+// the cache policy computed during the execution, unless the header is
+// already set by the transport
+func cacheControlMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		holder := &cachePolicyHolder{}
+		var once sync.Once
+		setHeader := func() {
+			once.Do(func() {
+				holder.mutex.Lock()
+				defer holder.mutex.Unlock()
+				if holder.policy != nil && w.Header().Get("Cache-Control") == "" {
+					w.Header().Set("Cache-Control", holder.policy.HeaderValue())
+				}
+			})
+		}
+
+		wrapped := httpsnoop.Wrap(w, httpsnoop.Hooks{
+			WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+				return func(code int) {
+					setHeader()
+					next(code)
+				}
+			},
+			Write: func(next httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+				return func(b []byte) (int, error) {
+					setHeader()
+					return next(b)
+				}
+			},
+		})
+
+		ctx := context.WithValue(r.Context(), cachePolicyContextKey, holder)
+		h.ServeHTTP(wrapped, r.WithContext(ctx))
+	})
+}
+
+// ResponseCache is a LRU cache of public query responses, kept for the max
+// age of their cache policy. Responses are keyed by the query document, the
+// operation name, the variables and the permissions of the request.
+type ResponseCache struct {
+	cache *lru.Cache
+	now   func() time.Time
+}
+
+type responseCacheEntry struct {
+	data    json.RawMessage
+	scope   CacheScope
+	expires time.Time
+}
+
+// NewResponseCache returns a response cache holding up to size responses,
+// 1000 when size isn't positive
+func NewResponseCache(size int) *ResponseCache {
+	if size <= 0 {
+		size = defaultResponseCacheSize
+	}
+	// lru.New only fails when the size isn't positive
+	cache, _ := lru.New(size)
+	return &ResponseCache{cache: cache, now: time.Now}
+}
+
+// get returns the cached response data and its remaining cache policy
+func (c *ResponseCache) get(key string) (json.RawMessage, CachePolicy, bool) {
+	if c == nil || key == "" {
+		return nil, CachePolicy{}, false
+	}
+	value, ok := c.cache.Get(key)
+	if !ok {
+		return nil, CachePolicy{}, false
+	}
+	entry := value.(responseCacheEntry)
+	remaining := entry.expires.Sub(c.now())
+	if remaining < time.Second {
+		c.cache.Remove(key)
+		return nil, CachePolicy{}, false
+	}
+	return entry.data, CachePolicy{MaxAge: remaining, Scope: entry.scope}, true
+}
+
+// add caches the response data if the policy allows it
+func (c *ResponseCache) add(key string, data json.RawMessage, policy CachePolicy) {
+	if c == nil || key == "" || !policy.cacheable() {
+		return
+	}
+	c.cache.Add(key, responseCacheEntry{
+		data:    append(json.RawMessage(nil), data...),
+		scope:   policy.Scope,
+		expires: c.now().Add(policy.MaxAge),
+	})
+}
+
+// Purge removes all the responses from the cache
+func (c *ResponseCache) Purge() {
+	if c == nil {
+		return
+	}
+	c.cache.Purge()
+}
+
+// Len returns the number of cached responses
+func (c *ResponseCache) Len() int {
+	if c == nil {
+		return 0
+	}
+	return c.cache.Len()
+}
+
+// responseCacheKey returns the cache key of the response of the operation
+func responseCacheKey(operationCtx *graphql.OperationContext, perms *OperationPermissions) string {
+	if operationCtx.Doc == nil {
+		return ""
+	}
+	return operationCacheKey(operationCtx, operationCtx.Variables, perms)
+}
@@ -0,0 +1,188 @@
+package bramble
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+func TestCacheControlPolicy(t *testing.T) {
+	schema := gqlparser.MustLoadSchema(&ast.Source{Input: `
+		directive @cacheControl(maxAge: Int, scope: CacheControlScope) on FIELD_DEFINITION | OBJECT | INTERFACE | UNION
+		enum CacheControlScope { PUBLIC PRIVATE }
+
+		type Movie @cacheControl(maxAge: 120) {
+			id: ID!
+			title: String!
+			director: Person
+			reviews: [Review!]! @cacheControl(maxAge: 30)
+		}
+		type Person { name: String! }
+		type Review { text: String! }
+		type User @cacheControl(maxAge: 60, scope: PRIVATE) { name: String! }
+
+		type Query {
+			movie: Movie @cacheControl(maxAge: 300)
+			movies: [Movie!]!
+			me: User
+			version: String
+		}`})
+	cacheControl := &CacheControl{}
+
+	policy := func(query string) CachePolicy {
+		doc := gqlparser.MustLoadQuery(schema, query)
+		return cacheControl.policy(schema, doc.Operations[0].SelectionSet)
+	}
+
+	assert.Equal(t, CachePolicy{MaxAge: 300 * time.Second, Scope: CacheScopePublic}, policy(`{ movie { title } }`), "field hint")
+	assert.Equal(t, CachePolicy{MaxAge: 120 * time.Second, Scope: CacheScopePublic}, policy(`{ movies { title } }`), "type hint")
+	assert.Equal(t, CachePolicy{MaxAge: 30 * time.Second, Scope: CacheScopePublic}, policy(`{ movie { ... on Movie { reviews { text } } } }`), "minimum max age")
+	assert.Equal(t, CachePolicy{MaxAge: 0, Scope: CacheScopePublic}, policy(`{ movie { director { name } } }`), "objects without hint")
+	assert.Equal(t, CachePolicy{MaxAge: 60 * time.Second, Scope: CacheScopePrivate}, policy(`{ movie { title } me { name } }`), "private scope")
+	assert.Equal(t, CachePolicy{MaxAge: 0, Scope: CacheScopePublic}, policy(`{ version }`), "root fields without hint")
+
+	cacheControl.DefaultMaxAge = 10 * time.Second
+	assert.Equal(t, CachePolicy{MaxAge: 10 * time.Second, Scope: CacheScopePublic}, policy(`{ version movie { title } }`))
+	assert.Equal(t, CachePolicy{MaxAge: 10 * time.Second, Scope: CacheScopePublic}, policy(`{ __typename }`))
+
+	assert.Equal(t, "max-age=60, private", CachePolicy{MaxAge: 60 * time.Second, Scope: CacheScopePrivate}.HeaderValue())
+	assert.Equal(t, "max-age=1, public", CachePolicy{MaxAge: 1500 * time.Millisecond, Scope: CacheScopePublic}.HeaderValue())
+	assert.Equal(t, "no-store", CachePolicy{}.HeaderValue())
+}
+
+func TestMergeCacheControl(t *testing.T) {
+	cacheControlDefinitions := `
+		directive @cacheControl(maxAge: Int, scope: CacheControlScope) on FIELD_DEFINITION | OBJECT
+		enum CacheControlScope { PUBLIC PRIVATE }
+		directive @boundary on OBJECT | FIELD_DEFINITION
+		interface Node { id: ID! }`
+	a := gqlparser.MustLoadSchema(&ast.Source{Input: cacheControlDefinitions + `
+		type Movie implements Node @boundary @cacheControl(maxAge: 120) {
+			id: ID!
+			title: String! @cacheControl(maxAge: 300)
+		}
+		type Query {
+			node(id: ID!): Node
+			movie(id: ID!): Movie @boundary
+		}`})
+	b := gqlparser.MustLoadSchema(&ast.Source{Input: cacheControlDefinitions + `
+		type Movie implements Node @boundary @cacheControl(maxAge: 60, scope: PRIVATE) {
+			id: ID!
+			rating: Int
+		}
+		type Query {
+			node(id: ID!): Node
+			movie(id: ID!): Movie @boundary
+		}`})
+
+	merged, err := MergeSchemas(a, b)
+	require.NoError(t, err)
+	require.NotNil(t, merged.Directives[cacheControlDirectiveName])
+	require.NotNil(t, merged.Types[cacheControlScopeTypeName])
+
+	movie := merged.Types["Movie"]
+	maxAge, ok, scope := cacheControlHint(movie.Directives)
+	assert.True(t, ok)
+	assert.Equal(t, 60*time.Second, maxAge, "the most restrictive hint is kept")
+	assert.Equal(t, CacheScopePrivate, scope)
+
+	maxAge, ok, _ = cacheControlHint(movie.Fields.ForName("title").Directives)
+	assert.True(t, ok)
+	assert.Equal(t, 300*time.Second, maxAge)
+}
+
+func TestCacheControlExecution(t *testing.T) {
+	var requests int32
+	f := &queryExecutionFixture{
+		services: []testService{
+			{
+				schema: `directive @cacheControl(maxAge: Int, scope: CacheControlScope) on FIELD_DEFINITION | OBJECT
+				enum CacheControlScope { PUBLIC PRIVATE }
+
+				type Query {
+					movie: String @cacheControl(maxAge: 60)
+					me: String @cacheControl(maxAge: 60, scope: PRIVATE)
+					broken: String @cacheControl(maxAge: 60)
+				}`,
+				handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					atomic.AddInt32(&requests, 1)
+					var req Request
+					require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+					if strings.Contains(req.Query, "broken") {
+						w.Write([]byte(`{"errors": [{"message": "broken"}]}`))
+						return
+					}
+					w.Write([]byte(`{"data": {"movie": "Fight Club", "me": "Tyler"}}`))
+				}),
+			},
+		},
+	}
+	es := f.setup(t)
+	cacheControl, err := NewCacheControl(CacheControlConfig{ResponseCache: true})
+	require.NoError(t, err)
+	es.CacheControl = cacheControl
+
+	gateway := handler.New(es)
+	gateway.AddTransport(transport.POST{})
+	srv := httptest.NewServer(cacheControlMiddleware(gateway))
+	t.Cleanup(srv.Close)
+
+	query := func(t *testing.T, query string) (string, string) {
+		resp, err := http.Post(srv.URL, "application/json", strings.NewReader(`{"query": "`+query+`"}`))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var result struct {
+			Data json.RawMessage
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		return resp.Header.Get("Cache-Control"), string(result.Data)
+	}
+
+	t.Run("public responses are cached", func(t *testing.T) {
+		atomic.StoreInt32(&requests, 0)
+		header, data := query(t, "{ movie }")
+		assert.Equal(t, "max-age=60, public", header)
+		assert.JSONEq(t, `{"movie": "Fight Club"}`, data)
+
+		header, data = query(t, "query { movie }")
+		assert.Regexp(t, `^max-age=(59|60), public$`, header)
+		assert.JSONEq(t, `{"movie": "Fight Club"}`, data)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+		assert.Equal(t, 1, es.CacheControl.ResponseCache.Len())
+	})
+
+	t.Run("private responses are not cached", func(t *testing.T) {
+		atomic.StoreInt32(&requests, 0)
+		header, _ := query(t, "{ me }")
+		assert.Equal(t, "max-age=60, private", header)
+		query(t, "{ me }")
+		assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+	})
+
+	t.Run("responses with errors are not cached", func(t *testing.T) {
+		atomic.StoreInt32(&requests, 0)
+		header, _ := query(t, "{ broken }")
+		assert.Equal(t, "no-store", header)
+		query(t, "{ broken }")
+		assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+	})
+}
+
+func TestResponseCacheSize(t *testing.T) {
+	for _, size := range []int{0, -1} {
+		cache := NewResponseCache(size)
+		cache.add("key", json.RawMessage(`{}`), CachePolicy{MaxAge: time.Minute, Scope: CacheScopePublic})
+		assert.Equal(t, 1, cache.Len(), "the default size is used when the size isn't positive")
+	}
+}
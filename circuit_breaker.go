@@ -0,0 +1,227 @@
+package bramble
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	errCircuitOpenCode = "SERVICE_UNAVAILABLE"
+
+	defaultCircuitBreakerFailureThreshold = 5
+	defaultCircuitBreakerOpenTimeout      = 30 * time.Second
+	defaultCircuitBreakerHalfOpenRequests = 1
+)
+
+// CircuitBreakerState is the state of the circuit breaker of a service
+type CircuitBreakerState int
+
+const (
+	// CircuitBreakerClosed lets all requests through
+	CircuitBreakerClosed CircuitBreakerState = iota
+	// CircuitBreakerHalfOpen lets a limited number of requests through to
+	// probe whether the service has recovered
+	CircuitBreakerHalfOpen
+	// CircuitBreakerOpen fails all requests without contacting the service
+	CircuitBreakerOpen
+)
+
+var circuitBreakerStates = []CircuitBreakerState{CircuitBreakerClosed, CircuitBreakerHalfOpen, CircuitBreakerOpen}
+
+func (s CircuitBreakerState) String() string {
+	switch s {
+	case CircuitBreakerClosed:
+		return "closed"
+	case CircuitBreakerHalfOpen:
+		return "half-open"
+	case CircuitBreakerOpen:
+		return "open"
+	}
+	return "unknown"
+}
+
+// CircuitBreakerConfig is the configuration of the per-service circuit
+// breakers
+type CircuitBreakerConfig struct {
+	// FailureThreshold is the number of consecutive failures opening the
+	// circuit
+	FailureThreshold int `json:"failure-threshold"`
+	// OpenTimeout is how long the circuit stays open before letting probe
+	// requests through
+	OpenTimeout string `json:"open-timeout"`
+	// HalfOpenRequests is the number of concurrent probe requests allowed
+	// when the circuit is half-open
+	HalfOpenRequests int `json:"half-open-requests"`
+}
+
+// CircuitOpenError is returned for requests to a service whose circuit is
+// open
+type CircuitOpenError struct {
+	ServiceURL string
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("service %s is unavailable: circuit breaker is open", e.ServiceURL)
+}
+
+// CircuitBreakers tracks the failures of every service and fails requests
+// fast when a service is down. Transport errors and 5xx responses are
+// failures, GraphQL errors returned by a service are not.
+type CircuitBreakers struct {
+	failureThreshold int
+	openTimeout      time.Duration
+	halfOpenRequests int
+	now              func() time.Time
+
+	mu       sync.Mutex
+	breakers map[string]*circuitBreaker
+}
+
+type circuitBreaker struct {
+	state    CircuitBreakerState
+	failures int
+	openedAt time.Time
+	inFlight int
+}
+
+// NewCircuitBreakers creates circuit breakers from the config, unset values
+// are replaced by their defaults.
+func NewCircuitBreakers(cfg CircuitBreakerConfig) (*CircuitBreakers, error) {
+	b := &CircuitBreakers{
+		failureThreshold: cfg.FailureThreshold,
+		openTimeout:      defaultCircuitBreakerOpenTimeout,
+		halfOpenRequests: cfg.HalfOpenRequests,
+		now:              time.Now,
+		breakers:         make(map[string]*circuitBreaker),
+	}
+	if b.failureThreshold <= 0 {
+		b.failureThreshold = defaultCircuitBreakerFailureThreshold
+	}
+	if b.halfOpenRequests <= 0 {
+		b.halfOpenRequests = defaultCircuitBreakerHalfOpenRequests
+	}
+	if cfg.OpenTimeout != "" {
+		timeout, err := time.ParseDuration(cfg.OpenTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid open timeout: %w", err)
+		}
+		b.openTimeout = timeout
+	}
+	return b, nil
+}
+
+// State returns the state of the circuit breaker for the service
+func (b *CircuitBreakers) State(serviceURL string) CircuitBreakerState {
+	if b == nil {
+		return CircuitBreakerClosed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if breaker, ok := b.breakers[serviceURL]; ok {
+		return breaker.state
+	}
+	return CircuitBreakerClosed
+}
+
+// allow returns an error if the request to the service should not be made.
+// Every allowed request must be followed by a call to record or release.
+func (b *CircuitBreakers) allow(serviceURL string) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	breaker := b.breaker(serviceURL)
+	if breaker.state == CircuitBreakerOpen {
+		if b.now().Sub(breaker.openedAt) < b.openTimeout {
+			return &CircuitOpenError{ServiceURL: serviceURL}
+		}
+		b.setState(serviceURL, breaker, CircuitBreakerHalfOpen)
+	}
+	if breaker.state == CircuitBreakerHalfOpen {
+		if breaker.inFlight >= b.halfOpenRequests {
+			return &CircuitOpenError{ServiceURL: serviceURL}
+		}
+		breaker.inFlight++
+	}
+	return nil
+}
+
+// record records the outcome of an allowed request
+func (b *CircuitBreakers) record(serviceURL string, success bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	breaker := b.breaker(serviceURL)
+	switch breaker.state {
+	case CircuitBreakerClosed:
+		if success {
+			breaker.failures = 0
+			return
+		}
+		breaker.failures++
+		if breaker.failures >= b.failureThreshold {
+			b.open(serviceURL, breaker)
+		}
+	case CircuitBreakerHalfOpen:
+		breaker.inFlight--
+		if success {
+			breaker.failures = 0
+			b.setState(serviceURL, breaker, CircuitBreakerClosed)
+			return
+		}
+		b.open(serviceURL, breaker)
+	}
+}
+
+// release releases an allowed request without recording its outcome, e.g.
+// when the request was cancelled by the client
+func (b *CircuitBreakers) release(serviceURL string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	breaker := b.breaker(serviceURL)
+	if breaker.state == CircuitBreakerHalfOpen {
+		breaker.inFlight--
+	}
+}
+
+func (b *CircuitBreakers) breaker(serviceURL string) *circuitBreaker {
+	breaker, ok := b.breakers[serviceURL]
+	if !ok {
+		breaker = &circuitBreaker{}
+		b.breakers[serviceURL] = breaker
+		b.setState(serviceURL, breaker, CircuitBreakerClosed)
+	}
+	return breaker
+}
+
+func (b *CircuitBreakers) open(serviceURL string, breaker *circuitBreaker) {
+	breaker.openedAt = b.now()
+	b.setState(serviceURL, breaker, CircuitBreakerOpen)
+}
+
+func (b *CircuitBreakers) setState(serviceURL string, breaker *circuitBreaker, state CircuitBreakerState) {
+	breaker.state = state
+	breaker.inFlight = 0
+	for _, s := range circuitBreakerStates {
+		value := 0.0
+		if s == state {
+			value = 1
+		}
+		promServiceCircuitBreakerState.With(prometheus.Labels{
+			"service": serviceURL,
+			"state":   s.String(),
+		}).Set(value)
+	}
+}
@@ -0,0 +1,140 @@
+package bramble
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	const url = "http://service"
+	now := time.Now()
+	breakers, err := NewCircuitBreakers(CircuitBreakerConfig{
+		FailureThreshold: 2,
+		OpenTimeout:      "10s",
+	})
+	require.NoError(t, err)
+	breakers.now = func() time.Time { return now }
+
+	assert.Equal(t, CircuitBreakerClosed, breakers.State(url))
+
+	// successes reset the consecutive failures
+	require.NoError(t, breakers.allow(url))
+	breakers.record(url, false)
+	require.NoError(t, breakers.allow(url))
+	breakers.record(url, true)
+	require.NoError(t, breakers.allow(url))
+	breakers.record(url, false)
+	assert.Equal(t, CircuitBreakerClosed, breakers.State(url))
+
+	require.NoError(t, breakers.allow(url))
+	breakers.record(url, false)
+	assert.Equal(t, CircuitBreakerOpen, breakers.State(url))
+	assert.Equal(t, &CircuitOpenError{ServiceURL: url}, breakers.allow(url))
+
+	// a single probe is let through after the timeout
+	now = now.Add(10 * time.Second)
+	require.NoError(t, breakers.allow(url))
+	assert.Equal(t, CircuitBreakerHalfOpen, breakers.State(url))
+	assert.Error(t, breakers.allow(url))
+
+	// a failed probe opens the circuit again
+	breakers.record(url, false)
+	assert.Equal(t, CircuitBreakerOpen, breakers.State(url))
+	assert.Error(t, breakers.allow(url))
+
+	// a released probe doesn't change the state
+	now = now.Add(10 * time.Second)
+	require.NoError(t, breakers.allow(url))
+	breakers.release(url)
+	assert.Equal(t, CircuitBreakerHalfOpen, breakers.State(url))
+
+	// a successful probe closes the circuit
+	require.NoError(t, breakers.allow(url))
+	breakers.record(url, true)
+	assert.Equal(t, CircuitBreakerClosed, breakers.State(url))
+	require.NoError(t, breakers.allow(url))
+
+	assert.Equal(t, CircuitBreakerClosed, breakers.State("http://other-service"))
+
+	_, err = NewCircuitBreakers(CircuitBreakerConfig{OpenTimeout: "ten seconds"})
+	assert.Error(t, err)
+}
+
+func TestCircuitBreakerClient(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.Header.Get("X-Fail") != "" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{"errors": [{"message": "not found"}]}`))
+	}))
+	defer srv.Close()
+
+	breakers, err := NewCircuitBreakers(CircuitBreakerConfig{FailureThreshold: 2})
+	require.NoError(t, err)
+	c := NewClient(WithCircuitBreakers(breakers))
+
+	// GraphQL errors are not failures
+	for i := 0; i < 3; i++ {
+		err := c.Request(context.Background(), srv.URL, &Request{}, nil)
+		assert.EqualError(t, err, "not found")
+	}
+	assert.Equal(t, CircuitBreakerClosed, breakers.State(srv.URL))
+
+	failing := &Request{Headers: http.Header{"X-Fail": []string{"1"}}}
+	for i := 0; i < 2; i++ {
+		_ = c.Request(context.Background(), srv.URL, failing, nil)
+	}
+	assert.Equal(t, CircuitBreakerOpen, breakers.State(srv.URL))
+
+	err = c.Request(context.Background(), srv.URL, &Request{}, nil)
+	var circuitErr *CircuitOpenError
+	require.ErrorAs(t, err, &circuitErr)
+	assert.Equal(t, srv.URL, circuitErr.ServiceURL)
+	assert.Equal(t, int32(5), atomic.LoadInt32(&requests))
+}
+
+func TestCircuitBreakerQueryExecution(t *testing.T) {
+	f := &queryExecutionFixture{
+		services: []testService{
+			{
+				schema: `type Query { movie: String! }`,
+				handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Write([]byte(`{"data": {"movie": "Fight Club"}}`))
+				}),
+			},
+			{
+				schema: `type Query { actor: String }`,
+				handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					t.Error("the service should not be queried when the circuit is open")
+				}),
+			},
+		},
+	}
+	es := f.setup(t)
+
+	breakers, err := NewCircuitBreakers(CircuitBreakerConfig{FailureThreshold: 1})
+	require.NoError(t, err)
+	actorURL, err := es.Locations.URLFor(queryObjectName, "", "actor")
+	require.NoError(t, err)
+	require.NoError(t, breakers.allow(actorURL))
+	breakers.record(actorURL, false)
+	es.GraphqlClient.CircuitBreakers = breakers
+
+	query := gqlparser.MustLoadQuery(f.mergedSchema, `{ movie actor }`)
+	resp := es.ExecuteQuery(testContextWithVariables(nil, query.Operations[0]))
+	require.Len(t, resp.Errors, 1)
+	assert.Equal(t, errCircuitOpenCode, resp.Errors[0].Extensions["code"])
+	assert.Equal(t, actorURL, resp.Errors[0].Extensions["serviceUrl"])
+	assert.JSONEq(t, `{"movie": "Fight Club", "actor": null}`, string(resp.Data))
+}
@@ -9,19 +9,43 @@ import (
 	"io"
 	"math"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/vektah/gqlparser/v2/ast"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// timeoutHeader is the header containing the time left for a request to a
+// service, in milliseconds, so that services can stop early
+const timeoutHeader = "X-Bramble-Timeout"
+
 // GraphQLClient is a GraphQL client.
 type GraphQLClient struct {
 	HTTPClient      *http.Client
 	MaxResponseSize int64
 	UserAgent       string
+	// CircuitBreakers fails requests to unavailable services fast, disabled
+	// when nil
+	CircuitBreakers *CircuitBreakers
+	// RetryPolicies are the retry policies used by RequestWithRetry,
+	// requests are not retried when nil
+	RetryPolicies *RetryPolicies
+	// RequestCoalescing shares responses among identical concurrent queries,
+	// disabled when nil
+	RequestCoalescing *RequestCoalescing
+
+	services      map[string]*serviceSettings
+	servicesMutex sync.RWMutex
 }
 
 // ClientOpt is a function used to set a GraphQL client option
@@ -91,23 +115,77 @@ func WithUserAgent(userAgent string) ClientOpt {
 	}
 }
 
+// WithCircuitBreakers sets the circuit breakers guarding requests to services.
+func WithCircuitBreakers(breakers *CircuitBreakers) ClientOpt {
+	return func(s *GraphQLClient) {
+		s.CircuitBreakers = breakers
+	}
+}
+
+// WithRetryPolicies sets the retry policies used for idempotent requests.
+func WithRetryPolicies(policies *RetryPolicies) ClientOpt {
+	return func(s *GraphQLClient) {
+		s.RetryPolicies = policies
+	}
+}
+
 // Request executes a GraphQL request.
 func (c *GraphQLClient) Request(ctx context.Context, url string, request *Request, out interface{}) error {
+	_, err := c.request(ctx, url, request, out)
+	return err
+}
+
+// request executes a GraphQL request and returns the HTTP status code of the
+// response, 0 if no response was received.
+func (c *GraphQLClient) request(ctx context.Context, url string, request *Request, out interface{}) (statusCode int, err error) {
+	ctx, span := tracer.Start(ctx, "downstream request",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("bramble.service.url", url),
+			attribute.String("graphql.operation.name", request.OperationName),
+		),
+	)
+	start := time.Now()
+	defer func() {
+		if statusCode != 0 {
+			span.SetAttributes(attribute.Int("http.response.status_code", statusCode))
+		}
+		endSpan(span, err)
+		observeServiceRequest(ctx, start, statusCode, err)
+	}()
+
+	if c.RequestCoalescing != nil {
+		if key, ok := c.RequestCoalescing.key(url, request); ok {
+			return c.coalescedRequest(ctx, key, url, request, out)
+		}
+	}
+	return c.sendRequest(ctx, url, request, out)
+}
+
+// sendRequest sends a GraphQL request to the service
+func (c *GraphQLClient) sendRequest(ctx context.Context, url string, request *Request, out interface{}) (int, error) {
 	var buf bytes.Buffer
 	err := json.NewEncoder(&buf).Encode(request)
 	if err != nil {
-		return fmt.Errorf("unable to encode request body: %w", err)
+		return 0, fmt.Errorf("unable to encode request body: %w", err)
 	}
 
 	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, &buf)
 	if err != nil {
-		return fmt.Errorf("unable to create request: %w", err)
+		return 0, fmt.Errorf("unable to create request: %w", err)
 	}
 
 	if request.Headers != nil {
 		httpReq.Header = request.Headers.Clone()
 	}
 
+	settings := c.serviceSettings(url)
+	if settings != nil {
+		for name, values := range settings.headers {
+			httpReq.Header[name] = values
+		}
+	}
+
 	httpReq.Header.Set("Content-Type", "application/json; charset=utf-8")
 	httpReq.Header.Set("Accept", "application/json; charset=utf-8")
 
@@ -115,18 +193,37 @@ func (c *GraphQLClient) Request(ctx context.Context, url string, request *Reques
 		httpReq.Header.Set("User-Agent", c.UserAgent)
 	}
 
-	res, err := c.HTTPClient.Do(httpReq)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(httpReq.Header))
+
+	httpClient := c.httpClient(settings)
+	if timeout := requestTimeout(ctx, httpClient.Timeout); timeout > 0 {
+		httpReq.Header.Set(timeoutHeader, strconv.FormatInt(timeout.Milliseconds(), 10))
+	}
+
+	if err := c.CircuitBreakers.allow(url); err != nil {
+		return 0, err
+	}
+
+	res, err := httpClient.Do(httpReq)
+	switch {
+	case err != nil && ctx.Err() != nil:
+		c.CircuitBreakers.release(url)
+	case err != nil:
+		c.CircuitBreakers.record(url, false)
+	default:
+		c.CircuitBreakers.record(url, res.StatusCode < http.StatusInternalServerError)
+	}
 	if err != nil {
 		if os.IsTimeout(err) {
 			promServiceTimeoutErrorCounter.With(prometheus.Labels{
 				"service": url,
 			}).Inc()
 		}
-		return fmt.Errorf("error during request: %w", err)
+		return 0, fmt.Errorf("error during request: %w", err)
 	}
 	defer res.Body.Close()
 
-	maxResponseSize := c.MaxResponseSize
+	maxResponseSize := c.maxResponseSize(settings)
 	if maxResponseSize == 0 {
 		maxResponseSize = math.MaxInt64
 	}
@@ -144,19 +241,229 @@ func (c *GraphQLClient) Request(ctx context.Context, url string, request *Reques
 	if err != nil {
 		if errors.Is(err, io.ErrUnexpectedEOF) {
 			if limitReader.N == 0 {
-				return fmt.Errorf("response exceeded maximum size of %d bytes", maxResponseSize)
+				return res.StatusCode, fmt.Errorf("response exceeded maximum size of %d bytes", maxResponseSize)
 			}
 		}
-		return fmt.Errorf("error decoding response: %w", err)
+		return res.StatusCode, fmt.Errorf("error decoding response: %w", err)
 	}
 
 	if len(graphqlResponse.Errors) > 0 {
-		return graphqlResponse.Errors
+		return res.StatusCode, graphqlResponse.Errors
+	}
+
+	return res.StatusCode, nil
+}
+
+// requestTimeout returns the time left for a request, the shortest of the
+// client timeout and the time left before the context deadline. It returns 0
+// if there is no timeout.
+func requestTimeout(ctx context.Context, clientTimeout time.Duration) time.Duration {
+	timeout := clientTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		remaining := time.Until(deadline)
+		if remaining < time.Millisecond {
+			remaining = time.Millisecond
+		}
+		if timeout == 0 || remaining < timeout {
+			timeout = remaining
+		}
+	}
+	return timeout
+}
+
+// SubscriptionEvent is a single event received from a downstream
+// subscription. Err is set when the service returned errors for the event or
+// when the subscription failed.
+type SubscriptionEvent struct {
+	Data map[string]interface{}
+	Err  error
+}
+
+// Subscribe opens a subscription on the service using the graphql-transport-ws
+// protocol. Events are sent on the returned channel until the service
+// completes the subscription or the context is cancelled, the channel is then
+// closed.
+func (c *GraphQLClient) Subscribe(ctx context.Context, serviceURL string, request *Request) (<-chan SubscriptionEvent, error) {
+	wsURL, err := websocketURL(serviceURL)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make(http.Header)
+	if request.Headers != nil {
+		header = request.Headers.Clone()
+	}
+	settings := c.serviceSettings(serviceURL)
+	if settings != nil {
+		for name, values := range settings.headers {
+			header[name] = values
+		}
+	}
+	if c.UserAgent != "" {
+		header.Set("User-Agent", c.UserAgent)
+	}
+
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+
+	timeout := c.httpClient(settings).Timeout
+	dialer := websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: timeout,
+		Subprotocols:     []string{graphqlTransportWSProtocol},
+	}
+	if err := c.CircuitBreakers.allow(serviceURL); err != nil {
+		return nil, err
+	}
+	conn, res, err := dialer.DialContext(ctx, wsURL, header)
+	switch {
+	case err != nil && ctx.Err() != nil:
+		c.CircuitBreakers.release(serviceURL)
+	case err != nil && res == nil:
+		c.CircuitBreakers.record(serviceURL, false)
+	default:
+		c.CircuitBreakers.record(serviceURL, res.StatusCode < http.StatusInternalServerError)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening subscription: %w", err)
+	}
+	if maxResponseSize := c.maxResponseSize(settings); maxResponseSize > 0 {
+		conn.SetReadLimit(maxResponseSize)
+	}
+
+	if err := c.initSubscription(conn, request, timeout); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	var writeLock sync.Mutex
+	write := func(msg wsMessage) {
+		writeLock.Lock()
+		defer writeLock.Unlock()
+		_ = conn.WriteJSON(msg)
+	}
+
+	events := make(chan SubscriptionEvent)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			write(wsMessage{ID: "1", Type: wsCompleteMsg})
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	go func() {
+		defer close(events)
+		defer conn.Close()
+		defer close(done)
+
+		send := func(event SubscriptionEvent) bool {
+			select {
+			case events <- event:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		for {
+			var msg wsMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				if ctx.Err() == nil && !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+					send(SubscriptionEvent{Err: fmt.Errorf("error during subscription: %w", err)})
+				}
+				return
+			}
+
+			switch msg.Type {
+			case wsNextMsg:
+				var response struct {
+					Data   map[string]interface{} `json:"data"`
+					Errors GraphqlErrors          `json:"errors"`
+				}
+				event := SubscriptionEvent{}
+				if err := json.Unmarshal(msg.Payload, &response); err != nil {
+					event.Err = fmt.Errorf("error decoding subscription event: %w", err)
+				} else {
+					event.Data = response.Data
+					if len(response.Errors) > 0 {
+						event.Err = response.Errors
+					}
+				}
+				if !send(event) {
+					return
+				}
+			case wsErrorMsg:
+				var errs GraphqlErrors
+				if err := json.Unmarshal(msg.Payload, &errs); err != nil {
+					send(SubscriptionEvent{Err: fmt.Errorf("error decoding subscription error: %w", err)})
+					return
+				}
+				send(SubscriptionEvent{Err: errs})
+				return
+			case wsCompleteMsg:
+				return
+			case wsPingMsg:
+				write(wsMessage{Type: wsPongMsg})
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+// initSubscription initializes the connection and subscribes to the operation.
+func (c *GraphQLClient) initSubscription(conn *websocket.Conn, request *Request, timeout time.Duration) error {
+	if timeout > 0 {
+		_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	}
+
+	if err := conn.WriteJSON(wsMessage{Type: wsConnectionInitMsg}); err != nil {
+		return fmt.Errorf("error initializing subscription: %w", err)
+	}
+
+	for {
+		var msg wsMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			return fmt.Errorf("error initializing subscription: %w", err)
+		}
+		if msg.Type == wsConnectionAckMsg {
+			break
+		}
+		if msg.Type == wsPingMsg {
+			if err := conn.WriteJSON(wsMessage{Type: wsPongMsg}); err != nil {
+				return fmt.Errorf("error initializing subscription: %w", err)
+			}
+		}
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("unable to encode request body: %w", err)
+	}
+	if err := conn.WriteJSON(wsMessage{ID: "1", Type: wsSubscribeMsg, Payload: payload}); err != nil {
+		return fmt.Errorf("error subscribing: %w", err)
 	}
 
 	return nil
 }
 
+func websocketURL(serviceURL string) (string, error) {
+	u, err := url.Parse(serviceURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid service url: %w", err)
+	}
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	default:
+		u.Scheme = "ws"
+	}
+	return u.String(), nil
+}
+
 // Request is a GraphQL request.
 type Request struct {
 	Query         string                 `json:"query"`
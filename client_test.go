@@ -2,11 +2,14 @@ package bramble
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"net/http/cookiejar"
 	"net/http/httptest"
 	"net/url"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -101,4 +104,25 @@ func TestGraphqlClient(t *testing.T) {
 		require.Error(t, err)
 		assert.Equal(t, "response exceeded maximum size of 1 bytes", err.Error())
 	})
+
+	t.Run("with timeout header", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(fmt.Sprintf(`{"data": {"timeout": %q}}`, r.Header.Get(timeoutHeader))))
+		}))
+
+		c := NewClient()
+		var res struct{ Timeout string }
+		err := c.Request(context.Background(), srv.URL, &Request{}, &res)
+		require.NoError(t, err)
+		assert.Equal(t, "5000", res.Timeout)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		err = c.Request(ctx, srv.URL, &Request{}, &res)
+		require.NoError(t, err)
+		timeout, err := strconv.Atoi(res.Timeout)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, timeout, 1000)
+		assert.Greater(t, timeout, 0)
+	})
 }
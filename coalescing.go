@@ -0,0 +1,148 @@
+package bramble
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/parser"
+	"golang.org/x/sync/singleflight"
+)
+
+var defaultCoalescingHeaders = []string{"Authorization", "Cookie"}
+
+// CoalescingConfig configures the coalescing of identical concurrent
+// requests to services.
+type CoalescingConfig struct {
+	// Headers are the request headers that are part of the coalescing key,
+	// requests with different values for these headers are never coalesced.
+	// Defaults to Authorization and Cookie.
+	Headers []string `json:"headers"`
+}
+
+// RequestCoalescing shares the response of an in-flight request among
+// identical concurrent requests. Requests are identical when they have the
+// same service URL, document, operation name, variables and key headers.
+// Only queries are coalesced.
+type RequestCoalescing struct {
+	headers []string
+	group   singleflight.Group
+}
+
+type coalescedResponse struct {
+	statusCode int
+	data       json.RawMessage
+}
+
+// NewRequestCoalescing creates the request coalescing from its configuration
+func NewRequestCoalescing(cfg CoalescingConfig) *RequestCoalescing {
+	headers := cfg.Headers
+	if headers == nil {
+		headers = defaultCoalescingHeaders
+	}
+	c := &RequestCoalescing{}
+	for _, header := range headers {
+		c.headers = append(c.headers, http.CanonicalHeaderKey(header))
+	}
+	return c
+}
+
+// key returns the coalescing key of the request, false if the request can't
+// be coalesced
+func (c *RequestCoalescing) key(url string, request *Request) (string, bool) {
+	if !isQueryRequest(request) {
+		return "", false
+	}
+	variables, err := json.Marshal(request.Variables)
+	if err != nil {
+		return "", false
+	}
+
+	h := sha256.New()
+	write := func(s string) {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	write(url)
+	write(request.Query)
+	write(request.OperationName)
+	write(string(variables))
+	for _, header := range c.headers {
+		write(header)
+		for _, value := range request.Headers.Values(header) {
+			write(value)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
+// isQueryRequest returns whether the operation executed by the request is a
+// query
+func isQueryRequest(request *Request) bool {
+	doc, err := parser.ParseQuery(&ast.Source{Input: request.Query})
+	if err != nil {
+		return false
+	}
+	var operation *ast.OperationDefinition
+	if request.OperationName == "" && len(doc.Operations) == 1 {
+		operation = doc.Operations[0]
+	} else {
+		operation = doc.Operations.ForName(request.OperationName)
+	}
+	return operation != nil && operation.Operation == ast.Query
+}
+
+// WithRequestCoalescing enables the coalescing of identical concurrent queries.
+func WithRequestCoalescing(coalescing *RequestCoalescing) ClientOpt {
+	return func(s *GraphQLClient) {
+		s.RequestCoalescing = coalescing
+	}
+}
+
+// coalescedRequest executes the request, sharing the response with identical
+// concurrent requests with the same key.
+func (c *GraphQLClient) coalescedRequest(ctx context.Context, key, url string, request *Request, out interface{}) (int, error) {
+	var sent bool
+	ch := c.RequestCoalescing.group.DoChan(key, func() (interface{}, error) {
+		sent = true
+		var data json.RawMessage
+		statusCode, err := c.sendRequest(ctx, url, request, &data)
+		return coalescedResponse{statusCode: statusCode, data: data}, err
+	})
+
+	var res singleflight.Result
+	select {
+	case res = <-ch:
+	case <-ctx.Done():
+		return 0, fmt.Errorf("error during request: %w", ctx.Err())
+	}
+	err := res.Err
+
+	result := "sent"
+	if !sent {
+		result = "shared"
+	}
+	promServiceCoalescingCounter.With(prometheus.Labels{
+		"service": url,
+		"result":  result,
+	}).Inc()
+
+	// the request was cancelled by the caller that sent it, not by this one
+	if !sent && ctx.Err() == nil && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
+		return c.sendRequest(ctx, url, request, out)
+	}
+
+	response := res.Val.(coalescedResponse)
+	if len(response.data) > 0 && out != nil {
+		if decodeErr := json.Unmarshal(response.data, out); decodeErr != nil && err == nil {
+			err = decodeErr
+		}
+	}
+	return response.statusCode, err
+}
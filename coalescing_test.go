@@ -0,0 +1,133 @@
+package bramble
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestCoalescingKey(t *testing.T) {
+	coalescing := NewRequestCoalescing(CoalescingConfig{})
+	key := func(request *Request) string {
+		k, ok := coalescing.key("http://movies/query", request)
+		require.True(t, ok)
+		return k
+	}
+
+	base := key(NewRequest("query { movie(id: 1) { title } }"))
+	assert.Equal(t, base, key(NewRequest("query { movie(id: 1) { title } }").
+		WithHeaders(http.Header{"X-Request-Id": []string{"1"}})), "other headers are ignored")
+	assert.NotEqual(t, base, key(NewRequest("query { movie(id: 2) { title } }")))
+	assert.NotEqual(t, base, key(NewRequest("query { movie(id: 1) { title } }").
+		WithHeaders(http.Header{"Authorization": []string{"Bearer token"}})))
+	assert.NotEqual(t, base, key(NewRequest("query { movie(id: 1) { title } }").
+		WithVariables(map[string]interface{}{"id": 1})))
+
+	_, ok := coalescing.key("http://movies/query", NewRequest("mutation { rateMovie }"))
+	assert.False(t, ok, "mutations are not coalesced")
+	_, ok = coalescing.key("http://movies/query", NewRequest("query A { movie } mutation B { rateMovie }").WithOperationName("B"))
+	assert.False(t, ok)
+	_, ok = coalescing.key("http://movies/query", NewRequest("{ movie"))
+	assert.False(t, ok)
+}
+
+func TestRequestCoalescing(t *testing.T) {
+	const concurrentRequests = 5
+
+	// newServer returns a server whose responses are held until release is
+	// closed, every request is signaled on received once it reaches the
+	// server
+	newServer := func(t *testing.T) (srv *httptest.Server, received chan struct{}, release chan struct{}) {
+		received = make(chan struct{}, concurrentRequests)
+		release = make(chan struct{})
+		srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received <- struct{}{}
+			<-release
+			w.Write([]byte(`{"data": {"movie": "Fight Club"}}`))
+		}))
+		t.Cleanup(srv.Close)
+		return srv, received, release
+	}
+
+	c := NewClient(WithRequestCoalescing(NewRequestCoalescing(CoalescingConfig{})))
+
+	t.Run("identical queries share a response", func(t *testing.T) {
+		srv, received, release := newServer(t)
+		results := make([]string, concurrentRequests)
+		var wg, started sync.WaitGroup
+		request := func(i int) {
+			defer wg.Done()
+			var res struct{ Movie string }
+			assert.NoError(t, c.Request(context.Background(), srv.URL, NewRequest("{ movie }"), &res))
+			results[i] = res.Movie
+		}
+
+		// the other requests are sent while the first one is held by the
+		// server
+		wg.Add(1)
+		go request(0)
+		<-received
+		for i := 1; i < concurrentRequests; i++ {
+			i := i
+			wg.Add(1)
+			started.Add(1)
+			go func() {
+				started.Done()
+				request(i)
+			}()
+		}
+		started.Wait()
+		close(release)
+		wg.Wait()
+
+		assert.Len(t, received, 0, "a single request reaches the server")
+		for _, movie := range results {
+			assert.Equal(t, "Fight Club", movie)
+		}
+		assert.Equal(t, 1.0, testutil.ToFloat64(promServiceCoalescingCounter.WithLabelValues(srv.URL, "sent")))
+		assert.Equal(t, float64(concurrentRequests-1), testutil.ToFloat64(promServiceCoalescingCounter.WithLabelValues(srv.URL, "shared")))
+	})
+
+	t.Run("mutations are not coalesced", func(t *testing.T) {
+		srv, received, release := newServer(t)
+		var wg sync.WaitGroup
+		for i := 0; i < concurrentRequests; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.NoError(t, c.Request(context.Background(), srv.URL, NewRequest("mutation { movie }"), nil))
+			}()
+		}
+		// every mutation reaches the server while the others are held
+		for i := 0; i < concurrentRequests; i++ {
+			<-received
+		}
+		close(release)
+		wg.Wait()
+	})
+
+	t.Run("waiting requests can be cancelled", func(t *testing.T) {
+		srv, received, release := newServer(t)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_ = c.Request(context.Background(), srv.URL, NewRequest("{ movie }"), nil)
+		}()
+		<-received
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		err := c.Request(ctx, srv.URL, NewRequest("{ movie }"), nil)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		close(release)
+		<-done
+		assert.Len(t, received, 0, "the cancelled request was waiting for the first one")
+	})
+}
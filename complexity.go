@@ -0,0 +1,174 @@
+package bramble
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/99designs/gqlgen/complexity"
+	"github.com/99designs/gqlgen/graphql/errcode"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+const (
+	complexityExtension    = "complexity"
+	errComplexityLimitCode = "COMPLEXITY_LIMIT_EXCEEDED"
+
+	defaultFieldCost          = 1
+	defaultListSizeMultiplier = 1
+	maxComplexity             = int(^uint(0) >> 1)
+)
+
+// checkComplexity computes the complexity of the operation and returns an
+// error if it exceeds the limit, no limit is enforced when it is 0. The other
+// operation limits should be checked first, as the complexity is computed for
+// every fragment spread. It must be called with the schema lock held.
+func (s *ExecutableSchema) checkComplexity(operation *ast.OperationDefinition, variables map[string]interface{}, limits OperationLimits) (int, gqlerror.List) {
+	cost := complexity.Calculate(complexitySchema{ExecutableSchema: s, schema: s.MergedSchema}, operation, variables)
+	if limits.MaxComplexity == 0 || cost <= limits.MaxComplexity {
+		return cost, nil
+	}
+
+	err := gqlerror.Errorf("operation has complexity %d, which exceeds the limit of %d", cost, limits.MaxComplexity)
+	errcode.Set(err, errComplexityLimitCode)
+	err.Extensions["complexity"] = cost
+	err.Extensions["maxComplexity"] = limits.MaxComplexity
+	return cost, gqlerror.List{err}
+}
+
+// complexitySchema computes the complexity of operations against the merged
+// schema it was created with, without taking the schema lock
+type complexitySchema struct {
+	*ExecutableSchema
+	schema *ast.Schema
+}
+
+// Schema returns the merged schema
+func (s complexitySchema) Schema() *ast.Schema {
+	return s.schema
+}
+
+// Complexity returns the cost of the field
+func (s complexitySchema) Complexity(typeName, fieldName string, childComplexity int, args map[string]interface{}) (int, bool) {
+	return schemaFieldCost(s.schema, typeName, fieldName, childComplexity, args)
+}
+
+// schemaFieldCost returns the cost of the field of the type, false if the
+// schema doesn't define it
+func schemaFieldCost(schema *ast.Schema, typeName, fieldName string, childComplexity int, args map[string]interface{}) (int, bool) {
+	def := schema.Types[typeName]
+	if def == nil {
+		return 0, false
+	}
+	field := def.Fields.ForName(fieldName)
+	if field == nil {
+		return 0, false
+	}
+	return fieldCost(schema, field, childComplexity, args), true
+}
+
+// fieldCost returns the cost of the field: its weight plus the complexity of
+// its selection set, multiplied by the expected size for lists.
+//
+// The weight is declared with @cost(weight: Int!) on the field or on its
+// type, and defaults to 1. The expected size of lists is declared with
+// @listSize(assumedSize: Int, slicingArguments: [String!]), the largest
+// slicing argument is used when provided, otherwise the assumed size.
+func fieldCost(schema *ast.Schema, field *ast.FieldDefinition, childComplexity int, args map[string]interface{}) int {
+	weight, ok := costWeight(field.Directives)
+	if !ok {
+		if def := schema.Types[field.Type.Name()]; def != nil {
+			weight, ok = costWeight(def.Directives)
+		}
+	}
+	if !ok {
+		weight = defaultFieldCost
+	}
+
+	size := defaultListSizeMultiplier
+	if field.Type.Elem != nil {
+		size = listSize(field.Directives, args)
+	}
+
+	return saturatingAdd(weight, saturatingMultiply(size, childComplexity))
+}
+
+func costWeight(directives ast.DirectiveList) (int, bool) {
+	directive := directives.ForName(costDirectiveName)
+	if directive == nil {
+		return 0, false
+	}
+	arg := directive.Arguments.ForName("weight")
+	if arg == nil || arg.Value == nil {
+		return 0, false
+	}
+	weight, err := strconv.Atoi(arg.Value.Raw)
+	if err != nil || weight < 0 {
+		return 0, false
+	}
+	return weight, true
+}
+
+func listSize(directives ast.DirectiveList, args map[string]interface{}) int {
+	directive := directives.ForName(listSizeDirectiveName)
+	if directive == nil {
+		return defaultListSizeMultiplier
+	}
+
+	size, sliced := 0, false
+	if arg := directive.Arguments.ForName("slicingArguments"); arg != nil && arg.Value != nil {
+		for _, child := range arg.Value.Children {
+			if value, ok := intValue(args[child.Value.Raw]); ok {
+				sliced = true
+				if value > size {
+					size = value
+				}
+			}
+		}
+	}
+	if !sliced {
+		arg := directive.Arguments.ForName("assumedSize")
+		if arg == nil || arg.Value == nil {
+			return defaultListSizeMultiplier
+		}
+		size, _ = strconv.Atoi(arg.Value.Raw)
+	}
+
+	if size < 1 {
+		return defaultListSizeMultiplier
+	}
+	return size
+}
+
+// intValue converts an argument or variable value to an int
+func intValue(value interface{}) (int, bool) {
+	switch value := value.(type) {
+	case int64:
+		return int(value), true
+	case int:
+		return value, true
+	case float64:
+		return int(value), true
+	case json.Number:
+		i, err := value.Int64()
+		return int(i), err == nil
+	}
+	return 0, false
+}
+
+func saturatingAdd(a, b int) int {
+	if c := a + b; c >= a {
+		return c
+	}
+	return maxComplexity
+}
+
+func saturatingMultiply(a, b int) int {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	if c := a * b; c/b == a {
+		return c
+	}
+	return maxComplexity
+}
@@ -0,0 +1,136 @@
+package bramble
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2"
+)
+
+func TestComplexity(t *testing.T) {
+	f := &queryExecutionFixture{
+		services: []testService{
+			{
+				schema: `directive @cost(weight: Int!) on FIELD_DEFINITION | OBJECT
+				directive @listSize(assumedSize: Int, slicingArguments: [String!]) on FIELD_DEFINITION
+
+				type Movie @cost(weight: 2) {
+					id: ID!
+					title: String!
+					reviews: [Review!]! @listSize(assumedSize: 5)
+				}
+
+				type Review {
+					text: String!
+				}
+
+				type Query {
+					movies(first: Int, last: Int): [Movie!]! @listSize(slicingArguments: ["first", "last"])
+					movie(id: ID!): Movie @cost(weight: 10)
+					tags: [String!]!
+				}`,
+				handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Write([]byte(`{"data": {"movie": {"title": "Movie 1"}}}`))
+				}),
+			},
+		},
+	}
+	es := f.setup(t)
+
+	complexity := func(query string, variables map[string]interface{}) int {
+		doc := gqlparser.MustLoadQuery(es.MergedSchema, query)
+		cost, err := es.checkComplexity(doc.Operations[0], variables, OperationLimits{MaxComplexity: maxComplexity})
+		require.Nil(t, err)
+		return cost
+	}
+
+	t.Run("no limit", func(t *testing.T) {
+		query := gqlparser.MustLoadQuery(es.MergedSchema, `{ movie(id: "1") { title } }`)
+		cost, err := es.checkComplexity(query.Operations[0], nil, OperationLimits{})
+		assert.Nil(t, err)
+		assert.Equal(t, 11, cost, "the complexity is computed without a limit")
+
+		ctx := testContextWithVariables(nil, query.Operations[0])
+		resp := es.ExecuteQuery(ctx)
+		require.Empty(t, resp.Errors)
+		assert.Equal(t, 11, graphql.GetExtension(ctx, complexityExtension), "the complexity is always reported")
+	})
+
+	t.Run("default cost", func(t *testing.T) {
+		assert.Equal(t, 1, complexity(`{ tags }`, nil))
+	})
+
+	t.Run("field and type weights", func(t *testing.T) {
+		// movie: 10 + title: 1
+		assert.Equal(t, 11, complexity(`{ movie(id: "1") { title } }`, nil))
+	})
+
+	t.Run("list sizes", func(t *testing.T) {
+		// movies: 2 + 3 * (title: 1 + reviews: 1 + 5 * text: 1)
+		assert.Equal(t, 23, complexity(`{ movies(first: 3) { title reviews { text } } }`, nil))
+		// the largest slicing argument is used
+		assert.Equal(t, 2+10*1, complexity(`{ movies(first: 3, last: 10) { title } }`, nil))
+		// variables
+		assert.Equal(t, 2+4*1, complexity(`query($n: Int) { movies(first: $n) { title } }`, map[string]interface{}{"n": int64(4)}))
+		// no slicing argument
+		assert.Equal(t, 2+1*1, complexity(`{ movies { title } }`, nil))
+	})
+
+	t.Run("limit", func(t *testing.T) {
+		query := gqlparser.MustLoadQuery(es.MergedSchema, `{ movie(id: "1") { title } }`)
+		ctx := AddPermissionsToContext(testContextWithVariables(nil, query.Operations[0]), OperationPermissions{
+			AllowedRootQueryFields: AllowedFields{AllowAll: true},
+			Limits:                 OperationLimits{MaxComplexity: 10},
+		})
+		resp := es.ExecuteQuery(ctx)
+		require.Len(t, resp.Errors, 1)
+		assert.Equal(t, "operation has complexity 11, which exceeds the limit of 10", resp.Errors[0].Message)
+		assert.Equal(t, errComplexityLimitCode, resp.Errors[0].Extensions["code"])
+		assert.Equal(t, 11, resp.Errors[0].Extensions["complexity"])
+		assert.Equal(t, 10, resp.Errors[0].Extensions["maxComplexity"])
+		assert.Nil(t, resp.Data)
+		assert.Equal(t, 11, graphql.GetExtension(ctx, complexityExtension))
+	})
+
+	t.Run("role limits override the gateway limits", func(t *testing.T) {
+		es.Limits = OperationLimits{MaxComplexity: 5}
+		defer func() { es.Limits = OperationLimits{} }()
+
+		query := gqlparser.MustLoadQuery(es.MergedSchema, `{ movie(id: "1") { title } }`)
+		ctx := AddPermissionsToContext(testContextWithVariables(nil, query.Operations[0]), OperationPermissions{
+			AllowedRootQueryFields: AllowedFields{AllowAll: true},
+			Limits:                 OperationLimits{MaxComplexity: 20},
+		})
+		resp := es.ExecuteQuery(ctx)
+		require.Empty(t, resp.Errors)
+		assert.JSONEq(t, `{"movie": {"title": "Movie 1"}}`, string(resp.Data))
+		assert.Equal(t, 11, graphql.GetExtension(ctx, complexityExtension))
+
+		ctx = testContextWithVariables(nil, query.Operations[0])
+		resp = es.ExecuteQuery(ctx)
+		require.Len(t, resp.Errors, 1)
+		assert.Equal(t, errComplexityLimitCode, resp.Errors[0].Extensions["code"])
+	})
+}
+
+func TestMergeOperationLimits(t *testing.T) {
+	perms := MergePermissions(
+		OperationPermissions{Limits: OperationLimits{MaxComplexity: 10}},
+		OperationPermissions{Limits: OperationLimits{MaxComplexity: 20}},
+	)
+	assert.Equal(t, 20, perms.Limits.MaxComplexity)
+
+	perms = MergePermissions(
+		OperationPermissions{Limits: OperationLimits{MaxComplexity: 5000}},
+		OperationPermissions{},
+	)
+	assert.Equal(t, 5000, perms.Limits.MaxComplexity, "unset limits are ignored")
+
+	es := &ExecutableSchema{Limits: OperationLimits{MaxComplexity: 1000, MaxDepth: 10}}
+	limits := es.operationLimits(perms, true)
+	assert.Equal(t, 5000, limits.MaxComplexity, "the role limit overrides the stricter gateway limit")
+	assert.Equal(t, 10, limits.MaxDepth, "the gateway limit applies when no role sets it")
+}
@@ -12,6 +12,7 @@ import (
 
 	"github.com/fsnotify/fsnotify"
 	log "github.com/sirupsen/logrus"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
 const (
@@ -66,17 +67,58 @@ type Config struct {
 	PollIntervalDuration   time.Duration
 	MaxRequestsPerQuery    int64 `json:"max-requests-per-query"`
 	MaxServiceResponseSize int64 `json:"max-service-response-size"`
-	Plugins                []PluginConfig
+	PlanCacheSize          int   `json:"plan-cache-size"`
+	// Maximum duration of the execution of queries and mutations, no
+	// timeout when empty
+	OperationTimeout string `json:"operation-timeout"`
+	// Settings of the services, services missing from Services are ignored.
+	// The services given as objects in the config files are added to both.
+	ServiceConfigs []ServiceConfig `json:"-"`
+	// Per-service circuit breakers, disabled when nil
+	CircuitBreaker *CircuitBreakerConfig `json:"circuit-breaker"`
+	// Retry policies for idempotent requests to services, disabled when nil
+	Retry *RetryConfig `json:"retry"`
+	// Coalescing of identical concurrent queries to services, disabled when
+	// nil
+	Coalescing *CoalescingConfig `json:"coalescing"`
+	// Cache of boundary lookup results, disabled when nil
+	EntityCache *EntityCacheConfig `json:"entity-cache"`
+	// Cache-Control headers computed from @cacheControl hints, disabled when
+	// nil
+	CacheControl *CacheControlConfig `json:"cache-control"`
+	// OpenTelemetry tracing, disabled when nil
+	Tracing *TracingConfig `json:"tracing"`
+	// Operation and client labels of the HTTP metrics, disabled when nil
+	Metrics *MetricsConfig `json:"metrics"`
+	// Redaction of the logged request bodies, sampling and slow request
+	// logging, every request is logged in full when nil
+	RequestLogging *RequestLoggingConfig `json:"request-logging"`
+	// Limits enforced on incoming operations, can be overridden per role
+	OperationLimits OperationLimits `json:"operation-limits"`
+	// Automatic persisted queries, disabled when nil
+	PersistedQueries *PersistedQueriesConfig `json:"persisted-queries"`
+	// Trusted documents allowlist, disabled when nil
+	TrustedDocuments *TrustedDocumentsConfig `json:"trusted-documents"`
+	Plugins          []PluginConfig
 	// Config extensions that can be shared among plugins
 	Extensions map[string]json.RawMessage
 	// HTTP client to customize for downstream services query
 	QueryHTTPClient *http.Client
-
-	plugins          []Plugin
-	executableSchema *ExecutableSchema
-	watcher          *fsnotify.Watcher
-	configFiles      []string
-	linkedFiles      []string
+	// Store for automatic persisted queries, takes precedence over the
+	// store described by PersistedQueries
+	PersistedQueryStore PersistedQueryStore `json:"-"`
+
+	plugins               []Plugin
+	operationTimeout      time.Duration
+	tracerProvider        *sdktrace.TracerProvider
+	metricLabels          *MetricLabels
+	requestLogging        *RequestLogging
+	executableSchema      *ExecutableSchema
+	trustedDocuments      *TrustedDocuments
+	watcher               *fsnotify.Watcher
+	configFiles           []string
+	linkedFiles           []string
+	linkedTrustedManifest string
 }
 
 func newConfig() *Config {
@@ -88,6 +130,7 @@ func newConfig() *Config {
 		PollInterval:           defaultPollIntervalString,
 		MaxRequestsPerQuery:    50,
 		MaxServiceResponseSize: defaultMaxServiceResponseSize,
+		PlanCacheSize:          defaultPlanCacheSize,
 	}
 }
 
@@ -127,9 +170,18 @@ func (c *Config) handleConfigFile(f string) error {
 	}
 	defer func() { _ = fp.Close() }()
 
-	if err := json.NewDecoder(fp).Decode(&c); err != nil {
+	// services are either URLs or objects with the settings of the service
+	type plain Config
+	file := struct {
+		*plain
+		Services []ServiceConfig `json:"services"`
+	}{plain: (*plain)(c)}
+	if err := json.NewDecoder(fp).Decode(&file); err != nil {
 		return fmt.Errorf("error decoding config file %q: %w", f, err)
 	}
+	if file.Services != nil {
+		c.setServices(file.Services)
+	}
 	return nil
 }
 
@@ -185,34 +237,72 @@ func (c *Config) load(isReload bool) error {
 		return fmt.Errorf("invalid poll interval: %w", err)
 	}
 
+	c.operationTimeout = 0
+	if c.OperationTimeout != "" {
+		c.operationTimeout, err = time.ParseDuration(c.OperationTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid operation timeout: %w", err)
+		}
+	}
+
 	services, err := c.buildServiceList()
 	if err != nil {
 		return err
 	}
-	c.Services = services
+	for _, service := range services {
+		if _, err := service.settings(); err != nil {
+			return fmt.Errorf("invalid configuration for service %q: %w", service.URL, err)
+		}
+	}
+	c.setServices(services)
 
 	c.plugins = c.ConfigurePlugins()
 
 	return nil
 }
 
-func (c *Config) buildServiceList() ([]string, error) {
-	serviceSet := map[string]bool{}
+// setServices sets both the service URLs and the service settings
+func (c *Config) setServices(services []ServiceConfig) {
+	c.Services = make([]string, 0, len(services))
+	for _, service := range services {
+		c.Services = append(c.Services, service.URL)
+	}
+	c.ServiceConfigs = services
+}
+
+// buildServiceList returns the services from the config files, the
+// environment and the plugins. A service keeps its settings from
+// ServiceConfigs wherever it is listed.
+func (c *Config) buildServiceList() ([]ServiceConfig, error) {
+	settings := map[string]ServiceConfig{}
+	for _, service := range c.ServiceConfigs {
+		settings[service.URL] = service
+	}
+	serviceSet := map[string]ServiceConfig{}
+	addService := func(serviceURL string) {
+		if _, ok := serviceSet[serviceURL]; ok {
+			return
+		}
+		service, ok := settings[serviceURL]
+		if !ok {
+			service = ServiceConfig{URL: serviceURL}
+		}
+		serviceSet[serviceURL] = service
+	}
 	for _, service := range c.Services {
-		serviceSet[service] = true
+		addService(service)
 	}
 	for _, service := range strings.Fields(os.Getenv("BRAMBLE_SERVICE_LIST")) {
-		serviceSet[service] = true
+		addService(service)
 	}
 	for _, plugin := range c.plugins {
 		ok, path := plugin.GraphqlQueryPath()
 		if ok {
-			service := c.PrivateHttpAddress(path)
-			serviceSet[service] = true
+			addService(c.PrivateHttpAddress(path))
 		}
 	}
-	services := []string{}
-	for service := range serviceSet {
+	services := []ServiceConfig{}
+	for _, service := range serviceSet {
 		services = append(services, service)
 	}
 	if len(services) == 0 {
@@ -228,6 +318,11 @@ func (c *Config) Watch() {
 		case err := <-c.watcher.Errors:
 			log.WithError(err).Error("config watch error")
 		case e := <-c.watcher.Events:
+			if c.trustedDocuments != nil && (e.Op == fsnotify.Write || e.Op == fsnotify.Create) &&
+				isTrustedDocumentsManifest(e.Name, c.trustedDocuments.Config().Manifest, &c.linkedTrustedManifest) {
+				c.updateTrustedDocuments()
+			}
+
 			shouldUpdate := false
 			for i := range c.configFiles {
 				log.WithFields(log.Fields{"event": e, "files": c.configFiles, "links": c.linkedFiles}).Debug("received config file event")
@@ -261,7 +356,9 @@ func (c *Config) Watch() {
 				cfgLog.WithError(err).Error("watcher failed reloading config")
 			}
 			cfgLog.WithField("services", c.Services).Info(c.LogLevel, "watcher reloaded configuration")
-			err = c.executableSchema.UpdateServiceList(c.Services)
+			c.updateTrustedDocuments()
+			c.executableSchema.setPollInterval(c.PollIntervalDuration)
+			err = c.executableSchema.UpdateServiceList(c.ServiceConfigs)
 			if err != nil {
 				cfgLog.WithError(err).Error("watcher failed updating services")
 			}
@@ -270,6 +367,35 @@ func (c *Config) Watch() {
 	}
 }
 
+// updateTrustedDocuments applies the trusted documents configuration and
+// reloads the manifest. The allowlist can't be enabled or disabled without a
+// restart.
+func (c *Config) updateTrustedDocuments() {
+	if c.trustedDocuments == nil || c.TrustedDocuments == nil {
+		return
+	}
+	if err := c.trustedDocuments.Update(*c.TrustedDocuments); err != nil {
+		cfgLog.WithError(err).Error("watcher failed reloading trusted documents")
+		return
+	}
+	c.watchTrustedDocumentsManifest()
+	cfgLog.WithField("manifest", c.TrustedDocuments.Manifest).Info("watcher reloaded trusted documents")
+}
+
+// watchTrustedDocumentsManifest watches the manifest directory, so that the
+// manifest is reloaded when it changes.
+func (c *Config) watchTrustedDocumentsManifest() {
+	if c.watcher == nil {
+		return
+	}
+	manifest := c.trustedDocuments.Config().Manifest
+	// watch the directory, else we'll lose the watch if the file is relinked
+	if err := c.watcher.Add(filepath.Dir(manifest)); err != nil {
+		cfgLog.WithError(err).Error("error adding trusted documents manifest to watcher")
+	}
+	c.linkedTrustedManifest, _ = filepath.EvalSymlinks(manifest)
+}
+
 // GetConfig returns operational config for the gateway
 func GetConfig(configFiles []string) (*Config, error) {
 	watcher, err := fsnotify.NewWatcher()
@@ -319,23 +445,82 @@ func (c *Config) ConfigurePlugins() []Plugin {
 
 // Init initializes the config and does an initial fetch of the services.
 func (c *Config) Init() error {
-	var err error
-	c.Services, err = c.buildServiceList()
+	serviceConfigs, err := c.buildServiceList()
 	if err != nil {
 		return fmt.Errorf("error building service list: %w", err)
 	}
+	c.setServices(serviceConfigs)
+
+	if c.Tracing != nil {
+		c.tracerProvider, err = NewTracerProvider(*c.Tracing)
+		if err != nil {
+			return fmt.Errorf("error configuring tracing: %w", err)
+		}
+		setupTracing(c.tracerProvider)
+	}
+	if c.Metrics != nil {
+		c.metricLabels = NewMetricLabels(*c.Metrics)
+	}
+	if c.RequestLogging != nil {
+		c.requestLogging, err = NewRequestLogging(*c.RequestLogging)
+		if err != nil {
+			return fmt.Errorf("error configuring request logging: %w", err)
+		}
+	}
 
 	var services []*Service
-	for _, s := range c.Services {
-		services = append(services, NewService(s))
+	for _, s := range c.ServiceConfigs {
+		service, err := NewServiceWithConfig(s)
+		if err != nil {
+			return err
+		}
+		services = append(services, service)
 	}
 
 	queryClientOptions := []ClientOpt{WithMaxResponseSize(c.MaxServiceResponseSize), WithUserAgent(GenerateUserAgent("query"))}
 	if c.QueryHTTPClient != nil {
 		queryClientOptions = append(queryClientOptions, WithHTTPClient(c.QueryHTTPClient))
 	}
+	if c.CircuitBreaker != nil {
+		breakers, err := NewCircuitBreakers(*c.CircuitBreaker)
+		if err != nil {
+			return fmt.Errorf("error configuring circuit breaker: %w", err)
+		}
+		queryClientOptions = append(queryClientOptions, WithCircuitBreakers(breakers))
+	}
+	if c.Retry != nil {
+		policies, err := NewRetryPolicies(*c.Retry)
+		if err != nil {
+			return fmt.Errorf("error configuring retry policies: %w", err)
+		}
+		queryClientOptions = append(queryClientOptions, WithRetryPolicies(policies))
+	}
+	if c.Coalescing != nil {
+		queryClientOptions = append(queryClientOptions, WithRequestCoalescing(NewRequestCoalescing(*c.Coalescing)))
+	}
 	queryClient := NewClientWithPlugins(c.plugins, queryClientOptions...)
+	if err := queryClient.SetServiceConfigs(c.ServiceConfigs...); err != nil {
+		return err
+	}
 	es := NewExecutableSchema(c.plugins, c.MaxRequestsPerQuery, queryClient, services...)
+	es.PollInterval = c.PollIntervalDuration
+	es.OperationTimeout = c.operationTimeout
+	es.PlanCache = NewPlanCache(c.PlanCacheSize)
+	if c.EntityCache != nil {
+		entityCache, err := NewEntityCache(*c.EntityCache, nil)
+		if err != nil {
+			return fmt.Errorf("error configuring entity cache: %w", err)
+		}
+		es.EntityCache = entityCache
+	}
+	if c.CacheControl != nil {
+		cacheControl, err := NewCacheControl(*c.CacheControl)
+		if err != nil {
+			return fmt.Errorf("error configuring cache control: %w", err)
+		}
+		es.CacheControl = cacheControl
+	}
+	es.Limits = c.OperationLimits
 	err = es.UpdateSchema(true)
 	if err != nil {
 		return err
@@ -343,6 +528,21 @@ func (c *Config) Init() error {
 
 	c.executableSchema = es
 
+	if c.TrustedDocuments != nil {
+		c.trustedDocuments, err = NewTrustedDocuments(*c.TrustedDocuments)
+		if err != nil {
+			return fmt.Errorf("error configuring trusted documents: %w", err)
+		}
+		c.watchTrustedDocumentsManifest()
+	}
+
+	if c.PersistedQueryStore == nil && c.PersistedQueries != nil {
+		c.PersistedQueryStore, err = NewPersistedQueryStore(*c.PersistedQueries)
+		if err != nil {
+			return fmt.Errorf("error configuring persisted queries: %w", err)
+		}
+	}
+
 	var pluginsNames []string
 	for _, plugin := range c.plugins {
 		plugin.Init(c.executableSchema)
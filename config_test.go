@@ -2,7 +2,6 @@ package bramble
 
 import (
 	"fmt"
-	"os"
 	"strconv"
 	"testing"
 
@@ -45,7 +44,11 @@ func TestConfig(t *testing.T) {
 		require.Equal(t, log.DebugLevel, cfg.LogLevel)
 	})
 	t.Run("loglevel set from environment", func(t *testing.T) {
-		require.NoError(t, os.Setenv(envBrambleLogLevel, "ERROR"))
+		// the environment and the log level are global, they are restored so
+		// that the request events logged by other tests aren't filtered out
+		prevLevel := log.GetLevel()
+		t.Cleanup(func() { log.SetLevel(prevLevel) })
+		t.Setenv(envBrambleLogLevel, "ERROR")
 		cfg := newConfig()
 		cfg.Services = []string{"127.0.0.1:9999"}
 		require.NoError(t, cfg.Load())
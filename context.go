@@ -10,6 +10,9 @@ type brambleContextKey int
 
 const permissionsContextKey brambleContextKey = 1
 const requestHeaderContextKey brambleContextKey = 2
+const incrementalDeliveryContextKey brambleContextKey = 3
+const cachePolicyContextKey brambleContextKey = 4
+const serviceRequestLabelsContextKey brambleContextKey = 5
 
 // AddPermissionsToContext adds permissions to the request context. If
 // permissions are set the execution will check them against the query.
@@ -47,3 +50,16 @@ func GetOutgoingRequestHeadersFromContext(ctx context.Context) http.Header {
 	h, _ := ctx.Value(requestHeaderContextKey).(http.Header)
 	return h
 }
+
+// withIncrementalDelivery marks the request as served by a transport able to
+// deliver multiple payloads for a single operation
+func withIncrementalDelivery(ctx context.Context) context.Context {
+	return context.WithValue(ctx, incrementalDeliveryContextKey, true)
+}
+
+// incrementalDeliveryEnabled returns whether @defer and @stream can be
+// honoured for the request
+func incrementalDeliveryEnabled(ctx context.Context) bool {
+	enabled, _ := ctx.Value(incrementalDeliveryContextKey).(bool)
+	return enabled
+}
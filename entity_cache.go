@@ -0,0 +1,225 @@
+package bramble
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+const defaultEntityCacheSize = 10000
+
+// EntityCacheConfig is the configuration of the entity cache
+type EntityCacheConfig struct {
+	// MaxEntries is the maximum number of entities in the in-memory store
+	MaxEntries int `json:"max-entries"`
+	// Types are the max ages of boundary types by type name, they override
+	// the @cacheControl directive of the services. A max age of 0 disables
+	// caching for the type.
+	Types map[string]string `json:"types"`
+}
+
+// EntityCacheStore stores the cached entities. Implementations must be safe
+// for concurrent use.
+type EntityCacheStore interface {
+	// Get returns the value stored for the key, false if there is no value
+	// or it has expired
+	Get(ctx context.Context, key string) ([]byte, bool)
+	// Set stores the value for the key, until the ttl expires
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration)
+}
+
+// EntityCache caches the results of boundary lookups. Entities are keyed by
+// service, type, id and selection set, and cached for the max age of their
+// type: either from the configuration or from the @cacheControl(maxAge:)
+// directive of the type in the service schema. Types without a max age are
+// not cached.
+type EntityCache struct {
+	store   EntityCacheStore
+	maxAges map[string]time.Duration
+}
+
+// NewEntityCache creates the entity cache. The entities are kept in an
+// in-memory store if store is nil.
+func NewEntityCache(cfg EntityCacheConfig, store EntityCacheStore) (*EntityCache, error) {
+	c := &EntityCache{
+		store:   store,
+		maxAges: make(map[string]time.Duration),
+	}
+	for typeName, maxAge := range cfg.Types {
+		d, err := time.ParseDuration(maxAge)
+		if err != nil {
+			return nil, fmt.Errorf("invalid max age for type %q: %w", typeName, err)
+		}
+		c.maxAges[typeName] = d
+	}
+	if c.store == nil {
+		c.store = NewMemoryEntityCacheStore(cfg.MaxEntries)
+	}
+	return c, nil
+}
+
+// maxAge returns how long entities of the boundary type are cached, 0 if
+// they are not cached
+func (c *EntityCache) maxAge(typeName string, field BoundaryField) time.Duration {
+	if c == nil {
+		return 0
+	}
+	if maxAge, ok := c.maxAges[typeName]; ok {
+		return maxAge
+	}
+	return field.MaxAge
+}
+
+// entityCacheLookup holds the cache keys of the entities of a query step
+type entityCacheLookup struct {
+	cache      *EntityCache
+	serviceURL string
+	typeName   string
+	maxAge     time.Duration
+	keyPrefix  string
+}
+
+// lookup returns the lookup of the entities of the step, nil if the step
+// isn't cached
+func (c *EntityCache) lookup(ctx context.Context, schema *ast.Schema, step *QueryPlanStep, field BoundaryField) *entityCacheLookup {
+	maxAge := c.maxAge(step.ParentType, field)
+	if maxAge <= 0 {
+		return nil
+	}
+
+	_, variables := formatOperation(ctx, step.SelectionSet)
+	keyPrefix := hashCacheKey(struct {
+		ServiceURL   string
+		TypeName     string
+		SelectionSet string
+		Variables    map[string]interface{}
+	}{
+		ServiceURL:   step.ServiceURL,
+		TypeName:     step.ParentType,
+		SelectionSet: formatSelectionSetSingleLine(ctx, schema, step.SelectionSet),
+		Variables:    variables,
+	})
+	if keyPrefix == "" {
+		return nil
+	}
+
+	return &entityCacheLookup{
+		cache:      c,
+		serviceURL: step.ServiceURL,
+		typeName:   step.ParentType,
+		maxAge:     maxAge,
+		keyPrefix:  keyPrefix,
+	}
+}
+
+func (l *entityCacheLookup) key(id string) string {
+	return l.keyPrefix + ":" + id
+}
+
+// get returns the cached entities and the ids missing from the cache
+func (l *entityCacheLookup) get(ctx context.Context, ids []string) ([]interface{}, []string) {
+	var (
+		entities []interface{}
+		missing  []string
+	)
+	for _, id := range ids {
+		var entity interface{}
+		if data, ok := l.cache.store.Get(ctx, l.key(id)); ok && json.Unmarshal(data, &entity) == nil {
+			entities = append(entities, entity)
+			continue
+		}
+		missing = append(missing, id)
+	}
+
+	labels := prometheus.Labels{"service": l.serviceURL, "type": l.typeName}
+	promEntityCacheHitCounter.With(labels).Add(float64(len(entities)))
+	promEntityCacheMissCounter.With(labels).Add(float64(len(missing)))
+	return entities, missing
+}
+
+// set caches the entities returned by the service
+func (l *entityCacheLookup) set(ctx context.Context, entities []interface{}) {
+	for _, entity := range entities {
+		obj, ok := entity.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		var id string
+		switch value := obj["_bramble_id"].(type) {
+		case string:
+			id = value
+		case json.Number:
+			id = value.String()
+		case float64:
+			id = strconv.FormatFloat(value, 'f', -1, 64)
+		default:
+			continue
+		}
+		data, err := json.Marshal(obj)
+		if err != nil {
+			continue
+		}
+		l.cache.store.Set(ctx, l.key(id), data, l.maxAge)
+	}
+}
+
+// cacheControlMaxAge returns the max age set by the @cacheControl directive,
+// 0 if there is none or if the scope is private
+func cacheControlMaxAge(directives ast.DirectiveList) time.Duration {
+	maxAge, _, scope := cacheControlHint(directives)
+	if scope == CacheScopePrivate {
+		return 0
+	}
+	return maxAge
+}
+
+// MemoryEntityCacheStore is an in-memory LRU entity cache store
+type MemoryEntityCacheStore struct {
+	cache *lru.Cache
+	now   func() time.Time
+}
+
+type memoryEntityCacheEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+// NewMemoryEntityCacheStore returns an in-memory store holding up to size
+// entities, 10000 when size isn't positive
+func NewMemoryEntityCacheStore(size int) *MemoryEntityCacheStore {
+	if size <= 0 {
+		size = defaultEntityCacheSize
+	}
+	// lru.New only fails when the size isn't positive
+	cache, _ := lru.New(size)
+	return &MemoryEntityCacheStore{cache: cache, now: time.Now}
+}
+
+// Get returns the value stored for the key
+func (s *MemoryEntityCacheStore) Get(_ context.Context, key string) ([]byte, bool) {
+	entry, ok := s.cache.Get(key)
+	if !ok {
+		return nil, false
+	}
+	if !s.now().Before(entry.(memoryEntityCacheEntry).expires) {
+		s.cache.Remove(key)
+		return nil, false
+	}
+	return entry.(memoryEntityCacheEntry).value, true
+}
+
+// Set stores the value for the key
+func (s *MemoryEntityCacheStore) Set(_ context.Context, key string, value []byte, ttl time.Duration) {
+	s.cache.Add(key, memoryEntityCacheEntry{value: value, expires: s.now().Add(ttl)})
+}
+
+// Len returns the number of stored entities, including expired ones
+func (s *MemoryEntityCacheStore) Len() int {
+	return s.cache.Len()
+}
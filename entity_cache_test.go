@@ -0,0 +1,176 @@
+package bramble
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+func TestMemoryEntityCacheStore(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryEntityCacheStore(2)
+	store.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	store.Set(ctx, "a", []byte("1"), time.Minute)
+	value, ok := store.Get(ctx, "a")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("1"), value)
+
+	now = now.Add(time.Minute)
+	_, ok = store.Get(ctx, "a")
+	assert.False(t, ok, "expired entries are removed")
+	assert.Equal(t, 0, store.Len())
+
+	store.Set(ctx, "a", []byte("1"), time.Minute)
+	store.Set(ctx, "b", []byte("2"), time.Minute)
+	store.Set(ctx, "c", []byte("3"), time.Minute)
+	_, ok = store.Get(ctx, "a")
+	assert.False(t, ok, "the least recently used entry is evicted")
+	assert.Equal(t, 2, store.Len())
+
+	for _, size := range []int{0, -1} {
+		store := NewMemoryEntityCacheStore(size)
+		store.Set(ctx, "a", []byte("1"), time.Minute)
+		assert.Equal(t, 1, store.Len(), "the default size is used when the size isn't positive")
+	}
+}
+
+func TestEntityCacheMaxAge(t *testing.T) {
+	schema := gqlparser.MustLoadSchema(&ast.Source{Name: "movies", Input: `
+		directive @boundary on OBJECT | FIELD_DEFINITION
+		directive @cacheControl(maxAge: Int) on OBJECT
+		type Movie @boundary @cacheControl(maxAge: 60) {
+			id: ID!
+		}
+		type Actor @boundary {
+			id: ID!
+		}
+		type Query {
+			movie(id: ID!): Movie @boundary
+			actor(id: ID!): Actor @boundary
+		}`})
+	boundaryFields := buildBoundaryFieldsMap(&Service{ServiceURL: "http://movies", Schema: schema})
+	movie, err := boundaryFields.Field("http://movies", "Movie")
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, movie.MaxAge)
+	actor, err := boundaryFields.Field("http://movies", "Actor")
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(0), actor.MaxAge)
+
+	cache, err := NewEntityCache(EntityCacheConfig{Types: map[string]string{"Actor": "10s", "Movie": "0s"}}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 10*time.Second, cache.maxAge("Actor", actor))
+	assert.Equal(t, time.Duration(0), cache.maxAge("Movie", movie), "the configuration overrides the directive")
+
+	_, err = NewEntityCache(EntityCacheConfig{Types: map[string]string{"Actor": "forever"}}, nil)
+	assert.Error(t, err)
+}
+
+func TestEntityCacheExecution(t *testing.T) {
+	var (
+		mutex           sync.Mutex
+		movieIDs        []string
+		requestedIDs    []string
+		boundaryQueries int
+	)
+	aliasRegexp := regexp.MustCompile(`(_\d+): movie\(id: "(\d+)"\)`)
+	f := &queryExecutionFixture{
+		services: []testService{
+			{
+				schema: `directive @boundary on OBJECT
+				type Movie @boundary {
+					id: ID!
+				}
+
+				type Query {
+					movies: [Movie!]!
+				}`,
+				handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					mutex.Lock()
+					defer mutex.Unlock()
+					var movies []string
+					for _, id := range movieIDs {
+						movies = append(movies, fmt.Sprintf(`{"_bramble_id": %q, "_bramble__typename": "Movie", "id": %q}`, id, id))
+					}
+					fmt.Fprintf(w, `{"data": {"movies": [%s]}}`, strings.Join(movies, ","))
+				}),
+			},
+			{
+				schema: `directive @boundary on OBJECT | FIELD_DEFINITION
+				directive @cacheControl(maxAge: Int) on OBJECT
+				type Movie @boundary @cacheControl(maxAge: 60) {
+					id: ID!
+					title: String!
+				}
+
+				type Query {
+					movie(id: ID!): Movie @boundary
+				}`,
+				handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					var req Request
+					if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+						http.Error(w, err.Error(), http.StatusBadRequest)
+						return
+					}
+					mutex.Lock()
+					boundaryQueries++
+					mutex.Unlock()
+					titleKey := "title"
+					if strings.Contains(req.Query, "t: title") {
+						titleKey = "t"
+					}
+					var results []string
+					for _, match := range aliasRegexp.FindAllStringSubmatch(req.Query, -1) {
+						mutex.Lock()
+						requestedIDs = append(requestedIDs, match[2])
+						mutex.Unlock()
+						results = append(results, fmt.Sprintf(`%q: {"_bramble_id": %q, "_bramble__typename": "Movie", %q: "Movie %s"}`, match[1], match[2], titleKey, match[2]))
+					}
+					fmt.Fprintf(w, `{"data": {%s}}`, strings.Join(results, ","))
+				}),
+			},
+		},
+	}
+	es := f.setup(t)
+	cache, err := NewEntityCache(EntityCacheConfig{}, nil)
+	require.NoError(t, err)
+	es.EntityCache = cache
+
+	execute := func(query string, ids ...string) string {
+		mutex.Lock()
+		movieIDs, requestedIDs, boundaryQueries = ids, nil, 0
+		mutex.Unlock()
+		doc := gqlparser.MustLoadQuery(f.mergedSchema, query)
+		resp := es.ExecuteQuery(testContextWithVariables(nil, doc.Operations[0]))
+		require.Empty(t, resp.Errors)
+		return string(resp.Data)
+	}
+
+	data := execute(`{ movies { id title } }`, "1", "2")
+	assert.JSONEq(t, `{"movies": [{"id": "1", "title": "Movie 1"}, {"id": "2", "title": "Movie 2"}]}`, data)
+	assert.ElementsMatch(t, []string{"1", "2"}, requestedIDs)
+
+	data = execute(`{ movies { id title } }`, "1", "2", "3")
+	assert.JSONEq(t, `{"movies": [{"id": "1", "title": "Movie 1"}, {"id": "2", "title": "Movie 2"}, {"id": "3", "title": "Movie 3"}]}`, data)
+	assert.Equal(t, []string{"3"}, requestedIDs, "only missing ids are fetched")
+
+	data = execute(`{ movies { id title } }`, "3", "1")
+	assert.JSONEq(t, `{"movies": [{"id": "3", "title": "Movie 3"}, {"id": "1", "title": "Movie 1"}]}`, data)
+	assert.Equal(t, 0, boundaryQueries, "no lookup when every id is cached")
+
+	data = execute(`{ movies { id t: title } }`, "1")
+	assert.JSONEq(t, `{"movies": [{"id": "1", "t": "Movie 1"}]}`, data)
+	assert.Equal(t, []string{"1"}, requestedIDs, "entities are cached per selection set")
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"reflect"
 	"sync"
 	"time"
 
@@ -11,6 +12,8 @@ import (
 	log "github.com/sirupsen/logrus"
 	"github.com/vektah/gqlparser/v2/ast"
 	"github.com/vektah/gqlparser/v2/gqlerror"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func NewExecutableSchema(plugins []Plugin, maxRequestsPerQuery int64, client *GraphQLClient, services ...*Service) *ExecutableSchema {
@@ -30,6 +33,8 @@ func NewExecutableSchema(plugins []Plugin, maxRequestsPerQuery int64, client *Gr
 		GraphqlClient:       client,
 		plugins:             plugins,
 		MaxRequestsPerQuery: maxRequestsPerQuery,
+		PlanCache:           NewPlanCache(defaultPlanCacheSize),
+		pollUpdates:         make(chan struct{}, 1),
 	}
 }
 
@@ -42,35 +47,110 @@ type ExecutableSchema struct {
 	BoundaryQueries     BoundaryFieldsMap
 	GraphqlClient       *GraphQLClient
 	MaxRequestsPerQuery int64
+	// Limits are the limits enforced on operations, they can be overridden
+	// per role with the permissions limits
+	Limits OperationLimits
+	// PlanCache caches the query plans, it is purged every time the merged
+	// schema changes. A nil cache disables caching.
+	PlanCache *PlanCache
+	// EntityCache caches the results of boundary lookups, disabled when nil
+	EntityCache *EntityCache
+	// CacheControl computes the cache policy of query responses and caches
+	// public responses, disabled when nil
+	CacheControl *CacheControl
+	// PollInterval is the poll interval of services without their own poll
+	// interval, 0 means services are polled on every update
+	PollInterval time.Duration
+	// OperationTimeout is the maximum duration of the execution of queries
+	// and mutations, including deferred fragments. Requests to services only
+	// get the remaining time. 0 means no timeout.
+	OperationTimeout time.Duration
 
 	mutex   sync.RWMutex
 	plugins []Plugin
+	// pollUpdates is notified when the poll intervals change
+	pollUpdates chan struct{}
 }
 
 // UpdateServiceList replaces the list of services with the provided one and
-// update the schema.
-func (s *ExecutableSchema) UpdateServiceList(services []string) error {
+// update the schema. Services whose configuration changed are recreated.
+func (s *ExecutableSchema) UpdateServiceList(services []ServiceConfig) error {
+	s.mutex.RLock()
+	currentServices := s.Services
+	s.mutex.RUnlock()
+
 	newServices := make(map[string]*Service)
-	for _, svcURL := range services {
-		if svc, ok := s.Services[svcURL]; ok {
-			newServices[svcURL] = svc
-		} else {
-			newServices[svcURL] = NewService(svcURL)
+	for _, cfg := range services {
+		if svc, ok := currentServices[cfg.URL]; ok && reflect.DeepEqual(svc.Config, cfg) {
+			newServices[cfg.URL] = svc
+			continue
+		}
+		svc, err := NewServiceWithConfig(cfg)
+		if err != nil {
+			return err
 		}
+		newServices[cfg.URL] = svc
 	}
+	if err := s.GraphqlClient.SetServiceConfigs(services...); err != nil {
+		return err
+	}
+	s.mutex.Lock()
 	s.Services = newServices
+	s.mutex.Unlock()
+	s.notifyPollUpdate()
 
 	return s.UpdateSchema(true)
 }
 
-// UpdateSchema updates the schema from every service and then update the merged
-// schema.
+// setPollInterval sets the poll interval of services without their own poll
+// interval
+func (s *ExecutableSchema) setPollInterval(interval time.Duration) {
+	s.mutex.Lock()
+	s.PollInterval = interval
+	s.mutex.Unlock()
+	s.notifyPollUpdate()
+}
+
+// notifyPollUpdate wakes the schema poller up so that it reads the poll
+// intervals again
+func (s *ExecutableSchema) notifyPollUpdate() {
+	select {
+	case s.pollUpdates <- struct{}{}:
+	default:
+	}
+}
+
+// schemaPollInterval returns the interval at which services are checked for
+// polling, the shortest of the poll intervals. Polling is disabled when it
+// is 0.
+func (s *ExecutableSchema) schemaPollInterval() time.Duration {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	interval := s.PollInterval
+	for _, svc := range s.Services {
+		if svc.pollInterval > 0 && (interval == 0 || svc.pollInterval < interval) {
+			interval = svc.pollInterval
+		}
+	}
+	return interval
+}
+
+// UpdateSchema updates the schema from every service whose poll interval
+// has elapsed and then update the merged schema. Every service is polled when
+// forceRebuild is true.
 func (s *ExecutableSchema) UpdateSchema(forceRebuild bool) error {
 	var services []*Service
 	var schemas []*ast.Schema
 	var updatedServices []string
 	var invalidSchema bool
 
+	pollAll := forceRebuild
+	now := time.Now()
+
+	s.mutex.RLock()
+	currentServices, pollInterval := s.Services, s.PollInterval
+	s.mutex.RUnlock()
+
 	defer func() {
 		if invalidSchema {
 			promInvalidSchema.Set(1)
@@ -79,30 +159,39 @@ func (s *ExecutableSchema) UpdateSchema(forceRebuild bool) error {
 		}
 	}()
 
-	for url, s := range s.Services {
+	for url, svc := range currentServices {
+		if !pollAll && !svc.pollDue(now, pollInterval) {
+			// keep the schema of the last successful poll
+			if svc.Status == "OK" {
+				services = append(services, svc)
+				schemas = append(schemas, svc.Schema)
+			}
+			continue
+		}
+
 		logger := log.WithField("url", url)
-		updated, err := s.Update()
+		updated, err := svc.Update()
 		if err != nil {
-			promServiceUpdateErrorCounter.WithLabelValues(s.ServiceURL).Inc()
-			promServiceUpdateErrorGauge.WithLabelValues(s.ServiceURL).Set(1)
+			promServiceUpdateErrorCounter.WithLabelValues(svc.ServiceURL).Inc()
+			promServiceUpdateErrorGauge.WithLabelValues(svc.ServiceURL).Set(1)
 			invalidSchema, forceRebuild = true, true
 			logger.WithError(err).Error("unable to update service")
 			// Ignore this service in this update
 			continue
 		}
-		promServiceUpdateErrorGauge.WithLabelValues(s.ServiceURL).Set(0)
+		promServiceUpdateErrorGauge.WithLabelValues(svc.ServiceURL).Set(0)
 		logger = log.WithFields(log.Fields{
-			"version": s.Version,
-			"service": s.Name,
+			"version": svc.Version,
+			"service": svc.Name,
 		})
 
 		if updated {
 			logger.Info("service was updated")
-			updatedServices = append(updatedServices, s.Name)
+			updatedServices = append(updatedServices, svc.Name)
 		}
 
-		services = append(services, s)
-		schemas = append(schemas, s.Schema)
+		services = append(services, svc)
+		schemas = append(schemas, svc.Schema)
 	}
 
 	if len(updatedServices) > 0 || forceRebuild {
@@ -122,6 +211,10 @@ func (s *ExecutableSchema) UpdateSchema(forceRebuild bool) error {
 		s.IsBoundary = isBoundary
 		s.MergedSchema = schema
 		s.BoundaryQueries = boundaryQueries
+		s.PlanCache.Purge()
+		if s.CacheControl != nil {
+			s.CacheControl.ResponseCache.Purge()
+		}
 		s.mutex.Unlock()
 	}
 
@@ -130,10 +223,38 @@ func (s *ExecutableSchema) UpdateSchema(forceRebuild bool) error {
 
 // Exec returns the query execution handler
 func (s *ExecutableSchema) Exec(ctx context.Context) graphql.ResponseHandler {
-	return s.ExecuteQuery
+	if operationCtx := graphql.GetOperationContext(ctx); operationCtx.Operation.Operation == ast.Subscription {
+		return s.ExecuteSubscription(ctx)
+	}
+
+	// streaming transports call the handler until it returns nil, queries
+	// and mutations produce a single response unless they use @defer or
+	// @stream
+	var executed bool
+	var execution *incrementalExecution
+	return func(ctx context.Context) *graphql.Response {
+		if !executed {
+			executed = true
+			var response *graphql.Response
+			response, execution = s.executeQuery(ctx)
+			return response
+		}
+		if execution == nil {
+			return nil
+		}
+		return execution.next(ctx)
+	}
 }
 
 func (s *ExecutableSchema) ExecuteQuery(ctx context.Context) *graphql.Response {
+	response, _ := s.executeQuery(ctx)
+	return response
+}
+
+// executeQuery executes the query and returns the initial response. When the
+// transport supports incremental delivery, the deferred fragments and
+// streamed lists are delivered by the returned incremental execution.
+func (s *ExecutableSchema) executeQuery(ctx context.Context) (*graphql.Response, *incrementalExecution) {
 	operationCtx := graphql.GetOperationContext(ctx)
 	operation := operationCtx.Operation
 	variables := operationCtx.Variables
@@ -145,6 +266,14 @@ func (s *ExecutableSchema) ExecuteQuery(ctx context.Context) *graphql.Response {
 	AddField(ctx, "operation.name", operation.Name)
 	AddField(ctx, "operation.type", operation.Operation)
 
+	traceTiming(ctx, "parse", operationCtx.Stats.Parsing)
+	traceTiming(ctx, "validate", operationCtx.Stats.Validation)
+	ctx, span := tracer.Start(ctx, "operation", trace.WithAttributes(
+		attribute.String("graphql.operation.name", operation.Name),
+		attribute.String("graphql.operation.type", string(operation.Operation)),
+	))
+	defer span.End()
+
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 
@@ -160,16 +289,32 @@ func (s *ExecutableSchema) ExecuteQuery(ctx context.Context) *graphql.Response {
 		errs = perms.FilterAuthorizedFields(operation)
 	}
 
-	plan, err := Plan(&PlanningContext{
-		Operation:  operation,
-		Schema:     filteredSchema,
-		Locations:  s.Locations,
-		IsBoundary: s.IsBoundary,
-		Services:   s.Services,
-	})
+	limits := s.operationLimits(perms, hasPerms)
+	limitErrs := checkOperationLimits(operation, limits)
+	if len(limitErrs) == 0 {
+		var cost int
+		cost, limitErrs = s.checkComplexity(operation, variables, limits)
+		graphql.RegisterExtension(ctx, complexityExtension, cost)
+	}
+	if len(limitErrs) > 0 {
+		AddField(ctx, "errors", limitErrs)
+		return s.interceptResponse(ctx, operation.Name, operationCtx.RawQuery, variables, &graphql.Response{
+			Errors: limitErrs,
+		}), nil
+	}
+
+	responseCacheKey, cached := s.cachedResponse(ctx, operationCtx, perms, hasPerms)
+	if cached != nil {
+		return s.interceptResponse(ctx, operation.Name, operationCtx.RawQuery, variables, cached), nil
+	}
+
+	_, planSpan := tracer.Start(ctx, "plan")
+	plan, err := s.plan(operationCtx, operation, filteredSchema, perms, hasPerms)
+	endSpan(planSpan, err)
 
 	if err != nil {
-		return s.interceptResponse(ctx, operation.Name, operationCtx.RawQuery, variables, graphql.ErrorResponse(ctx, err.Error()))
+		AddField(ctx, "errors", err.Error())
+		return s.interceptResponse(ctx, operation.Name, operationCtx.RawQuery, variables, graphql.ErrorResponse(ctx, err.Error())), nil
 	}
 
 	extensions := make(map[string]interface{})
@@ -187,6 +332,9 @@ func (s *ExecutableSchema) ExecuteQuery(ctx context.Context) *graphql.Response {
 		if debugInfo.Timing {
 			extensions["timings"] = timings
 		}
+		if id, ok := traceID(ctx); debugInfo.TraceID && ok {
+			extensions["traceid"] = id
+		}
 	}
 
 	for name, value := range extensions {
@@ -195,12 +343,40 @@ func (s *ExecutableSchema) ExecuteQuery(ctx context.Context) *graphql.Response {
 
 	executionStart := time.Now()
 
-	qe := newQueryExecution(ctx, operationCtx.OperationName, s.GraphqlClient, filteredSchema, s.BoundaryQueries, int32(s.MaxRequestsPerQuery))
+	incremental := incrementalDeliveryEnabled(ctx) && (len(plan.DeferredFragments) > 0 || len(plan.StreamedFields) > 0)
+	selectionSet := operation.SelectionSet
+	if incremental {
+		selectionSet = removeDeferredFragments(selectionSet)
+	}
+
+	var execution *incrementalExecution
+	executionCtx, cancel := s.withOperationTimeout(ctx)
+	defer func() {
+		// the incremental execution cancels the context once everything
+		// is delivered
+		if execution == nil {
+			cancel()
+		}
+	}()
+
+	qe := newQueryExecution(executionCtx, operationCtx.OperationName, s.GraphqlClient, filteredSchema, s.BoundaryQueries, int32(s.MaxRequestsPerQuery))
+	qe.entityCache = s.EntityCache
+	if incremental {
+		qe.deferral = newDeferral(executionCtx)
+	}
+	if recordsSlowFields(ctx) {
+		qe.stepTimings = &stepTimings{}
+		addSlowFields(ctx, EventFields{
+			"plan":  loggedQueryPlan{plan: plan},
+			"steps": qe.stepTimings,
+		})
+	}
 	results, executeErrs := qe.Execute(plan)
 	if len(executeErrs) > 0 {
+		AddField(ctx, "errors", executeErrs)
 		return s.interceptResponse(ctx, operation.Name, operationCtx.RawQuery, variables, &graphql.Response{
 			Errors: executeErrs,
-		})
+		}), nil
 	}
 
 	for _, result := range results {
@@ -222,16 +398,21 @@ func (s *ExecutableSchema) ExecuteQuery(ctx context.Context) *graphql.Response {
 	timings["execution"] = time.Since(executionStart).Round(time.Millisecond).String()
 
 	mergeStart := time.Now()
+	_, mergeSpan := tracer.Start(ctx, "merge")
 	mergedResult, err := mergeExecutionResults(results)
+	endSpan(mergeSpan, err)
 	if err != nil {
 		errs = append(errs, &gqlerror.Error{Message: err.Error()})
 		AddField(ctx, "errors", errs)
 		return s.interceptResponse(ctx, operation.Name, operationCtx.RawQuery, variables, &graphql.Response{
 			Errors: errs,
-		})
+		}), nil
 	}
+	errs = append(errs, resolveEntityErrorPaths(mergedResult, results)...)
 
-	bubbleErrs, err := bubbleUpNullValuesInPlace(filteredSchema, operation.SelectionSet, mergedResult)
+	_, bubbleSpan := tracer.Start(ctx, "bubble")
+	bubbleErrs, err := bubbleUpNullValuesInPlace(filteredSchema, selectionSet, mergedResult)
+	bubbleSpan.End()
 	if err == errNullBubbledToRoot {
 		mergedResult = nil
 	} else if err != nil {
@@ -239,24 +420,263 @@ func (s *ExecutableSchema) ExecuteQuery(ctx context.Context) *graphql.Response {
 		AddField(ctx, "errors", errs)
 		return s.interceptResponse(ctx, operation.Name, operationCtx.RawQuery, variables, &graphql.Response{
 			Errors: errs,
-		})
+		}), nil
 	}
 
 	errs = append(errs, bubbleErrs...)
 	timings["merge"] = time.Since(mergeStart).Round(time.Millisecond).String()
 
 	formattingStart := time.Now()
-	formattedResponse := formatResponseData(filteredSchema, operation.SelectionSet, mergedResult)
+	_, formatSpan := tracer.Start(ctx, "format")
+	responseData := mergedResult
+	if incremental && mergedResult != nil {
+		execution = newIncrementalExecution(filteredSchema, qe, plan.DeferredFragments, mergedResult)
+		execution.cancel = cancel
+		responseData = execution.splitStreamedFields(plan.StreamedFields, variables)
+	}
+	formattedResponse := formatResponseData(filteredSchema, selectionSet, responseData)
+	formatSpan.End()
 	timings["format"] = time.Since(formattingStart).Round(time.Millisecond).String()
 
 	if len(errs) > 0 {
 		AddField(ctx, "errors", errs)
 	}
 
+	if execution != nil {
+		execution.start(nil)
+		graphql.RegisterExtension(ctx, hasNextExtension, execution.hasNext())
+	}
+
+	s.cacheResponse(ctx, filteredSchema, operation, responseCacheKey, formattedResponse, errs, execution != nil)
+
 	return s.interceptResponse(ctx, operation.Name, operationCtx.RawQuery, variables, &graphql.Response{
 		Data:   formattedResponse,
 		Errors: errs,
+	}), execution
+}
+
+// cachedResponse returns the response cache key of the operation and the
+// cached response, if any
+func (s *ExecutableSchema) cachedResponse(ctx context.Context, operationCtx *graphql.OperationContext, perms OperationPermissions, hasPerms bool) (string, *graphql.Response) {
+	if s.CacheControl == nil || s.CacheControl.ResponseCache == nil ||
+		operationCtx.Operation.Operation != ast.Query || incrementalDeliveryEnabled(ctx) {
+		return "", nil
+	}
+
+	var fingerprint *OperationPermissions
+	if hasPerms {
+		fingerprint = &perms
+	}
+	key := responseCacheKey(operationCtx, fingerprint)
+	data, policy, ok := s.CacheControl.ResponseCache.get(key)
+	if !ok {
+		return key, nil
+	}
+	setCachePolicy(ctx, policy)
+	return key, &graphql.Response{Data: data}
+}
+
+// cacheResponse sets the cache policy of the response, and caches the
+// response if the policy allows it. Only complete query responses without
+// errors can be cached.
+func (s *ExecutableSchema) cacheResponse(ctx context.Context, schema *ast.Schema, operation *ast.OperationDefinition, key string, data json.RawMessage, errs gqlerror.List, incremental bool) {
+	if s.CacheControl == nil {
+		return
+	}
+	var policy CachePolicy
+	if operation.Operation == ast.Query && len(errs) == 0 && !incremental {
+		policy = s.CacheControl.policy(schema, operation.SelectionSet)
+	}
+	setCachePolicy(ctx, policy)
+	s.CacheControl.ResponseCache.add(key, data, policy)
+}
+
+// withOperationTimeout returns the context of the execution of an operation,
+// with a deadline when an operation timeout is set
+func (s *ExecutableSchema) withOperationTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.OperationTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, s.OperationTimeout)
+}
+
+// plan returns the plan of the operation, from the plan cache when possible.
+// It must be called while holding the schema lock, so that plans built
+// against a previous schema can't be added after the cache is purged.
+func (s *ExecutableSchema) plan(operationCtx *graphql.OperationContext, operation *ast.OperationDefinition, schema *ast.Schema, perms OperationPermissions, hasPerms bool) (*QueryPlan, error) {
+	var key string
+	if s.PlanCache != nil {
+		var fingerprint *OperationPermissions
+		if hasPerms {
+			fingerprint = &perms
+		}
+		key = planCacheKey(operationCtx, fingerprint)
+		if plan, ok := s.PlanCache.Get(key); ok {
+			return plan, nil
+		}
+	}
+
+	plan, err := Plan(&PlanningContext{
+		Operation:  operation,
+		Schema:     schema,
+		Locations:  s.Locations,
+		IsBoundary: s.IsBoundary,
+		Services:   s.Services,
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.PlanCache.Add(key, plan)
+	return plan, nil
+}
+
+// ExecuteSubscription opens the subscription on the service owning the root
+// field. The returned handler blocks until the next event is received, and
+// returns the event data completed with the boundary fields from other
+// services. It returns nil once the subscription is over.
+func (s *ExecutableSchema) ExecuteSubscription(ctx context.Context) graphql.ResponseHandler {
+	operationCtx := graphql.GetOperationContext(ctx)
+	operation := operationCtx.Operation
+	variables := operationCtx.Variables
+
+	for _, plugin := range s.plugins {
+		plugin.InterceptRequest(ctx, operation.Name, operationCtx.RawQuery, variables)
+	}
+
+	AddField(ctx, "operation.name", operation.Name)
+	AddField(ctx, "operation.type", operation.Operation)
+
+	// the subscription can be long lived, so we only hold the lock while
+	// planning to avoid blocking schema updates
+	s.mutex.RLock()
+	operation = s.evaluateSkipAndInclude(variables, operation)
+	filteredSchema := s.MergedSchema
+
+	var errs gqlerror.List
+	perms, hasPerms := GetPermissionsFromContext(ctx)
+	if hasPerms {
+		filteredSchema = perms.FilterSchema(s.MergedSchema)
+		errs = perms.FilterAuthorizedFields(operation)
+	}
+
+	var cost int
+	limits := s.operationLimits(perms, hasPerms)
+	limitErrs := checkOperationLimits(operation, limits)
+	if len(limitErrs) == 0 {
+		cost, limitErrs = s.checkComplexity(operation, variables, limits)
+	}
+	if len(limitErrs) > 0 {
+		s.mutex.RUnlock()
+		AddField(ctx, "errors", limitErrs)
+		return graphql.OneShot(s.interceptResponse(ctx, operation.Name, operationCtx.RawQuery, variables, &graphql.Response{
+			Errors: limitErrs,
+		}))
+	}
+
+	plan, err := s.plan(operationCtx, operation, filteredSchema, perms, hasPerms)
+	boundaryQueries := s.BoundaryQueries
+	s.mutex.RUnlock()
+
+	if err != nil {
+		return graphql.OneShot(s.interceptResponse(ctx, operation.Name, operationCtx.RawQuery, variables, graphql.ErrorResponse(ctx, err.Error())))
+	}
+
+	if len(plan.RootSteps) == 0 && len(errs) > 0 {
+		AddField(ctx, "errors", errs)
+		return graphql.OneShot(s.interceptResponse(ctx, operation.Name, operationCtx.RawQuery, variables, &graphql.Response{
+			Errors: errs,
+		}))
+	}
+
+	if len(plan.RootSteps) != 1 || plan.RootSteps[0].ServiceURL == internalServiceName {
+		return graphql.OneShot(s.interceptResponse(ctx, operation.Name, operationCtx.RawQuery, variables, graphql.ErrorResponse(ctx, "subscriptions must select a single root field")))
+	}
+
+	step := plan.RootSteps[0]
+	document, documentVariables := formatDocument(ctx, filteredSchema, step.ParentType, step.SelectionSet)
+	req := NewRequest(document).
+		WithVariables(documentVariables).
+		WithHeaders(GetOutgoingRequestHeadersFromContext(ctx)).
+		WithOperationName(operationCtx.OperationName)
+
+	events, err := s.GraphqlClient.Subscribe(ctx, step.ServiceURL, req)
+	if err != nil {
+		return graphql.OneShot(s.interceptResponse(ctx, operation.Name, operationCtx.RawQuery, variables, &graphql.Response{
+			Errors: append(errs, newQueryExecution(ctx, operationCtx.OperationName, s.GraphqlClient, filteredSchema, boundaryQueries, 0).createGQLErrors(step, err)...),
+		}))
+	}
+
+	extensions := map[string]interface{}{
+		complexityExtension: cost,
+	}
+	if debugInfo, ok := ctx.Value(DebugKey).(DebugInfo); ok {
+		if debugInfo.Query {
+			extensions["query"] = operation
+		}
+		if debugInfo.Variables {
+			extensions["variables"] = variables
+		}
+		if debugInfo.Plan {
+			extensions["plan"] = plan
+		}
+	}
+
+	return func(ctx context.Context) *graphql.Response {
+		var event SubscriptionEvent
+		select {
+		case <-ctx.Done():
+			return nil
+		case e, ok := <-events:
+			if !ok {
+				return nil
+			}
+			event = e
+		}
+
+		for name, value := range extensions {
+			graphql.RegisterExtension(ctx, name, value)
+		}
+
+		qe := newQueryExecution(ctx, operationCtx.OperationName, s.GraphqlClient, filteredSchema, boundaryQueries, int32(s.MaxRequestsPerQuery))
+		qe.entityCache = s.EntityCache
+		results, executeErrs := qe.ExecuteEvent(step, event.Data, event.Err)
+		if len(executeErrs) > 0 {
+			return s.interceptResponse(ctx, operation.Name, operationCtx.RawQuery, variables, &graphql.Response{
+				Errors: executeErrs,
+			})
+		}
+
+		eventErrs := append(gqlerror.List{}, errs...)
+		for _, result := range results {
+			eventErrs = append(eventErrs, result.Errors...)
+		}
+
+		mergedResult, err := mergeExecutionResults(results)
+		if err != nil {
+			eventErrs = append(eventErrs, &gqlerror.Error{Message: err.Error()})
+			return s.interceptResponse(ctx, operation.Name, operationCtx.RawQuery, variables, &graphql.Response{
+				Errors: eventErrs,
+			})
+		}
+		eventErrs = append(eventErrs, resolveEntityErrorPaths(mergedResult, results)...)
+
+		bubbleErrs, err := bubbleUpNullValuesInPlace(filteredSchema, operation.SelectionSet, mergedResult)
+		if err == errNullBubbledToRoot {
+			mergedResult = nil
+		} else if err != nil {
+			eventErrs = append(eventErrs, &gqlerror.Error{Message: err.Error()})
+			return s.interceptResponse(ctx, operation.Name, operationCtx.RawQuery, variables, &graphql.Response{
+				Errors: eventErrs,
+			})
+		}
+		eventErrs = append(eventErrs, bubbleErrs...)
+
+		return s.interceptResponse(ctx, operation.Name, operationCtx.RawQuery, variables, &graphql.Response{
+			Data:   formatResponseData(filteredSchema, operation.SelectionSet, mergedResult),
+			Errors: eventErrs,
+		})
+	}
 }
 
 func (s *ExecutableSchema) interceptResponse(ctx context.Context, operationName, rawQuery string, variables map[string]interface{}, response *graphql.Response) *graphql.Response {
@@ -268,13 +688,17 @@ func (s *ExecutableSchema) interceptResponse(ctx context.Context, operationName,
 
 // Schema returns the merged schema
 func (s *ExecutableSchema) Schema() *ast.Schema {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
 	return s.MergedSchema
 }
 
-// Complexity returns the query complexity (unimplemented)
+// Complexity returns the cost of the field, computed from the @cost and
+// @listSize directives declared by services (see fieldCost)
 func (s *ExecutableSchema) Complexity(typeName, fieldName string, childComplexity int, args map[string]interface{}) (int, bool) {
-	// FIXME: TBD
-	return 0, false
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return schemaFieldCost(s.MergedSchema, typeName, fieldName, childComplexity, args)
 }
 
 func resolveIntrospectionFields(ctx context.Context, selectionSet ast.SelectionSet, filteredSchema *ast.Schema) map[string]interface{} {
@@ -684,7 +1108,7 @@ func (s *ExecutableSchema) evaluateSkipAndIncludeRec(vars map[string]interface{}
 					Alias:            selection.Alias,
 					Name:             selection.Name,
 					Arguments:        selection.Arguments,
-					Directives:       removeSkipAndInclude(selection.Directives),
+					Directives:       removeSkipAndInclude(vars, selection.Directives),
 					SelectionSet:     s.evaluateSkipAndIncludeRec(vars, selection.SelectionSet),
 					Position:         selection.Position,
 					Definition:       selection.Definition,
@@ -693,7 +1117,7 @@ func (s *ExecutableSchema) evaluateSkipAndIncludeRec(vars map[string]interface{}
 			case *ast.InlineFragment:
 				result = append(result, &ast.InlineFragment{
 					TypeCondition:    selection.TypeCondition,
-					Directives:       removeSkipAndInclude(selection.Directives),
+					Directives:       removeSkipAndInclude(vars, selection.Directives),
 					SelectionSet:     s.evaluateSkipAndIncludeRec(vars, selection.SelectionSet),
 					Position:         selection.Position,
 					ObjectDefinition: selection.ObjectDefinition,
@@ -701,14 +1125,14 @@ func (s *ExecutableSchema) evaluateSkipAndIncludeRec(vars map[string]interface{}
 			case *ast.FragmentSpread:
 				result = append(result, &ast.FragmentSpread{
 					Name:             selection.Name,
-					Directives:       removeSkipAndInclude(selection.Directives),
+					Directives:       removeSkipAndInclude(vars, selection.Directives),
 					Position:         selection.Position,
 					ObjectDefinition: selection.ObjectDefinition,
 					Definition: &ast.FragmentDefinition{
 						Name:               selection.Definition.Name,
 						VariableDefinition: selection.Definition.VariableDefinition,
 						TypeCondition:      selection.Definition.TypeCondition,
-						Directives:         removeSkipAndInclude(selection.Definition.Directives),
+						Directives:         removeSkipAndInclude(vars, selection.Definition.Directives),
 						SelectionSet:       s.evaluateSkipAndIncludeRec(vars, selection.Definition.SelectionSet),
 						Definition:         selection.Definition.Definition,
 						Position:           selection.Definition.Position,
@@ -720,12 +1144,16 @@ func (s *ExecutableSchema) evaluateSkipAndIncludeRec(vars map[string]interface{}
 	return result
 }
 
-func removeSkipAndInclude(directives ast.DirectiveList) ast.DirectiveList {
+func removeSkipAndInclude(vars map[string]interface{}, directives ast.DirectiveList) ast.DirectiveList {
 	var result ast.DirectiveList
 	for _, d := range directives {
 		if d.Name == "include" || d.Name == "skip" {
 			continue
 		}
+		// @defer and @stream are ignored when their "if" argument is false
+		if (d.Name == deferDirectiveName || d.Name == streamDirectiveName) && d.Arguments.ForName("if") != nil && !resolveIfArgument(d, vars) {
+			continue
+		}
 		result = append(result, d)
 	}
 	return result
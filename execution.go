@@ -4,12 +4,20 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
+	"os"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/vektah/gqlparser/v2/ast"
 	"github.com/vektah/gqlparser/v2/gqlerror"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -20,27 +28,48 @@ type executionResult struct {
 	InsertionPoint []string
 	Data           interface{}
 	Errors         gqlerror.List
+
+	// entityErrors are the errors of boundary lookups, their paths are
+	// resolved once the results are merged
+	entityErrors []entityError
 }
 
 type queryExecution struct {
 	ctx            context.Context
 	operationName  string
+	operationType  string
 	schema         *ast.Schema
-	requestCount   int32
+	requestCount   *int32
 	maxRequest     int32
 	graphqlClient  *GraphQLClient
 	boundaryFields BoundaryFieldsMap
+	// entityCache caches the results of boundary lookups, disabled when nil
+	entityCache *EntityCache
 
 	group   *errgroup.Group
 	results chan executionResult
+
+	// deferral holds the steps of deferred fragments until the initial
+	// response is sent, if nil deferred steps are executed right away
+	deferral *deferral
+	// fragment is the deferred fragment being executed, if any
+	fragment *DeferredFragment
+	// stepTimings records the durations of the steps, disabled when nil
+	stepTimings *stepTimings
 }
 
 func newQueryExecution(ctx context.Context, operationName string, client *GraphQLClient, schema *ast.Schema, boundaryFields BoundaryFieldsMap, maxRequest int32) *queryExecution {
+	var operationType string
+	if graphql.HasOperationContext(ctx) && graphql.GetOperationContext(ctx).Operation != nil {
+		operationType = string(graphql.GetOperationContext(ctx).Operation.Operation)
+	}
 	group, ctx := errgroup.WithContext(ctx)
 	return &queryExecution{
 		ctx:            ctx,
 		operationName:  operationName,
+		operationType:  operationType,
 		schema:         schema,
+		requestCount:   new(int32),
 		graphqlClient:  client,
 		boundaryFields: boundaryFields,
 		maxRequest:     maxRequest,
@@ -50,9 +79,11 @@ func newQueryExecution(ctx context.Context, operationName string, client *GraphQ
 }
 
 func (q *queryExecution) Execute(queryPlan *QueryPlan) ([]executionResult, gqlerror.List) {
-	wg := &sync.WaitGroup{}
 	results := []executionResult{}
 
+	// mutation root steps are executed one after another, in plan order.
+	// The child steps of a mutation are executed before the next one starts.
+	var serialSteps []*QueryPlanStep
 	for _, step := range queryPlan.RootSteps {
 		if step.ServiceURL == internalServiceName {
 			r, err := executeBrambleStep(step)
@@ -63,12 +94,56 @@ func (q *queryExecution) Execute(queryPlan *QueryPlan) ([]executionResult, gqler
 			continue
 		}
 
+		if step.ParentType == mutationObjectName {
+			serialSteps = append(serialSteps, step)
+			continue
+		}
+
 		step := step
 		q.group.Go(func() error {
 			return q.executeRootStep(step)
 		})
 	}
 
+	if len(serialSteps) > 0 {
+		q.group.Go(func() error {
+			for _, step := range serialSteps {
+				if err := q.executeSerialStep(step); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	return q.collectResults(results)
+}
+
+// executeSerialStep executes a mutation root step and waits for its child
+// steps, so that they don't observe the effects of the next mutations
+func (q *queryExecution) executeSerialStep(step *QueryPlanStep) error {
+	group, ctx := errgroup.WithContext(q.ctx)
+	execution := *q
+	execution.ctx = ctx
+	execution.group = group
+	group.Go(func() error {
+		return execution.executeRootStep(step)
+	})
+	return group.Wait()
+}
+
+// ExecuteEvent executes the child steps of a subscription root step using the
+// data received for a single subscription event.
+func (q *queryExecution) ExecuteEvent(step *QueryPlanStep, data map[string]interface{}, err error) ([]executionResult, gqlerror.List) {
+	q.group.Go(func() error {
+		return q.processRootStepResult(step, data, err)
+	})
+
+	return q.collectResults([]executionResult{})
+}
+
+func (q *queryExecution) collectResults(results []executionResult) ([]executionResult, gqlerror.List) {
+	wg := &sync.WaitGroup{}
 	wg.Add(1)
 	go func() {
 		for result := range q.results {
@@ -90,6 +165,10 @@ func (q *queryExecution) Execute(queryPlan *QueryPlan) ([]executionResult, gqler
 }
 
 func (q *queryExecution) executeRootStep(step *QueryPlanStep) error {
+	q, span := q.traceStep(step)
+	defer span.End()
+	defer q.stepTimings.record(step, time.Now())
+
 	var document string
 
 	var variables map[string]interface{}
@@ -101,7 +180,14 @@ func (q *queryExecution) executeRootStep(step *QueryPlanStep) error {
 	}
 
 	var data map[string]interface{}
-	err := q.executeDocument(document, variables, step.ServiceURL, &data)
+	// mutations are not idempotent and are never retried
+	err := q.executeDocument(step, document, variables, &data, step.ParentType == queryObjectName)
+	return q.processRootStepResult(step, data, err)
+}
+
+// processRootStepResult writes the result of a root step and starts the
+// execution of its child steps.
+func (q *queryExecution) processRootStepResult(step *QueryPlanStep, data map[string]interface{}, err error) error {
 	if err != nil {
 		q.writeExecutionResult(step, data, err)
 		return nil
@@ -112,9 +198,10 @@ func (q *queryExecution) executeRootStep(step *QueryPlanStep) error {
 	for _, childStep := range step.Then {
 		boundaryIDs, err := extractAndDedupeBoundaryIDs(data, childStep.InsertionPoint, childStep.ParentType)
 		if err != nil {
-			return err
+			q.writeExecutionResult(childStep, nil, err)
+			continue
 		}
-		if len(boundaryIDs) == 0 {
+		if len(boundaryIDs) == 0 || q.deferStep(childStep, boundaryIDs) {
 			continue
 		}
 
@@ -126,15 +213,104 @@ func (q *queryExecution) executeRootStep(step *QueryPlanStep) error {
 	return nil
 }
 
-func (q *queryExecution) executeDocument(query string, variables map[string]interface{}, serviceURL string, response interface{}) error {
+// executeDocument sends the document of the step to its service, idempotent
+// documents are retried according to the retry policy of the service.
+func (q *queryExecution) executeDocument(step *QueryPlanStep, query string, variables map[string]interface{}, response interface{}, idempotent bool) error {
 	req := NewRequest(query).
 		WithVariables(variables).
 		WithHeaders(GetOutgoingRequestHeadersFromContext(q.ctx)).
 		WithOperationName(q.operationName)
-	return q.graphqlClient.Request(q.ctx, serviceURL, req, &response)
+
+	ctx := context.WithValue(q.ctx, serviceRequestLabelsContextKey, serviceRequestLabels(step, q.operationType))
+	if idempotent {
+		return q.graphqlClient.RequestWithRetry(ctx, step.ServiceURL, req, &response)
+	}
+	return q.graphqlClient.Request(ctx, step.ServiceURL, req, &response)
+}
+
+// metricServiceName returns the name of the service of the step, or its URL
+// for services without a name
+func metricServiceName(step *QueryPlanStep) string {
+	if step.ServiceName != "" {
+		return step.ServiceName
+	}
+	return step.ServiceURL
+}
+
+// serviceRequestLabels returns the metric labels of a request sent for the
+// step, the outcome is added once the request is done
+func serviceRequestLabels(step *QueryPlanStep, operationType string) prometheus.Labels {
+	kind := "root"
+	if len(step.InsertionPoint) > 0 {
+		kind = "boundary"
+	}
+	return prometheus.Labels{
+		"service":        metricServiceName(step),
+		"kind":           kind,
+		"operation_type": operationType,
+	}
+}
+
+// observeServiceRequest records a request sent to a service with the labels
+// of the context, requests sent without labels aren't recorded
+func observeServiceRequest(ctx context.Context, start time.Time, statusCode int, err error) {
+	labels, ok := ctx.Value(serviceRequestLabelsContextKey).(prometheus.Labels)
+	if !ok {
+		return
+	}
+	outcome := serviceRequestOutcome(err)
+	if err == nil && statusCode >= http.StatusBadRequest {
+		outcome = "error"
+	}
+	requestLabels := prometheus.Labels{"outcome": outcome}
+	for name, value := range labels {
+		requestLabels[name] = value
+	}
+	promServiceRequestCounter.With(requestLabels).Inc()
+	promServiceRequestDurations.With(requestLabels).Observe(time.Since(start).Seconds())
+}
+
+// serviceRequestOutcome classifies the result of a request to a service
+func serviceRequestOutcome(err error) string {
+	var (
+		gqlErrs    GraphqlErrors
+		circuitErr *CircuitOpenError
+	)
+	switch {
+	case err == nil:
+		return "success"
+	case errors.As(err, &gqlErrs):
+		return "graphql_error"
+	case errors.As(err, &circuitErr):
+		return "circuit_open"
+	case errors.Is(err, context.DeadlineExceeded) || os.IsTimeout(err):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	default:
+		return "error"
+	}
+}
+
+// observeBoundaryIDs records the number of boundary ids fetched by every
+// request of a boundary lookup
+func observeBoundaryIDs(step *QueryPlanStep, ids []string, batchSize int) {
+	observer := promServiceBoundaryIDs.With(prometheus.Labels{
+		"service": metricServiceName(step),
+		"type":    step.ParentType,
+	})
+	for _, batch := range batchBy(ids, batchSize) {
+		observer.Observe(float64(len(batch)))
+	}
 }
 
 func (q *queryExecution) writeExecutionResult(step *QueryPlanStep, data interface{}, err error) {
+	q.writeBoundaryExecutionResult(step, data, err, nil)
+}
+
+// writeBoundaryExecutionResult writes the result of a boundary lookup, errors
+// returned for a single entity are recorded as entity errors
+func (q *queryExecution) writeBoundaryExecutionResult(step *QueryPlanStep, data interface{}, err error, boundaryIDs []string) {
 	result := executionResult{
 		ServiceURL:     step.ServiceURL,
 		InsertionPoint: step.InsertionPoint,
@@ -142,34 +318,80 @@ func (q *queryExecution) writeExecutionResult(step *QueryPlanStep, data interfac
 	}
 	if err != nil {
 		result.Errors = q.createGQLErrors(step, err)
+		span := trace.SpanFromContext(q.ctx)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	var gqlErrs GraphqlErrors
+	if len(boundaryIDs) > 0 && errors.As(err, &gqlErrs) && len(gqlErrs) == len(result.Errors) {
+		for i, gqlErr := range gqlErrs {
+			if entityErr, ok := boundaryEntityError(result.Errors[i], gqlErr.Path, boundaryIDs); ok {
+				entityErr.path = clientErrorPath(step.SelectionSet, entityErr.path)
+				result.entityErrors = append(result.entityErrors, entityErr)
+			}
+		}
 	}
 
 	q.results <- result
 }
 
+// executeChildStep executes a boundary step and starts the execution of its
+// child steps. Errors are written as the result of the step they affect, so
+// that they only null the fields of that step and the rest of the response is
+// still returned.
 func (q *queryExecution) executeChildStep(step *QueryPlanStep, boundaryIDs []string) error {
-	newRequestCount := atomic.AddInt32(&q.requestCount, 1)
+	q, span := q.traceStep(step)
+	defer span.End()
+	defer q.stepTimings.record(step, time.Now())
+	span.SetAttributes(attribute.Int("bramble.step.boundary_ids", len(boundaryIDs)))
+
+	newRequestCount := atomic.AddInt32(q.requestCount, 1)
 	if newRequestCount > q.maxRequest {
-		return fmt.Errorf("exceeded max requests of %v", q.maxRequest)
+		q.writeExecutionResult(step, nil, fmt.Errorf("exceeded max requests of %v", q.maxRequest))
+		return nil
 	}
 
 	boundaryField, err := q.boundaryFields.Field(step.ServiceURL, step.ParentType)
 	if err != nil {
-		return err
+		q.writeExecutionResult(step, nil, err)
+		return nil
 	}
 
-	documents, variables, err := buildBoundaryQueryDocuments(q.ctx, q.schema, step, boundaryIDs, boundaryField, 50)
-	if err != nil {
-		return err
+	var cachedData []interface{}
+	cacheLookup := q.entityCache.lookup(q.ctx, q.schema, step, boundaryField)
+	if cacheLookup != nil {
+		cachedData, boundaryIDs = cacheLookup.get(q.ctx, boundaryIDs)
 	}
 
-	data, err := q.executeBoundaryQuery(documents, step.ServiceURL, variables, boundaryField)
-	if err != nil {
-		q.writeExecutionResult(step, data, err)
-		return nil
+	var (
+		data      []interface{}
+		lookupErr error
+	)
+	if len(boundaryIDs) > 0 {
+		batchSize := q.graphqlClient.boundaryBatchSize(step.ServiceURL, step.ParentType)
+		documents, variables, err := buildBoundaryQueryDocuments(q.ctx, q.schema, step, boundaryIDs, boundaryField, batchSize)
+		if err != nil {
+			q.writeExecutionResult(step, nil, err)
+			return nil
+		}
+		observeBoundaryIDs(step, boundaryIDs, batchSize)
+
+		data, lookupErr = q.executeBoundaryQuery(step, documents, variables, boundaryField)
+		// GraphQL errors come with the data of the entities that could be
+		// resolved, that data is kept but never cached
+		var gqlErrs GraphqlErrors
+		if lookupErr != nil && !errors.As(lookupErr, &gqlErrs) {
+			q.writeExecutionResult(step, data, lookupErr)
+			return nil
+		}
+		if lookupErr == nil && cacheLookup != nil {
+			cacheLookup.set(q.ctx, data)
+		}
 	}
+	data = append(cachedData, data...)
 
-	q.writeExecutionResult(step, data, nil)
+	q.writeBoundaryExecutionResult(step, data, lookupErr, boundaryIDs)
 
 	nonNilBoundaryResults := extractNonNilBoundaryResults(data)
 
@@ -177,13 +399,15 @@ func (q *queryExecution) executeChildStep(step *QueryPlanStep, boundaryIDs []str
 		for _, childStep := range step.Then {
 			boundaryResultInsertionPoint, err := trimInsertionPointForNestedBoundaryStep(nonNilBoundaryResults, childStep.InsertionPoint)
 			if err != nil {
-				return err
+				q.writeExecutionResult(childStep, nil, err)
+				continue
 			}
 			boundaryIDs, err := extractAndDedupeBoundaryIDs(nonNilBoundaryResults, boundaryResultInsertionPoint, childStep.ParentType)
 			if err != nil {
-				return err
+				q.writeExecutionResult(childStep, nil, err)
+				continue
 			}
-			if len(boundaryIDs) == 0 {
+			if len(boundaryIDs) == 0 || q.deferStep(childStep, boundaryIDs) {
 				continue
 			}
 			childStep := childStep
@@ -196,6 +420,51 @@ func (q *queryExecution) executeChildStep(step *QueryPlanStep, boundaryIDs []str
 	return nil
 }
 
+// traceStep starts the span of the execution of the step. The returned
+// execution uses the context of the span, so that downstream requests and
+// child steps are part of it.
+func (q *queryExecution) traceStep(step *QueryPlanStep) (*queryExecution, trace.Span) {
+	ctx, span := tracer.Start(q.ctx, "step", trace.WithAttributes(
+		attribute.String("bramble.service.url", step.ServiceURL),
+		attribute.String("bramble.service.name", step.ServiceName),
+		attribute.String("bramble.step.parent_type", step.ParentType),
+		attribute.StringSlice("bramble.step.insertion_point", step.InsertionPoint),
+	))
+	execution := *q
+	execution.ctx = ctx
+	return &execution, span
+}
+
+// deferStep holds the step if it belongs to a deferred fragment other than
+// the one being executed. It returns false if the step should be executed now.
+func (q *queryExecution) deferStep(step *QueryPlanStep, boundaryIDs []string) bool {
+	if q.deferral == nil || step.Defer == nil || step.Defer == q.fragment {
+		return false
+	}
+	q.deferral.add(step, boundaryIDs)
+	return true
+}
+
+// executeDeferredFragment executes the steps held for the fragment. The
+// execution shares the request budget of the query.
+func (q *queryExecution) executeDeferredFragment(fragment *DeferredFragment) ([]executionResult, gqlerror.List) {
+	group, ctx := errgroup.WithContext(q.deferral.ctx)
+	execution := *q
+	execution.ctx = ctx
+	execution.group = group
+	execution.results = make(chan executionResult)
+	execution.fragment = fragment
+
+	for _, deferred := range q.deferral.take(fragment) {
+		deferred := deferred
+		group.Go(func() error {
+			return execution.executeChildStep(deferred.step, deferred.boundaryIDs)
+		})
+	}
+
+	return execution.collectResults([]executionResult{})
+}
+
 func extractNonNilBoundaryResults(data []interface{}) []interface{} {
 	var nonNilResults []interface{}
 	for _, d := range data {
@@ -208,32 +477,85 @@ func extractNonNilBoundaryResults(data []interface{}) []interface{} {
 	return nonNilResults
 }
 
-func (q *queryExecution) executeBoundaryQuery(documents []string, serviceURL string, variables map[string]interface{}, boundaryFieldGetter BoundaryField) ([]interface{}, error) {
+func (q *queryExecution) executeBoundaryQuery(step *QueryPlanStep, documents []string, variables map[string]interface{}, boundaryFieldGetter BoundaryField) ([]interface{}, error) {
+	results, err := q.executeBoundaryDocuments(step, documents, variables)
+	if !boundaryFieldGetter.Array || results == nil {
+		return results, err
+	}
+
+	// every document of an array boundary field returns the list of the
+	// entities of its batch under _result
 	output := make([]interface{}, 0)
-	if !boundaryFieldGetter.Array {
-		for _, document := range documents {
-			partialData := make(map[string]interface{})
-			err := q.executeDocument(document, variables, serviceURL, &partialData)
-			if err != nil {
-				return nil, err
-			}
-			for _, value := range partialData {
-				output = append(output, value)
-			}
+	for _, result := range results {
+		if result == nil {
+			continue
+		}
+		entities, ok := result.([]interface{})
+		if !ok {
+			return nil, errors.New("array boundary field lookups should return a list")
 		}
-		return output, nil
+		output = append(output, entities...)
 	}
+	return output, err
+}
 
-	if len(documents) != 1 {
-		return nil, errors.New("there should only be a single document for array boundary field lookups")
+// executeBoundaryDocuments sends the documents of a boundary lookup
+// concurrently, up to the boundary concurrency of the service. The results
+// are returned in the order of the documents.
+func (q *queryExecution) executeBoundaryDocuments(step *QueryPlanStep, documents []string, variables map[string]interface{}) ([]interface{}, error) {
+	var (
+		mutex   sync.Mutex
+		gqlErrs GraphqlErrors
+	)
+	results := make([]map[string]interface{}, len(documents))
+	group, ctx := errgroup.WithContext(q.ctx)
+	execution := *q
+	execution.ctx = ctx
+	semaphore := make(chan struct{}, q.graphqlClient.boundaryConcurrency(step.ServiceURL))
+
+documents:
+	for i, document := range documents {
+		i, document := i, document
+		select {
+		case semaphore <- struct{}{}:
+		case <-ctx.Done():
+			break documents
+		}
+		group.Go(func() error {
+			defer func() { <-semaphore }()
+			partialData := make(map[string]interface{})
+			if err := execution.executeDocument(step, document, variables, &partialData, true); err != nil {
+				// GraphQL errors don't fail the other documents, the data
+				// returned alongside them is kept
+				var documentErrs GraphqlErrors
+				if !errors.As(err, &documentErrs) {
+					return err
+				}
+				mutex.Lock()
+				gqlErrs = append(gqlErrs, documentErrs...)
+				mutex.Unlock()
+			}
+			results[i] = partialData
+			return nil
+		})
+	}
+	if err := group.Wait(); err != nil {
+		return nil, err
+	}
+	if err := q.ctx.Err(); err != nil {
+		return nil, err
 	}
 
-	data := struct {
-		Result []interface{} `json:"_result"`
-	}{}
-
-	err := q.executeDocument(documents[0], variables, serviceURL, &data)
-	return data.Result, err
+	output := make([]interface{}, 0)
+	for _, partialData := range results {
+		for _, value := range partialData {
+			output = append(output, value)
+		}
+	}
+	if len(gqlErrs) > 0 {
+		return output, gqlErrs
+	}
+	return output, nil
 }
 
 func (q *queryExecution) createGQLErrors(step *QueryPlanStep, err error) gqlerror.List {
@@ -269,28 +591,80 @@ func (q *queryExecution) createGQLErrors(step *QueryPlanStep, err error) gqlerro
 			extensions["serviceName"] = step.ServiceName
 			extensions["serviceUrl"] = step.ServiceURL
 
+			// paths of boundary steps are relative to the boundary lookup,
+			// they are set once the entity is found in the merged result
+			var errPath ast.Path
+			if len(step.InsertionPoint) == 0 {
+				errPath = clientErrorPath(step.SelectionSet, ge.Path)
+			}
+
 			outputErrs = append(outputErrs, &gqlerror.Error{
 				Message:    ge.Message,
-				Path:       ge.Path,
+				Path:       errPath,
 				Locations:  locs,
 				Extensions: extensions,
 			})
 		}
 		return outputErrs
 	} else {
+		extensions := map[string]interface{}{
+			"selectionSet": formatSelectionSetSingleLine(q.ctx, q.schema, step.SelectionSet),
+		}
+		var circuitErr *CircuitOpenError
+		if errors.As(err, &circuitErr) {
+			extensions["code"] = errCircuitOpenCode
+			extensions["serviceName"] = step.ServiceName
+			extensions["serviceUrl"] = step.ServiceURL
+		}
 		outputErrs = append(outputErrs, &gqlerror.Error{
-			Message:   err.Error(),
-			Path:      path,
-			Locations: locs,
-			Extensions: map[string]interface{}{
-				"selectionSet": formatSelectionSetSingleLine(q.ctx, q.schema, step.SelectionSet),
-			},
+			Message:    err.Error(),
+			Path:       path,
+			Locations:  locs,
+			Extensions: extensions,
 		})
 	}
 
 	return outputErrs
 }
 
+// clientErrorPath rewrites the path of a downstream error, relative to the
+// selection set sent to the service, into the coordinates of the client
+// operation. Reserved aliases are replaced with the alias of the field
+// selected by the client, or cut if the client didn't select that field.
+func clientErrorPath(selectionSet ast.SelectionSet, path ast.Path) ast.Path {
+	if len(path) == 0 {
+		return path
+	}
+
+	result := ast.Path{}
+	for _, element := range path {
+		name, ok := element.(ast.PathName)
+		if !ok {
+			result = append(result, element)
+			continue
+		}
+
+		if fieldName, ok := reservedAliases[string(name)]; ok {
+			for _, field := range selectionSetToFields(selectionSet) {
+				if _, reserved := reservedAliases[field.Alias]; !reserved && field.Name == fieldName {
+					return append(result, ast.PathName(field.Alias))
+				}
+			}
+			return result
+		}
+
+		result = append(result, name)
+		var selections ast.SelectionSet
+		for _, field := range selectionSetToFields(selectionSet) {
+			if field.Alias == string(name) {
+				selections = append(selections, field.SelectionSet...)
+			}
+		}
+		selectionSet = selections
+	}
+	return result
+}
+
 // The insertionPoint represents the level a piece of data should be inserted at, relative to the root of the root step's data.
 // However results from a boundary query only contain a portion of that tree. For example, you could
 // have insertionPoint: ["foo", "bar", "movies", "movie", "compTitles"], with the below example as the boundary result we're
@@ -371,14 +745,15 @@ func extractAndDedupeBoundaryIDs(data interface{}, insertionPoint []string, pare
 	if err != nil {
 		return nil, err
 	}
+	// ids are kept in the order they appear in the data
 	dedupeMap := make(map[string]struct{}, len(boundaryIDs))
+	deduped := make([]string, 0, len(boundaryIDs))
 	for _, boundaryID := range boundaryIDs {
+		if _, ok := dedupeMap[boundaryID]; ok {
+			continue
+		}
 		dedupeMap[boundaryID] = struct{}{}
-	}
-
-	deduped := make([]string, 0, len(boundaryIDs))
-	for id := range dedupeMap {
-		deduped = append(deduped, id)
+		deduped = append(deduped, boundaryID)
 	}
 
 	return deduped, nil
@@ -439,20 +814,21 @@ func buildBoundaryQueryDocuments(ctx context.Context, schema *ast.Schema, step *
 	operation, variables := formatOperation(ctx, step.SelectionSet)
 
 	selectionSetQL := formatSelectionSetSingleLine(ctx, schema, step.SelectionSet)
-	if parentTypeBoundaryField.Array {
-		var qids []string
-		for _, id := range ids {
-			qids = append(qids, fmt.Sprintf("%q", id))
-		}
-		idsQL := fmt.Sprintf("[%s]", strings.Join(qids, ", "))
-		return []string{fmt.Sprintf(`query %s { _result: %s(%s: %s) %s }`, operation, parentTypeBoundaryField.Field, parentTypeBoundaryField.Argument, idsQL, selectionSetQL)}, variables, nil
-	}
-
 	var (
 		documents      []string
 		selectionIndex int
 	)
 	for _, batch := range batchBy(ids, batchSize) {
+		if parentTypeBoundaryField.Array {
+			var qids []string
+			for _, id := range batch {
+				qids = append(qids, fmt.Sprintf("%q", id))
+			}
+			idsQL := fmt.Sprintf("[%s]", strings.Join(qids, ", "))
+			documents = append(documents, fmt.Sprintf(`query %s { _result: %s(%s: %s) %s }`, operation, parentTypeBoundaryField.Field, parentTypeBoundaryField.Argument, idsQL, selectionSetQL))
+			continue
+		}
+
 		var selections []string
 		for _, id := range batch {
 			selection := fmt.Sprintf("%s: %s(%s: %q) %s", fmt.Sprintf("_%d", selectionIndex), parentTypeBoundaryField.Field, parentTypeBoundaryField.Argument, id, selectionSetQL)
@@ -54,10 +54,18 @@ func TestBuildBoundaryQueryDocuments(t *testing.T) {
 	}
 	expected := []string{`query operationName { _result: getOwners(ids: ["1", "2", "3"]) { _bramble_id: id name } }`}
 	ctx := testContextWithoutVariables(&ast.OperationDefinition{Name: "operationName"})
-	docs, vars, err := buildBoundaryQueryDocuments(ctx, schema, step, ids, boundaryField, 1)
+	docs, vars, err := buildBoundaryQueryDocuments(ctx, schema, step, ids, boundaryField, 10)
 	require.NoError(t, err)
 	require.Equal(t, expected, docs)
 	require.Equal(t, (map[string]interface{})(nil), vars)
+
+	expected = []string{
+		`query operationName { _result: getOwners(ids: ["1", "2"]) { _bramble_id: id name } }`,
+		`query operationName { _result: getOwners(ids: ["3"]) { _bramble_id: id name } }`,
+	}
+	docs, _, err = buildBoundaryQueryDocuments(ctx, schema, step, ids, boundaryField, 2)
+	require.NoError(t, err)
+	require.Equal(t, expected, docs)
 }
 
 func TestBuildBoundaryQueryDocumentsWithVariables(t *testing.T) {
@@ -95,7 +103,7 @@ func TestBuildBoundaryQueryDocumentsWithVariables(t *testing.T) {
 	}
 	expected := []string{`query ($format: String) { _result: getOwners(ids: ["1", "2", "3"]) { _bramble_id: id name(format: $format) } }`}
 	ctx := testContextWithVariables(map[string]interface{}{"format": "upper"}, query.Operations[0])
-	docs, vars, err := buildBoundaryQueryDocuments(ctx, schema, step, ids, boundaryField, 1)
+	docs, vars, err := buildBoundaryQueryDocuments(ctx, schema, step, ids, boundaryField, 10)
 	require.NoError(t, err)
 	require.Equal(t, expected, docs)
 	require.Equal(t, map[string]interface{}{"format": "upper"}, vars)
@@ -419,6 +419,46 @@ func TestIntrospectionQuery(t *testing.T) {
 					  }
 					}
 				  ]
+				},
+				{
+				  "name": "defer",
+				  "args": [
+					{
+					  "name": "label",
+					  "type": {
+						"name": "String"
+					  }
+					},
+					{
+					  "name": "if",
+					  "type": {
+						"name": null
+					  }
+					}
+				  ]
+				},
+				{
+				  "name": "stream",
+				  "args": [
+					{
+					  "name": "label",
+					  "type": {
+						"name": "String"
+					  }
+					},
+					{
+					  "name": "if",
+					  "type": {
+						"name": null
+					  }
+					},
+					{
+					  "name": "initialCount",
+					  "type": {
+						"name": "Int"
+					  }
+					}
+				  ]
 				}
 			  ]
 			}
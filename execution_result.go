@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"strconv"
 	"strings"
 
 	"github.com/vektah/gqlparser/v2/ast"
@@ -166,6 +167,72 @@ func boundaryTypeFromMap(boundaryMap map[string]interface{}) (string, error) {
 	return "", fmt.Errorf(`boundaryTypeFromMap: "_bramble__typename" not found`)
 }
 
+// entityError is an error returned by a boundary lookup for a single entity
+type entityError struct {
+	err *gqlerror.Error
+	// id is the id of the entity
+	id string
+	// path is the path of the error relative to the entity
+	path ast.Path
+}
+
+// boundaryEntityError returns the entity error of a GraphQL error returned by
+// a boundary lookup. Entities are looked up with "_N" aliases, or at index N
+// of "_result" for array boundary fields, N being the index of their id.
+func boundaryEntityError(err *gqlerror.Error, path ast.Path, boundaryIDs []string) (entityError, bool) {
+	if len(path) == 0 {
+		return entityError{}, false
+	}
+	index := -1
+	rest := path[1:]
+	switch name := path[0].(type) {
+	case ast.PathName:
+		if name == "_result" && len(rest) > 0 {
+			if i, ok := rest[0].(ast.PathIndex); ok {
+				index, rest = int(i), rest[1:]
+			}
+		} else if strings.HasPrefix(string(name), "_") {
+			if i, err := strconv.Atoi(string(name[1:])); err == nil {
+				index = i
+			}
+		}
+	}
+	if index < 0 || index >= len(boundaryIDs) {
+		return entityError{}, false
+	}
+	return entityError{err: err, id: boundaryIDs[index], path: rest}, true
+}
+
+// resolveEntityErrorPaths sets the path of entity errors to the path of the
+// entity in the response, followed by the path of the error within the
+// entity. If the entity appears several times in the response, a copy of the
+// error is returned for every other occurrence.
+func resolveEntityErrorPaths(data interface{}, results []executionResult) gqlerror.List {
+	var errs gqlerror.List
+	for _, result := range results {
+		for _, entityErr := range result.entityErrors {
+			var paths []ast.Path
+			forEachObjectAtPath(data, result.InsertionPoint, ast.Path{}, func(path ast.Path, object map[string]interface{}) {
+				if id, err := boundaryIDFromMap(object); err == nil && id == entityErr.id {
+					paths = append(paths, append(path, entityErr.path...))
+				}
+			})
+			if len(paths) == 0 {
+				continue
+			}
+
+			delete(entityErr.err.Extensions, "selectionPath")
+			entityErr.err.Path = paths[0]
+			for _, path := range paths[1:] {
+				errCopy := *entityErr.err
+				errCopy.Path = path
+				errs = append(errs, &errCopy)
+			}
+		}
+	}
+	return errs
+}
+
 func getBoundaryFieldResults(src []interface{}) ([]map[string]interface{}, error) {
 	var results []map[string]interface{}
 	for i, element := range src {
@@ -335,7 +402,7 @@ func formatResponseDataRec(schema *ast.Schema, selectionSet ast.SelectionSet, re
 				fieldData, ok := result[field.Alias]
 				if !ok {
 					innerBuf.WriteString("null")
-				} else if field.SelectionSet != nil && len(field.SelectionSet) > 0 {
+				} else if field.SelectionSet != nil {
 					val := formatResponseDataRec(schema, field.SelectionSet, fieldData, false)
 					innerBuf.Write(val)
 				} else {
@@ -4,16 +4,22 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/99designs/gqlgen/graphql"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/vektah/gqlparser/v2"
@@ -821,6 +827,92 @@ func TestQueryExecutionServiceTimeout(t *testing.T) {
 	jsonEqWithOrder(t, f.expected, string(f.resp.Data))
 }
 
+func TestQueryExecutionOperationTimeout(t *testing.T) {
+	rootTimeout := make(chan string, 1)
+	boundaryTimeout := make(chan string, 1)
+	release := make(chan struct{})
+	f := &queryExecutionFixture{
+		services: []testService{
+			{
+				schema: `directive @boundary on OBJECT
+				type Movie @boundary {
+					id: ID!
+					title: String
+				}
+
+				type Query {
+					movie(id: ID!): Movie!
+				}`,
+				handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					rootTimeout <- r.Header.Get(timeoutHeader)
+					w.Write([]byte(`{
+						"data": {
+							"movie": {
+								"_bramble_id": "1",
+								"_bramble__typename": "Movie",
+								"id": "1",
+								"title": "Test title"
+							}
+						}
+					}`))
+				}),
+			},
+			{
+				schema: `directive @boundary on OBJECT | FIELD_DEFINITION
+				type Movie @boundary {
+					id: ID!
+					slowField: String
+				}
+
+				type Query {
+					movie(id: ID!): Movie! @boundary
+				}`,
+				handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					boundaryTimeout <- r.Header.Get(timeoutHeader)
+					// the response is only sent once the deadline of the
+					// operation is exceeded
+					<-release
+					w.Write([]byte(`{
+						"data": {
+							"_0": {
+								"_bramble_id": "1",
+								"_bramble__typename": "Movie",
+								"slowField": "very slow field"
+							}
+						}
+					}`))
+				}),
+			},
+		},
+		query: `{
+			movie(id: "1") {
+				id
+				title
+				slowField
+			}
+		}`,
+	}
+
+	es := f.setup(t)
+	es.OperationTimeout = 200 * time.Millisecond
+
+	query := gqlparser.MustLoadQuery(f.mergedSchema, f.query)
+	resp := es.ExecuteQuery(testContextWithVariables(nil, query.Operations[0]))
+	close(release)
+	require.Len(t, resp.Errors, 1)
+	assert.Contains(t, resp.Errors[0].Message, "context deadline exceeded")
+	jsonEqWithOrder(t, `{"movie": {"id": "1", "title": "Test title", "slowField": null}}`, string(resp.Data))
+
+	// the child step only gets the time left after the root step
+	root, err := strconv.Atoi(<-rootTimeout)
+	require.NoError(t, err)
+	boundary, err := strconv.Atoi(<-boundaryTimeout)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, root, 200)
+	assert.Greater(t, boundary, 0)
+	assert.LessOrEqual(t, boundary, root)
+}
+
 func TestQueryExecutionNamespaceAndFragmentSpread(t *testing.T) {
 	f := &queryExecutionFixture{
 		services: []testService{
@@ -2703,6 +2795,410 @@ func TestMutationExecution(t *testing.T) {
 	f.checkSuccess(t)
 }
 
+func TestMutationExecutionIsSerial(t *testing.T) {
+	var (
+		mutex sync.Mutex
+		log   []string
+	)
+	handler := func(service string, delay time.Duration) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req Request
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			mutex.Lock()
+			log = append(log, "start "+service)
+			mutex.Unlock()
+
+			time.Sleep(delay)
+			doc := gqlparser.MustLoadQuery(gqlparser.MustLoadSchema(&ast.Source{Input: `
+				type Query { version: String }
+				type Mutation {
+					createMovie(title: String): String
+					deleteMovie(id: ID!): String
+					rateMovie(id: ID!, rating: Int): String
+				}`}), req.Query)
+			data := make(map[string]string)
+			for _, field := range selectionSetToFields(doc.Operations[0].SelectionSet) {
+				data[field.Alias] = field.Name
+			}
+
+			mutex.Lock()
+			log = append(log, "end "+service)
+			mutex.Unlock()
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+		})
+	}
+	f := &queryExecutionFixture{
+		services: []testService{
+			{
+				schema: `type Query { movies: [String!] }
+				type Mutation {
+					createMovie(title: String): String
+					deleteMovie(id: ID!): String
+				}`,
+				handler: handler("A", 50*time.Millisecond),
+			},
+			{
+				schema: `type Query { ratings: [Int!] }
+				type Mutation {
+					rateMovie(id: ID!, rating: Int): String
+				}`,
+				handler: handler("B", 0),
+			},
+		},
+	}
+	es := f.setup(t)
+
+	query := gqlparser.MustLoadQuery(f.mergedSchema, `mutation {
+		c: deleteMovie(id: "1")
+		b: rateMovie(id: "1", rating: 5)
+		a: createMovie(title: "a")
+	}`)
+	resp := es.ExecuteQuery(testContextWithVariables(nil, query.Operations[0]))
+	require.Empty(t, resp.Errors)
+
+	assert.Equal(t, []string{"start A", "end A", "start B", "end B", "start A", "end A"}, log)
+	assert.Equal(t, `{"c":"deleteMovie","b":"rateMovie","a":"createMovie"}`, strings.Join(strings.Fields(string(resp.Data)), ""))
+}
+
+func TestMutationExecutionWaitsForChildSteps(t *testing.T) {
+	var (
+		mutex        sync.Mutex
+		rating       int
+		lookupServed bool
+	)
+	f := &queryExecutionFixture{
+		services: []testService{
+			{
+				schema: `directive @boundary on OBJECT
+				type Movie @boundary {
+					id: ID!
+					title: String
+				}
+				type Query {
+					movie(id: ID!): Movie!
+				}
+				type Mutation {
+					createMovie(title: String): Movie!
+				}`,
+				handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Write([]byte(`{"data": {"createMovie": {"_bramble_id": "1", "_bramble__typename": "Movie", "title": "a"}}}`))
+				}),
+			},
+			{
+				schema: `directive @boundary on OBJECT | FIELD_DEFINITION
+				type Movie @boundary {
+					id: ID!
+					rating: Int
+				}
+				type Query {
+					movie(id: ID!): Movie @boundary
+				}
+				type Mutation {
+					rateMovie(id: ID!, rating: Int!): Int
+				}`,
+				handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					var req Request
+					require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+					mutex.Lock()
+					defer mutex.Unlock()
+					if strings.Contains(req.Query, "rateMovie") {
+						assert.True(t, lookupServed, "the boundary lookup of the first mutation is done before the second mutation")
+						rating = 5
+						w.Write([]byte(`{"data": {"rateMovie": 5}}`))
+						return
+					}
+					lookupServed = true
+					fmt.Fprintf(w, `{"data": {"_0": {"_bramble_id": "1", "_bramble__typename": "Movie", "rating": %d}}}`, rating)
+				}),
+			},
+		},
+	}
+	es := f.setup(t)
+
+	query := gqlparser.MustLoadQuery(f.mergedSchema, `mutation {
+		createMovie(title: "a") { title rating }
+		rateMovie(id: "1", rating: 5)
+	}`)
+	resp := es.ExecuteQuery(testContextWithVariables(nil, query.Operations[0]))
+	require.Empty(t, resp.Errors)
+	jsonEqWithOrder(t, `{"createMovie": {"title": "a", "rating": 0}, "rateMovie": 5}`, string(resp.Data))
+}
+
+func TestQueryExecutionChildStepErrorReturnsPartialResult(t *testing.T) {
+	f := &queryExecutionFixture{
+		services: []testService{
+			{
+				schema: `directive @boundary on OBJECT
+				type Movie @boundary {
+					id: ID!
+					title: String
+				}
+				type Query {
+					movie(id: ID!): Movie!
+				}`,
+				handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Write([]byte(`{
+						"data": {
+							"movie": {
+								"_bramble_id": "1",
+								"_bramble__typename": "Movie",
+								"id": "1",
+								"title": "Test title"
+							}
+						}
+					}`))
+				}),
+			},
+			{
+				schema: `directive @boundary on OBJECT | FIELD_DEFINITION
+				type Movie @boundary {
+					id: ID!
+					release: Int
+					director: String!
+				}
+				type Query {
+					movie(id: ID!): Movie @boundary
+				}`,
+				handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					t.Error("the boundary service should not be queried")
+				}),
+			},
+		},
+	}
+	es := f.setup(t)
+	es.MaxRequestsPerQuery = 0
+
+	query := gqlparser.MustLoadQuery(f.mergedSchema, `{ movie(id: "1") { title release } }`)
+	resp := es.ExecuteQuery(testContextWithVariables(nil, query.Operations[0]))
+	jsonEqWithOrder(t, `{"movie": {"title": "Test title", "release": null}}`, string(resp.Data))
+	require.Len(t, resp.Errors, 1)
+	assert.Equal(t, "exceeded max requests of 0", resp.Errors[0].Message)
+	assert.Equal(t, ast.Path{ast.PathName("movie")}, resp.Errors[0].Path)
+
+	query = gqlparser.MustLoadQuery(f.mergedSchema, `{ movie(id: "1") { title director } }`)
+	resp = es.ExecuteQuery(testContextWithVariables(nil, query.Operations[0]))
+	assert.JSONEq(t, `null`, string(resp.Data), "nulls are bubbled up from non-nullable fields")
+	require.Len(t, resp.Errors, 2)
+	assert.Equal(t, "exceeded max requests of 0", resp.Errors[0].Message)
+	assert.Equal(t, ast.Path{ast.PathName("movie"), ast.PathName("director")}, resp.Errors[1].Path)
+}
+
+func TestQueryExecutionBoundaryErrorsKeepPartialData(t *testing.T) {
+	moviesService := testService{
+		schema: `directive @boundary on OBJECT
+		type Movie @boundary {
+			id: ID!
+		}
+		type Query {
+			movies: [Movie!]!
+		}`,
+		handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{
+				"data": {
+					"movies": [
+						{ "_bramble_id": "1", "_bramble__typename": "Movie", "id": "1" },
+						{ "_bramble_id": "2", "_bramble__typename": "Movie", "id": "2" },
+						{ "_bramble_id": "3", "_bramble__typename": "Movie", "id": "3" }
+					]
+				}
+			}`))
+		}),
+	}
+
+	check := func(t *testing.T, f *queryExecutionFixture) {
+		es := f.setup(t)
+		query := gqlparser.MustLoadQuery(f.mergedSchema, `{ movies { id title } }`)
+		resp := es.ExecuteQuery(testContextWithVariables(nil, query.Operations[0]))
+
+		jsonEqWithOrder(t, `{
+			"movies": [
+				{ "id": "1", "title": "Movie 1" },
+				{ "id": "2", "title": null },
+				{ "id": "3", "title": "Movie 3" }
+			]
+		}`, string(resp.Data))
+		require.Len(t, resp.Errors, 1)
+		assert.Equal(t, "title not found", resp.Errors[0].Message)
+		assert.Equal(t, ast.Path{ast.PathName("movies"), ast.PathIndex(1), ast.PathName("title")}, resp.Errors[0].Path)
+		assert.NotContains(t, resp.Errors[0].Extensions, "selectionPath")
+	}
+
+	t.Run("aliased lookups", func(t *testing.T) {
+		check(t, &queryExecutionFixture{
+			services: []testService{
+				moviesService,
+				{
+					schema: `directive @boundary on OBJECT | FIELD_DEFINITION
+					type Movie @boundary {
+						id: ID!
+						title: String
+					}
+					type Query {
+						movie(id: ID!): Movie @boundary
+					}`,
+					handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						w.Write([]byte(`{
+							"data": {
+								"_0": { "_bramble_id": "1", "_bramble__typename": "Movie", "title": "Movie 1" },
+								"_1": { "_bramble_id": "2", "_bramble__typename": "Movie", "title": null },
+								"_2": { "_bramble_id": "3", "_bramble__typename": "Movie", "title": "Movie 3" }
+							},
+							"errors": [
+								{ "message": "title not found", "path": ["_1", "title"] }
+							]
+						}`))
+					}),
+				},
+			},
+		})
+	})
+
+	t.Run("array lookup", func(t *testing.T) {
+		check(t, &queryExecutionFixture{
+			services: []testService{
+				moviesService,
+				{
+					schema: `directive @boundary on OBJECT | FIELD_DEFINITION
+					type Movie @boundary {
+						id: ID!
+						title: String
+					}
+					type Query {
+						movies(ids: [ID!]!): [Movie]! @boundary
+					}`,
+					handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						w.Write([]byte(`{
+							"data": {
+								"_result": [
+									{ "_bramble_id": "1", "_bramble__typename": "Movie", "title": "Movie 1" },
+									{ "_bramble_id": "2", "_bramble__typename": "Movie", "title": null },
+									{ "_bramble_id": "3", "_bramble__typename": "Movie", "title": "Movie 3" }
+								]
+							},
+							"errors": [
+								{ "message": "title not found", "path": ["_result", 1, "title"] }
+							]
+						}`))
+					}),
+				},
+			},
+		})
+	})
+}
+
+func TestClientErrorPath(t *testing.T) {
+	schema := gqlparser.MustLoadSchema(&ast.Source{Input: `
+		type Movie {
+			id: ID!
+			title: String
+			compTitles: [Movie!]!
+		}
+		type Query {
+			movies: [Movie!]!
+		}`})
+	query := gqlparser.MustLoadQuery(schema, `{
+		films: movies {
+			key: id
+			... on Movie {
+				similar: compTitles { title }
+			}
+		}
+	}`)
+	selectionSet := query.Operations[0].SelectionSet
+	selectionSet[0].(*ast.Field).SelectionSet = append(selectionSet[0].(*ast.Field).SelectionSet,
+		&ast.Field{Alias: "_bramble_id", Name: "id"},
+		&ast.Field{Alias: "_bramble__typename", Name: "__typename"},
+	)
+
+	path := func(elements ...interface{}) ast.Path {
+		var result ast.Path
+		for _, element := range elements {
+			switch element := element.(type) {
+			case string:
+				result = append(result, ast.PathName(element))
+			case int:
+				result = append(result, ast.PathIndex(element))
+			}
+		}
+		return result
+	}
+
+	assert.Equal(t, path("films", 1, "similar", 0, "title"), clientErrorPath(selectionSet, path("films", 1, "similar", 0, "title")))
+	assert.Equal(t, path("films", 1, "key"), clientErrorPath(selectionSet, path("films", 1, "_bramble_id")), "reserved aliases are replaced with the client alias")
+	assert.Equal(t, path("films", 1), clientErrorPath(selectionSet, path("films", 1, "_bramble__typename")), "reserved aliases not selected by the client are cut")
+	assert.Nil(t, clientErrorPath(selectionSet, nil))
+}
+
+func TestQueryExecutionBoundaryErrorPaths(t *testing.T) {
+	f := &queryExecutionFixture{
+		services: []testService{
+			{
+				schema: `directive @boundary on OBJECT
+				type Movie @boundary {
+					id: ID!
+				}
+				type Query {
+					movies: [Movie!]!
+				}`,
+				handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Write([]byte(`{
+						"data": {
+							"films": [
+								{ "_bramble_id": "1", "_bramble__typename": "Movie" },
+								{ "_bramble_id": "2", "_bramble__typename": "Movie" },
+								{ "_bramble_id": "1", "_bramble__typename": "Movie" }
+							]
+						}
+					}`))
+				}),
+			},
+			{
+				schema: `directive @boundary on OBJECT | FIELD_DEFINITION
+				type Movie @boundary {
+					id: ID!
+					title: String
+				}
+				type Query {
+					movie(id: ID!): Movie @boundary
+				}`,
+				handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Write([]byte(`{
+						"data": {
+							"_0": { "_bramble_id": "1", "_bramble__typename": "Movie", "name": null },
+							"_1": { "_bramble_id": "2", "_bramble__typename": "Movie", "name": "Movie 2" }
+						},
+						"errors": [
+							{ "message": "title not found", "path": ["_0", "name"] },
+							{ "message": "unexpected error" }
+						]
+					}`))
+				}),
+			},
+		},
+	}
+	es := f.setup(t)
+	query := gqlparser.MustLoadQuery(f.mergedSchema, `{ films: movies { name: title } }`)
+	resp := es.ExecuteQuery(testContextWithVariables(nil, query.Operations[0]))
+
+	jsonEqWithOrder(t, `{
+		"films": [
+			{ "name": null },
+			{ "name": "Movie 2" },
+			{ "name": null }
+		]
+	}`, string(resp.Data))
+
+	var paths []ast.Path
+	for _, err := range resp.Errors {
+		paths = append(paths, err.Path)
+	}
+	assert.ElementsMatch(t, []ast.Path{
+		{ast.PathName("films"), ast.PathIndex(0), ast.PathName("name")},
+		{ast.PathName("films"), ast.PathIndex(2), ast.PathName("name")},
+		nil,
+	}, paths, "errors are reported for every occurrence of the entity, errors without an entity have no path")
+}
+
 func TestQueryExecutionWithUnions(t *testing.T) {
 	f := &queryExecutionFixture{
 		services: []testService{
@@ -3311,6 +3807,41 @@ func TestQueryWithAbstractType(t *testing.T) {
 	f.checkSuccess(t)
 }
 
+func TestServiceRequestMetrics(t *testing.T) {
+	f := boundaryBatchFixture(func() {})
+	es := f.setup(t)
+
+	var configs []ServiceConfig
+	for url := range es.Services {
+		configs = append(configs, ServiceConfig{URL: url, BoundaryBatchSize: 2})
+	}
+	require.NoError(t, es.GraphqlClient.SetServiceConfigs(configs...))
+
+	f.run(t, es)
+
+	rootURL := es.Locations["Query.movies"]
+	boundaryURL := es.Locations["Movie.title"]
+	assert.Equal(t, 1.0, testutil.ToFloat64(promServiceRequestCounter.WithLabelValues(rootURL, "root", "query", "success")))
+	assert.Equal(t, 3.0, testutil.ToFloat64(promServiceRequestCounter.WithLabelValues(boundaryURL, "boundary", "query", "success")))
+
+	var metric dto.Metric
+	require.NoError(t, promServiceRequestDurations.WithLabelValues(boundaryURL, "boundary", "query", "success").(prometheus.Histogram).Write(&metric))
+	assert.Equal(t, uint64(3), metric.GetHistogram().GetSampleCount())
+
+	require.NoError(t, promServiceBoundaryIDs.WithLabelValues(boundaryURL, "Movie").(prometheus.Histogram).Write(&metric))
+	assert.Equal(t, uint64(3), metric.GetHistogram().GetSampleCount())
+	assert.Equal(t, 5.0, metric.GetHistogram().GetSampleSum())
+}
+
+func TestServiceRequestOutcome(t *testing.T) {
+	assert.Equal(t, "success", serviceRequestOutcome(nil))
+	assert.Equal(t, "graphql_error", serviceRequestOutcome(fmt.Errorf("wrapped: %w", GraphqlErrors{{Message: "error"}})))
+	assert.Equal(t, "circuit_open", serviceRequestOutcome(&CircuitOpenError{ServiceURL: "http://movies"}))
+	assert.Equal(t, "timeout", serviceRequestOutcome(context.DeadlineExceeded))
+	assert.Equal(t, "canceled", serviceRequestOutcome(context.Canceled))
+	assert.Equal(t, "error", serviceRequestOutcome(errors.New("connection refused")))
+}
+
 func TestMergeWithNull(t *testing.T) {
 	nullMap := make(map[string]interface{})
 	dataMap := map[string]interface{}{
@@ -3572,11 +4103,11 @@ func testContextWithoutVariables(op *ast.OperationDefinition) context.Context {
 		op = &ast.OperationDefinition{}
 	}
 
-	return AddPermissionsToContext(graphql.WithOperationContext(context.Background(), &graphql.OperationContext{
+	return AddPermissionsToContext(graphql.WithResponseContext(graphql.WithOperationContext(context.Background(), &graphql.OperationContext{
 		OperationName: op.Name,
 		Variables:     map[string]interface{}{},
 		Operation:     op,
-	}), OperationPermissions{
+	}), graphql.DefaultErrorPresenter, graphql.DefaultRecover), OperationPermissions{
 		AllowedRootQueryFields:        AllowedFields{AllowAll: true},
 		AllowedRootMutationFields:     AllowedFields{AllowAll: true},
 		AllowedRootSubscriptionFields: AllowedFields{AllowAll: true},
@@ -3588,11 +4119,11 @@ func testContextWithNoPermissions(op *ast.OperationDefinition) context.Context {
 		op = &ast.OperationDefinition{}
 	}
 
-	return AddPermissionsToContext(graphql.WithOperationContext(context.Background(), &graphql.OperationContext{
+	return AddPermissionsToContext(graphql.WithResponseContext(graphql.WithOperationContext(context.Background(), &graphql.OperationContext{
 		OperationName: op.Name,
 		Variables:     map[string]interface{}{},
 		Operation:     op,
-	}), OperationPermissions{
+	}), graphql.DefaultErrorPresenter, graphql.DefaultRecover), OperationPermissions{
 		AllowedRootQueryFields:        AllowedFields{},
 		AllowedRootMutationFields:     AllowedFields{},
 		AllowedRootSubscriptionFields: AllowedFields{},
@@ -152,6 +152,10 @@ func formatSelection(sb *strings.Builder, schema *ast.Schema, vars map[string]in
 			}
 			formatArgumentList(sb, schema, vars, selection.Arguments)
 			for _, d := range selection.Directives {
+				if d.Name == streamDirectiveName {
+					// @stream is handled by the gateway
+					continue
+				}
 				sb.WriteString(" @")
 				sb.WriteString(d.Name)
 				formatArgumentList(sb, schema, vars, d.Arguments)
@@ -27,11 +27,26 @@ func NewGateway(executableSchema *ExecutableSchema, plugins []Plugin) *Gateway {
 
 // UpdateSchemas periodically updates the execute schema
 func (g *Gateway) UpdateSchemas(interval time.Duration) {
-	time.Sleep(interval)
-	for range time.Tick(interval) {
-		err := g.ExecutableSchema.UpdateSchema(false)
-		if err != nil {
-			log.WithError(err).Error("error updating schemas")
+	g.updateSchemas(func() time.Duration { return interval }, nil)
+}
+
+// updateSchemas periodically updates the execute schema. The interval is read
+// again before every update and whenever updates is notified, so that
+// reloaded poll intervals apply. Polling is paused while the interval is 0
+// and resumes once a reload sets an interval.
+func (g *Gateway) updateSchemas(interval func() time.Duration, updates <-chan struct{}) {
+	for {
+		var tick <-chan time.Time
+		if d := interval(); d > 0 {
+			tick = time.After(d)
+		}
+		select {
+		case <-tick:
+			err := g.ExecutableSchema.UpdateSchema(false)
+			if err != nil {
+				log.WithError(err).Error("error updating schemas")
+			}
+		case <-updates:
 		}
 	}
 }
@@ -40,21 +55,40 @@ func (g *Gateway) UpdateSchemas(interval time.Duration) {
 func (g *Gateway) Router(cfg *Config) http.Handler {
 	mux := http.NewServeMux()
 
-	// Duplicated from `handler.NewDefaultServer` minus
-	// the websocket transport and persisted query extension
+	// Duplicated from `handler.NewDefaultServer`, the websocket transport uses
+	// the graphql-transport-ws protocol, results can also be streamed as
+	// Server-Sent Events or multipart responses and persisted queries are only
+	// enabled when a store is configured
 	gatewayHandler := handler.New(g.ExecutableSchema)
+	gatewayHandler.AddTransport(WebsocketTransport{
+		KeepAlivePingInterval: 10 * time.Second,
+	})
 	gatewayHandler.AddTransport(transport.Options{})
+	gatewayHandler.AddTransport(SSETransport{})
+	gatewayHandler.AddTransport(MultipartMixedTransport{})
 	gatewayHandler.AddTransport(transport.GET{})
 	gatewayHandler.AddTransport(transport.POST{})
 	gatewayHandler.AddTransport(transport.MultipartForm{})
 	if !cfg.DisableIntrospection {
 		gatewayHandler.Use(extension.Introspection{})
 	}
+	// trusted documents are checked first, so that clients can send the hash
+	// of a trusted document without registering it as a persisted query
+	if cfg.trustedDocuments != nil {
+		gatewayHandler.Use(cfg.trustedDocuments)
+	}
+	if cfg.PersistedQueryStore != nil {
+		gatewayHandler.Use(extension.AutomaticPersistedQuery{
+			Cache: persistedQueryCache{store: cfg.PersistedQueryStore},
+		})
+	}
 
 	mux.Handle("/query",
 		applyMiddleware(
 			gatewayHandler,
 			debugMiddleware,
+			cacheControlMiddleware,
+			tracingMiddleware,
 		),
 	)
 
@@ -68,7 +102,7 @@ func (g *Gateway) Router(cfg *Config) http.Handler {
 		result = g.plugins[i].ApplyMiddlewarePublicMux(result)
 	}
 
-	return applyMiddleware(result, monitoringMiddleware)
+	return applyMiddleware(result, monitoringMiddleware(cfg.metricLabels, cfg.requestLogging, g.ExecutableSchema))
 }
 
 // PrivateRouter returns the private http handler
@@ -8,6 +8,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -63,7 +64,7 @@ func TestGatewayQuery(t *testing.T) {
 
 	gtw.Router(&Config{}).ServeHTTP(rec, req)
 	assert.Equal(t, http.StatusOK, rec.Code)
-	assert.JSONEq(t, `{"data": { "test": "Hello" }}`, rec.Body.String())
+	assert.JSONEq(t, `{"data": { "test": "Hello" }, "extensions": {"complexity": 1}}`, rec.Body.String())
 }
 
 func TestRequestJSONBodyLogging(t *testing.T) {
@@ -205,3 +206,33 @@ func TestDebugMiddleware(t *testing.T) {
 		})
 	}
 }
+
+func TestGatewayUpdateSchemasResumesPolling(t *testing.T) {
+	polled := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case polled <- struct{}{}:
+		default:
+		}
+		w.Write([]byte(`{"data": {"service": {"name": "test", "version": "1", "schema": "type Service { name: String! version: String! schema: String! } type Query { service: Service! test: String }"}}}`))
+	}))
+	defer server.Close()
+
+	executableSchema := NewExecutableSchema(nil, 50, nil, NewService(server.URL))
+	gtw := NewGateway(executableSchema, []Plugin{})
+	go gtw.updateSchemas(executableSchema.schemaPollInterval, executableSchema.pollUpdates)
+
+	select {
+	case <-polled:
+		t.Fatal("services were polled with polling disabled")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	executableSchema.setPollInterval(time.Millisecond)
+	select {
+	case <-polled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("polling wasn't resumed by the poll interval update")
+	}
+	executableSchema.setPollInterval(0)
+}
@@ -0,0 +1,539 @@
+package bramble
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"github.com/vektah/gqlparser/v2/parser"
+)
+
+// incrementalDeliveryDirectives are the @defer and @stream directives, they
+// are added to the merged schema and handled by the gateway
+var incrementalDeliveryDirectives = func() map[string]*ast.DirectiveDefinition {
+	doc, err := parser.ParseSchema(&ast.Source{Name: "incremental delivery", BuiltIn: true, Input: `
+	directive @defer(label: String, if: Boolean! = true) on FRAGMENT_SPREAD | INLINE_FRAGMENT
+	directive @stream(label: String, if: Boolean! = true, initialCount: Int = 0) on FIELD
+	`})
+	if err != nil {
+		panic(err)
+	}
+	directives := make(map[string]*ast.DirectiveDefinition)
+	for _, d := range doc.Directives {
+		directives[d.Name] = d
+	}
+	return directives
+}()
+
+// Incremental payloads carry their fields in the response extensions, they
+// are moved to the top level of the payload by marshalResponse
+const (
+	hasNextExtension     = "hasNext"
+	incrementalExtension = "incremental"
+)
+
+// DeferredFragment is a fragment marked with @defer. Its data is sent in a
+// subsequent payload once the steps planned for it are executed.
+type DeferredFragment struct {
+	Label string
+	// Path is the insertion point of the fragment
+	Path []string
+	// SelectionSet contains the fragment, without its nested deferred
+	// fragments
+	SelectionSet ast.SelectionSet
+	// Parent is the deferred fragment the fragment is nested in, its payload
+	// is always sent before the payload of the fragment
+	Parent *DeferredFragment
+
+	directive *ast.Directive
+}
+
+// StreamedField is a list field marked with @stream. The items after the
+// initial count are sent in a subsequent payload.
+type StreamedField struct {
+	Label string
+	// Path is the path to the field, including the field alias
+	Path  []string
+	Field *ast.Field
+
+	directive *ast.Directive
+}
+
+func (f *StreamedField) initialCount(variables map[string]interface{}) int {
+	arg := f.directive.Arguments.ForName("initialCount")
+	if arg == nil {
+		return 0
+	}
+	value, err := arg.Value.Value(variables)
+	if err != nil {
+		return 0
+	}
+	count, _ := intValue(value)
+	return count
+}
+
+// collectIncrementalSelections returns the deferred fragments and the
+// streamed fields of the selection set. Streamed fields inside of deferred
+// fragments are sent with the fragment.
+func collectIncrementalSelections(selectionSet ast.SelectionSet) ([]*DeferredFragment, []*StreamedField) {
+	var fragments []*DeferredFragment
+	var streams []*StreamedField
+	collectIncrementalSelectionsRec(selectionSet, nil, nil, &fragments, &streams)
+	return fragments, streams
+}
+
+func collectIncrementalSelectionsRec(selectionSet ast.SelectionSet, path []string, parent *DeferredFragment, fragments *[]*DeferredFragment, streams *[]*StreamedField) {
+	for _, selection := range selectionSet {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			fieldPath := append(append([]string{}, path...), selection.Alias)
+			if d := selection.Directives.ForName(streamDirectiveName); d != nil && parent == nil {
+				*streams = append(*streams, &StreamedField{
+					Label:     directiveLabel(d),
+					Path:      fieldPath,
+					Field:     selection,
+					directive: d,
+				})
+			}
+			collectIncrementalSelectionsRec(selection.SelectionSet, fieldPath, parent, fragments, streams)
+		case *ast.InlineFragment:
+			fragmentParent := parent
+			if d := selection.Directives.ForName(deferDirectiveName); d != nil {
+				inlineFragment := *selection
+				inlineFragment.SelectionSet = removeDeferredFragments(selection.SelectionSet)
+				fragmentParent = newDeferredFragment(d, path, parent, &inlineFragment)
+				*fragments = append(*fragments, fragmentParent)
+			}
+			collectIncrementalSelectionsRec(selection.SelectionSet, path, fragmentParent, fragments, streams)
+		case *ast.FragmentSpread:
+			fragmentParent := parent
+			if d := selection.Directives.ForName(deferDirectiveName); d != nil {
+				spread := *selection
+				definition := *selection.Definition
+				definition.SelectionSet = removeDeferredFragments(selection.Definition.SelectionSet)
+				spread.Definition = &definition
+				fragmentParent = newDeferredFragment(d, path, parent, &spread)
+				*fragments = append(*fragments, fragmentParent)
+			}
+			collectIncrementalSelectionsRec(selection.Definition.SelectionSet, path, fragmentParent, fragments, streams)
+		}
+	}
+}
+
+func newDeferredFragment(d *ast.Directive, path []string, parent *DeferredFragment, selection ast.Selection) *DeferredFragment {
+	return &DeferredFragment{
+		Label:        directiveLabel(d),
+		Path:         append([]string{}, path...),
+		SelectionSet: ast.SelectionSet{selection},
+		Parent:       parent,
+		directive:    d,
+	}
+}
+
+func directiveLabel(d *ast.Directive) string {
+	if arg := d.Arguments.ForName("label"); arg != nil && arg.Value.Kind == ast.StringValue {
+		return arg.Value.Raw
+	}
+	return ""
+}
+
+// deferSteps marks the steps planned for a fragment with @defer, steps that
+// are already marked belong to a nested deferred fragment
+func deferSteps(directives ast.DirectiveList, steps []*QueryPlanStep) {
+	d := directives.ForName(deferDirectiveName)
+	if d == nil {
+		return
+	}
+	for _, step := range steps {
+		if step.deferDirective == nil {
+			step.deferDirective = d
+		}
+	}
+}
+
+// bindDeferredSteps sets the deferred fragment of the steps marked during
+// planning
+func bindDeferredSteps(steps []*QueryPlanStep, fragments []*DeferredFragment) {
+	for _, step := range steps {
+		if step.deferDirective != nil {
+			for _, fragment := range fragments {
+				if fragment.directive == step.deferDirective {
+					step.Defer = fragment
+					break
+				}
+			}
+		}
+		bindDeferredSteps(step.Then, fragments)
+	}
+}
+
+// removeDeferredFragments returns a copy of the selection set without the
+// fragments marked with @defer
+func removeDeferredFragments(selectionSet ast.SelectionSet) ast.SelectionSet {
+	if selectionSet == nil {
+		return nil
+	}
+	result := ast.SelectionSet{}
+	for _, selection := range selectionSet {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			if selection.SelectionSet == nil {
+				result = append(result, selection)
+				continue
+			}
+			field := *selection
+			field.SelectionSet = removeDeferredFragments(selection.SelectionSet)
+			result = append(result, &field)
+		case *ast.InlineFragment:
+			if selection.Directives.ForName(deferDirectiveName) != nil {
+				continue
+			}
+			inlineFragment := *selection
+			inlineFragment.SelectionSet = removeDeferredFragments(selection.SelectionSet)
+			result = append(result, &inlineFragment)
+		case *ast.FragmentSpread:
+			if selection.Directives.ForName(deferDirectiveName) != nil {
+				continue
+			}
+			spread := *selection
+			definition := *selection.Definition
+			definition.SelectionSet = removeDeferredFragments(selection.Definition.SelectionSet)
+			spread.Definition = &definition
+			result = append(result, &spread)
+		}
+	}
+	return result
+}
+
+// deferral holds the steps of deferred fragments, along with the boundary ids
+// they were planned for
+type deferral struct {
+	ctx     context.Context
+	mutex   sync.Mutex
+	pending map[*DeferredFragment][]deferredStep
+}
+
+type deferredStep struct {
+	step        *QueryPlanStep
+	boundaryIDs []string
+}
+
+func newDeferral(ctx context.Context) *deferral {
+	return &deferral{
+		ctx:     ctx,
+		pending: make(map[*DeferredFragment][]deferredStep),
+	}
+}
+
+func (d *deferral) add(step *QueryPlanStep, boundaryIDs []string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.pending[step.Defer] = append(d.pending[step.Defer], deferredStep{step: step, boundaryIDs: boundaryIDs})
+}
+
+func (d *deferral) take(fragment *DeferredFragment) []deferredStep {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	steps := d.pending[fragment]
+	delete(d.pending, fragment)
+	return steps
+}
+
+type incrementalResult struct {
+	Data   json.RawMessage `json:"data,omitempty"`
+	Items  json.RawMessage `json:"items,omitempty"`
+	Path   ast.Path        `json:"path"`
+	Label  string          `json:"label,omitempty"`
+	Errors gqlerror.List   `json:"errors,omitempty"`
+}
+
+type deferredFragmentResult struct {
+	fragment *DeferredFragment
+	results  []executionResult
+	errs     gqlerror.List
+}
+
+// incrementalExecution delivers the streamed lists and the deferred
+// fragments of a query once its initial response is sent. Deferred fragments
+// are executed concurrently, a nested fragment is only executed after the
+// payload of its parent is sent.
+type incrementalExecution struct {
+	schema    *ast.Schema
+	execution *queryExecution
+	fragments []*DeferredFragment
+	data      map[string]interface{}
+
+	streams   []incrementalResult
+	running   int
+	completed chan deferredFragmentResult
+	// cancel releases the execution context once everything is delivered
+	cancel context.CancelFunc
+}
+
+func newIncrementalExecution(schema *ast.Schema, execution *queryExecution, fragments []*DeferredFragment, data map[string]interface{}) *incrementalExecution {
+	return &incrementalExecution{
+		schema:    schema,
+		execution: execution,
+		fragments: fragments,
+		data:      data,
+		// buffered so that executions don't leak if the client goes away
+		completed: make(chan deferredFragmentResult, len(fragments)),
+	}
+}
+
+// splitStreamedFields returns the data of the initial response, where the
+// streamed lists are cut at their initial count. The remaining items are sent
+// in the next payload.
+func (e *incrementalExecution) splitStreamedFields(fields []*StreamedField, variables map[string]interface{}) map[string]interface{} {
+	var data interface{} = e.data
+	for _, field := range fields {
+		var items []streamedItems
+		data, items = splitStreamedList(data, field.Path, ast.Path{}, field.initialCount(variables))
+		selectionSet := removeDeferredFragments(field.Field.SelectionSet)
+		for _, item := range items {
+			e.streams = append(e.streams, incrementalResult{
+				Items: formatStreamedItems(e.schema, selectionSet, item.items),
+				Path:  item.path,
+				Label: field.Label,
+			})
+		}
+	}
+	result, _ := data.(map[string]interface{})
+	return result
+}
+
+// start executes the fragments nested in parent, or the top level fragments
+// if parent is nil
+func (e *incrementalExecution) start(parent *DeferredFragment) {
+	for _, fragment := range e.fragments {
+		if fragment.Parent != parent {
+			continue
+		}
+		e.running++
+		fragment := fragment
+		go func() {
+			results, errs := e.execution.executeDeferredFragment(fragment)
+			e.completed <- deferredFragmentResult{fragment: fragment, results: results, errs: errs}
+		}()
+	}
+}
+
+func (e *incrementalExecution) hasNext() bool {
+	return len(e.streams) > 0 || e.running > 0
+}
+
+// next returns the next payload, or nil once everything was delivered
+func (e *incrementalExecution) next(ctx context.Context) *graphql.Response {
+	response := e.nextResponse(ctx)
+	if response == nil && e.cancel != nil {
+		e.cancel()
+	}
+	return response
+}
+
+func (e *incrementalExecution) nextResponse(ctx context.Context) *graphql.Response {
+	if len(e.streams) > 0 {
+		incremental := e.streams
+		e.streams = nil
+		return e.response(ctx, incremental, nil)
+	}
+
+	for e.running > 0 {
+		var result deferredFragmentResult
+		select {
+		case <-ctx.Done():
+			return nil
+		case result = <-e.completed:
+		}
+		e.running--
+
+		incremental, errs := e.completeFragment(result)
+		e.start(result.fragment)
+		if len(incremental) == 0 && len(errs) == 0 && e.hasNext() {
+			continue
+		}
+		return e.response(ctx, incremental, errs)
+	}
+
+	return nil
+}
+
+func (e *incrementalExecution) response(ctx context.Context, incremental []incrementalResult, errs gqlerror.List) *graphql.Response {
+	graphql.RegisterExtension(ctx, incrementalExtension, incremental)
+	graphql.RegisterExtension(ctx, hasNextExtension, e.hasNext())
+	return &graphql.Response{
+		Errors: errs,
+	}
+}
+
+// completeFragment merges the results of the fragment execution and returns
+// the fragment data for every object it applies to
+func (e *incrementalExecution) completeFragment(result deferredFragmentResult) ([]incrementalResult, gqlerror.List) {
+	errs := result.errs
+	for _, r := range result.results {
+		errs = append(errs, r.Errors...)
+		if e.data == nil {
+			continue
+		}
+		if err := mergeExecutionResultsRec(r.Data, e.data, r.InsertionPoint); err != nil {
+			errs = append(errs, &gqlerror.Error{Message: err.Error()})
+		}
+	}
+	if e.data != nil {
+		errs = append(errs, resolveEntityErrorPaths(e.data, result.results)...)
+	}
+
+	var incremental []incrementalResult
+	selectionSet := result.fragment.SelectionSet
+	forEachObjectAtPath(e.data, result.fragment.Path, ast.Path{}, func(path ast.Path, object map[string]interface{}) {
+		bubbleErrs, bubbleUp, err := bubbleUpNullValuesInPlaceRec(e.schema, nil, selectionSet, object, path)
+		if err != nil {
+			errs = append(errs, &gqlerror.Error{Message: err.Error(), Path: path})
+			return
+		}
+		data := json.RawMessage("null")
+		if !bubbleUp {
+			data = formatResponseData(e.schema, selectionSet, object)
+			if string(data) == "{}" {
+				// the fragment doesn't apply to the object type
+				return
+			}
+		}
+		incremental = append(incremental, incrementalResult{
+			Data:   data,
+			Path:   path,
+			Label:  result.fragment.Label,
+			Errors: bubbleErrs,
+		})
+	})
+
+	if len(incremental) > 0 && len(errs) > 0 {
+		incremental[0].Errors = append(errs, incremental[0].Errors...)
+		errs = nil
+	}
+	return incremental, errs
+}
+
+// forEachObjectAtPath calls fn with every object found at the given path,
+// along with its path in the response
+func forEachObjectAtPath(data interface{}, path []string, responsePath ast.Path, fn func(ast.Path, map[string]interface{})) {
+	switch data := data.(type) {
+	case map[string]interface{}:
+		if data == nil {
+			return
+		}
+		if len(path) == 0 {
+			fn(responsePath, data)
+			return
+		}
+		forEachObjectAtPath(data[path[0]], path[1:], appendPathName(responsePath, path[0]), fn)
+	case []interface{}:
+		for i, value := range data {
+			forEachObjectAtPath(value, path, appendPathIndex(responsePath, i), fn)
+		}
+	}
+}
+
+type streamedItems struct {
+	path  ast.Path
+	items []interface{}
+}
+
+// splitStreamedList returns a copy of data where the lists found at path are
+// cut at initialCount, along with the remaining items of every list
+func splitStreamedList(data interface{}, path []string, responsePath ast.Path, initialCount int) (interface{}, []streamedItems) {
+	switch data := data.(type) {
+	case map[string]interface{}:
+		if len(path) == 0 {
+			return data, nil
+		}
+		value, ok := data[path[0]]
+		if !ok || value == nil {
+			return data, nil
+		}
+		newValue, items := splitStreamedList(value, path[1:], appendPathName(responsePath, path[0]), initialCount)
+		result := make(map[string]interface{}, len(data))
+		for k, v := range data {
+			result[k] = v
+		}
+		result[path[0]] = newValue
+		return result, items
+	case []interface{}:
+		if len(path) == 0 {
+			if len(data) <= initialCount {
+				return data, nil
+			}
+			return data[:initialCount], []streamedItems{{
+				path:  appendPathIndex(responsePath, initialCount),
+				items: data[initialCount:],
+			}}
+		}
+		var items []streamedItems
+		result := make([]interface{}, len(data))
+		for i, value := range data {
+			var valueItems []streamedItems
+			result[i], valueItems = splitStreamedList(value, path, appendPathIndex(responsePath, i), initialCount)
+			items = append(items, valueItems...)
+		}
+		return result, items
+	}
+	return data, nil
+}
+
+func formatStreamedItems(schema *ast.Schema, selectionSet ast.SelectionSet, items []interface{}) json.RawMessage {
+	if selectionSet == nil {
+		data, err := json.Marshal(items)
+		if err != nil {
+			// the data was decoded from downstream JSON responses
+			panic(fmt.Errorf("invalid json when formatting streamed items: %w", err))
+		}
+		return data
+	}
+	return formatResponseDataRec(schema, selectionSet, items, false)
+}
+
+func appendPathName(path ast.Path, name string) ast.Path {
+	pathCopy := make(ast.Path, len(path))
+	copy(pathCopy, path)
+	return append(pathCopy, ast.PathName(name))
+}
+
+type incrementalPayload struct {
+	Errors      gqlerror.List          `json:"errors,omitempty"`
+	Data        json.RawMessage        `json:"data,omitempty"`
+	Incremental []incrementalResult    `json:"incremental,omitempty"`
+	HasNext     bool                   `json:"hasNext"`
+	Extensions  map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// marshalResponse encodes the response, moving the incremental delivery
+// fields from the extensions to the top level of the payload
+func marshalResponse(response *graphql.Response) ([]byte, error) {
+	hasNext, ok := response.Extensions[hasNextExtension].(bool)
+	if !ok {
+		return json.Marshal(response)
+	}
+
+	payload := incrementalPayload{
+		Errors:  response.Errors,
+		Data:    response.Data,
+		HasNext: hasNext,
+	}
+	for name, value := range response.Extensions {
+		switch name {
+		case hasNextExtension:
+		case incrementalExtension:
+			payload.Incremental, _ = value.([]incrementalResult)
+		default:
+			if payload.Extensions == nil {
+				payload.Extensions = make(map[string]interface{})
+			}
+			payload.Extensions[name] = value
+		}
+	}
+	return json.Marshal(payload)
+}
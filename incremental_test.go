@@ -0,0 +1,252 @@
+package bramble
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// multipartQuery sends the query to the server accepting a multipart/mixed
+// response and returns the parts of the response as they are received
+func multipartQuery(t *testing.T, url, query string) <-chan string {
+	body, err := json.Marshal(map[string]string{"query": query})
+	require.NoError(t, err)
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(string(body)))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "multipart/mixed")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, `multipart/mixed; boundary="-"; deferSpec=20220824`, resp.Header.Get("Content-Type"))
+
+	parts := make(chan string)
+	go func() {
+		defer close(parts)
+		defer resp.Body.Close()
+		var content string
+		chunk := make([]byte, 1024)
+		for {
+			n, err := resp.Body.Read(chunk)
+			content += string(chunk[:n])
+			// every part is made of its headers and body, followed by a boundary
+			content = strings.TrimPrefix(content, "\r\n---")
+			for {
+				headersEnd := strings.Index(content, "\r\n\r\n")
+				if headersEnd == -1 {
+					break
+				}
+				bodyEnd := strings.Index(content[headersEnd:], "\r\n---")
+				if bodyEnd == -1 {
+					break
+				}
+				parts <- content[headersEnd+4 : headersEnd+bodyEnd]
+				content = content[headersEnd+bodyEnd+5:]
+			}
+			if err == io.EOF {
+				assert.Equal(t, "--\r\n", content)
+				return
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	return parts
+}
+
+func newIncrementalTestServer(t *testing.T, es *ExecutableSchema) *httptest.Server {
+	gateway := handler.New(es)
+	gateway.AddTransport(MultipartMixedTransport{})
+	srv := httptest.NewServer(gateway)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestDefer(t *testing.T) {
+	movieService := testService{
+		schema: `directive @boundary on OBJECT
+		type Movie @boundary {
+			id: ID!
+			title: String!
+		}
+		type Query {
+			movie(id: ID!): Movie!
+			movies: [Movie!]!
+		}`,
+		handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req Request
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			if strings.Contains(req.Query, "movies") {
+				w.Write([]byte(`{"data": {"movies": [
+					{"_bramble_id": "1", "_bramble__typename": "Movie", "title": "Movie 1"},
+					{"_bramble_id": "2", "_bramble__typename": "Movie", "title": "Movie 2"}
+				]}}`))
+				return
+			}
+			w.Write([]byte(`{"data": {"movie": {"_bramble_id": "1", "_bramble__typename": "Movie", "title": "Movie 1"}}}`))
+		}),
+	}
+
+	ratingService := func(release <-chan struct{}) testService {
+		return testService{
+			schema: `directive @boundary on OBJECT | FIELD_DEFINITION
+			type Movie @boundary {
+				id: ID!
+				rating: Int!
+			}
+			type Query {
+				movies(ids: [ID!]!): [Movie]! @boundary
+			}`,
+			handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				<-release
+				var req Request
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+				var movies []string
+				for _, id := range []string{"1", "2"} {
+					if strings.Contains(req.Query, `"`+id+`"`) {
+						movies = append(movies, `{"_bramble_id": "`+id+`", "_bramble__typename": "Movie", "rating": `+id+`}`)
+					}
+				}
+				w.Write([]byte(`{"data": {"_result": [` + strings.Join(movies, ",") + `]}}`))
+			}),
+		}
+	}
+
+	t.Run("initial payload is sent before deferred steps are executed", func(t *testing.T) {
+		release := make(chan struct{})
+		f := &queryExecutionFixture{
+			services: []testService{movieService, ratingService(release)},
+		}
+		srv := newIncrementalTestServer(t, f.setup(t))
+
+		parts := multipartQuery(t, srv.URL, `{ movie(id: "1") { title ... @defer(label: "rating") { rating } } }`)
+		assert.JSONEq(t, `{"data": {"movie": {"title": "Movie 1"}}, "extensions": {"complexity": 3}, "hasNext": true}`, <-parts)
+		close(release)
+		assert.JSONEq(t, `{"incremental": [{"data": {"rating": 1}, "path": ["movie"], "label": "rating"}], "hasNext": false}`, <-parts)
+		_, more := <-parts
+		assert.False(t, more)
+	})
+
+	t.Run("deferred fragment in list", func(t *testing.T) {
+		release := make(chan struct{})
+		close(release)
+		f := &queryExecutionFixture{
+			services: []testService{movieService, ratingService(release)},
+		}
+		srv := newIncrementalTestServer(t, f.setup(t))
+
+		parts := multipartQuery(t, srv.URL, `{ movies { title ...MovieRating @defer } } fragment MovieRating on Movie { rating }`)
+		assert.JSONEq(t, `{"data": {"movies": [{"title": "Movie 1"}, {"title": "Movie 2"}]}, "extensions": {"complexity": 3}, "hasNext": true}`, <-parts)
+		assert.JSONEq(t, `{"incremental": [
+			{"data": {"rating": 1}, "path": ["movies", 0]},
+			{"data": {"rating": 2}, "path": ["movies", 1]}
+		], "hasNext": false}`, <-parts)
+	})
+
+	t.Run("disabled with if argument", func(t *testing.T) {
+		release := make(chan struct{})
+		close(release)
+		f := &queryExecutionFixture{
+			services: []testService{movieService, ratingService(release)},
+		}
+		srv := newIncrementalTestServer(t, f.setup(t))
+
+		parts := multipartQuery(t, srv.URL, `{ movie(id: "1") { title ... @defer(if: false) { rating } } }`)
+		assert.JSONEq(t, `{"data": {"movie": {"title": "Movie 1", "rating": 1}}, "extensions": {"complexity": 3}}`, <-parts)
+		_, more := <-parts
+		assert.False(t, more)
+	})
+
+	t.Run("ignored by transports without incremental delivery", func(t *testing.T) {
+		release := make(chan struct{})
+		close(release)
+		f := &queryExecutionFixture{
+			services: []testService{movieService, ratingService(release)},
+			query:    `{ movie(id: "1") { title ... @defer { rating } } }`,
+			expected: `{"movie": {"title": "Movie 1", "rating": 1}}`,
+		}
+		f.checkSuccess(t)
+	})
+}
+
+func TestStream(t *testing.T) {
+	f := &queryExecutionFixture{
+		services: []testService{
+			{
+				schema: `type Movie {
+					title: String!
+					tags: [String!]!
+				}
+				type Query {
+					movies: [Movie!]!
+				}`,
+				handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					var req Request
+					require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+					assert.NotContains(t, req.Query, "@stream")
+					w.Write([]byte(`{"data": {"movies": [
+						{"title": "Movie 1", "tags": ["a", "b"]},
+						{"title": "Movie 2", "tags": ["c"]},
+						{"title": "Movie 3", "tags": []}
+					]}}`))
+				}),
+			},
+		},
+	}
+	srv := newIncrementalTestServer(t, f.setup(t))
+
+	t.Run("objects", func(t *testing.T) {
+		parts := multipartQuery(t, srv.URL, `{ movies @stream(initialCount: 1, label: "movies") { title } }`)
+		assert.JSONEq(t, `{"data": {"movies": [{"title": "Movie 1"}]}, "extensions": {"complexity": 2}, "hasNext": true}`, <-parts)
+		assert.JSONEq(t, `{"incremental": [
+			{"items": [{"title": "Movie 2"}, {"title": "Movie 3"}], "path": ["movies", 1], "label": "movies"}
+		], "hasNext": false}`, <-parts)
+	})
+
+	t.Run("scalars in list", func(t *testing.T) {
+		parts := multipartQuery(t, srv.URL, `{ movies { title tags @stream } }`)
+		assert.JSONEq(t, `{"data": {"movies": [
+			{"title": "Movie 1", "tags": []},
+			{"title": "Movie 2", "tags": []},
+			{"title": "Movie 3", "tags": []}
+		]}, "extensions": {"complexity": 3}, "hasNext": true}`, <-parts)
+		assert.JSONEq(t, `{"incremental": [
+			{"items": ["a", "b"], "path": ["movies", 0, "tags", 0]},
+			{"items": ["c"], "path": ["movies", 1, "tags", 0]}
+		], "hasNext": false}`, <-parts)
+	})
+}
+
+func TestMultipartMixedTransportGETMutation(t *testing.T) {
+	f := &queryExecutionFixture{
+		services: []testService{
+			{
+				schema: `type Query { test: String }
+				type Mutation { update: String }`,
+				handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					t.Error("the mutation was executed")
+				}),
+			},
+		},
+	}
+	srv := newIncrementalTestServer(t, f.setup(t))
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"?query="+url.QueryEscape("mutation { update }"), nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept", "multipart/mixed")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	assert.Equal(t, http.MethodPost, resp.Header.Get("Allow"))
+}
@@ -2,6 +2,7 @@ package bramble
 
 import (
 	"context"
+	"net/http"
 	"sync"
 	"time"
 
@@ -14,8 +15,11 @@ type event struct {
 	name      string
 	timestamp time.Time
 	fields    EventFields
-	fieldLock sync.Mutex
-	writeLock sync.Once
+	// slowFields are only logged for slow requests, nil when they aren't
+	// recorded
+	slowFields EventFields
+	fieldLock  sync.Mutex
+	writeLock  sync.Once
 }
 
 // EventFields contains fields to be logged for the event
@@ -48,6 +52,40 @@ func (e *event) addFields(fields EventFields) {
 	e.fieldLock.Unlock()
 }
 
+// field returns the value of the field, nil if it isn't set
+func (e *event) field(name string) interface{} {
+	e.fieldLock.Lock()
+	defer e.fieldLock.Unlock()
+	return e.fields[name]
+}
+
+// recordSlowFields enables the recording of the fields of slow requests
+func (e *event) recordSlowFields() {
+	e.fieldLock.Lock()
+	e.slowFields = EventFields{}
+	e.fieldLock.Unlock()
+}
+
+// addSlowFields adds the fields recorded for slow requests to the event
+func (e *event) addSlowFields() {
+	e.fieldLock.Lock()
+	for k, v := range e.slowFields {
+		e.fields[k] = v
+	}
+	e.fieldLock.Unlock()
+}
+
+// failed returns whether the request returned errors
+func (e *event) failed() bool {
+	e.fieldLock.Lock()
+	defer e.fieldLock.Unlock()
+	if _, ok := e.fields["errors"]; ok {
+		return true
+	}
+	status, _ := e.fields["response.status"].(int)
+	return status >= http.StatusBadRequest
+}
+
 func (e *event) finish() {
 	e.writeLock.Do(func() {
 		log.WithFields(log.Fields{
@@ -71,6 +109,35 @@ func AddFields(ctx context.Context, fields EventFields) {
 	}
 }
 
+// recordsSlowFields returns whether the event of the context records the
+// fields of slow requests
+func recordsSlowFields(ctx context.Context) bool {
+	e := getEvent(ctx)
+	if e == nil {
+		return false
+	}
+	e.fieldLock.Lock()
+	defer e.fieldLock.Unlock()
+	return e.slowFields != nil
+}
+
+// addSlowFields adds the given fields to the event contained in the context,
+// they are only logged if the request is slow
+func addSlowFields(ctx context.Context, fields EventFields) {
+	e := getEvent(ctx)
+	if e == nil {
+		return
+	}
+	e.fieldLock.Lock()
+	defer e.fieldLock.Unlock()
+	if e.slowFields == nil {
+		return
+	}
+	for k, v := range fields {
+		e.slowFields[k] = v
+	}
+}
+
 func getEvent(ctx context.Context) *event {
 	if e := ctx.Value(eventKey); e != nil {
 		if e, ok := e.(*event); ok {
@@ -3,6 +3,7 @@ package bramble
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/vektah/gqlparser/v2"
 	"github.com/vektah/gqlparser/v2/ast"
@@ -16,19 +17,53 @@ type Service struct {
 	SchemaSource string
 	Schema       *ast.Schema
 	Status       string
+	// Config is the configuration of the service
+	Config ServiceConfig
 
-	client *GraphQLClient
+	client       *GraphQLClient
+	pollInterval time.Duration
+	lastPoll     time.Time
 }
 
 // NewService returns a new Service.
 func NewService(serviceURL string) *Service {
-	s := &Service{
-		ServiceURL: serviceURL,
-		client:     NewClientWithoutKeepAlive(WithUserAgent(GenerateUserAgent("update"))),
-	}
+	s, _ := NewServiceWithConfig(ServiceConfig{URL: serviceURL})
 	return s
 }
 
+// NewServiceWithConfig returns a new Service using the settings of the
+// configuration, the settings also apply to schema polling.
+func NewServiceWithConfig(cfg ServiceConfig) (*Service, error) {
+	settings, err := cfg.settings()
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration for service %q: %w", cfg.URL, err)
+	}
+
+	client := NewClientWithoutKeepAlive(WithUserAgent(GenerateUserAgent("update")))
+	if err := client.SetServiceConfigs(cfg); err != nil {
+		return nil, err
+	}
+
+	return &Service{
+		ServiceURL:   cfg.URL,
+		Name:         cfg.Name,
+		Config:       cfg,
+		client:       client,
+		pollInterval: settings.pollInterval,
+	}, nil
+}
+
+// pollDue returns whether the service schema should be polled, services
+// without their own poll interval use the default interval. A tenth of the
+// interval is tolerated to absorb the delays of the polling ticker.
+func (s *Service) pollDue(now time.Time, defaultInterval time.Duration) bool {
+	interval := s.pollInterval
+	if interval == 0 {
+		interval = defaultInterval
+	}
+	return s.lastPoll.IsZero() || now.Sub(s.lastPoll) >= interval-interval/10
+}
+
 // Update queries the service's schema, name and version and updates its status.
 func (s *Service) Update() (bool, error) {
 	req := NewRequest("query brambleServicePoll { service { name, version, schema} }").
@@ -41,6 +76,7 @@ func (s *Service) Update() (bool, error) {
 		} `json:"service"`
 	}{}
 
+	s.lastPoll = time.Now()
 	if err := s.client.Request(context.Background(), s.ServiceURL, req, &response); err != nil {
 		s.SchemaSource = ""
 		s.Status = "Unreachable"
@@ -50,6 +86,9 @@ func (s *Service) Update() (bool, error) {
 	updated := response.Service.Schema != s.SchemaSource
 
 	s.Name = response.Service.Name
+	if s.Config.Name != "" {
+		s.Name = s.Config.Name
+	}
 	s.Version = response.Service.Version
 	s.SchemaSource = response.Service.Schema
 
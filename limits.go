@@ -0,0 +1,178 @@
+package bramble
+
+import (
+	"strings"
+
+	"github.com/99designs/gqlgen/graphql/errcode"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+const errOperationLimitCode = "OPERATION_LIMIT_EXCEEDED"
+
+// OperationLimits are the limits enforced on incoming operations, a zero
+// value means no limit.
+type OperationLimits struct {
+	// MaxComplexity is the maximum cost of an operation, see
+	// ExecutableSchema.Complexity
+	MaxComplexity int `json:"max-complexity,omitempty"`
+	// MaxDepth is the maximum nesting of fields, root fields have a depth of 1
+	MaxDepth int `json:"max-depth,omitempty"`
+	// MaxFields is the maximum number of fields, fragments are counted every
+	// time they are spread
+	MaxFields int `json:"max-fields,omitempty"`
+	// MaxAliases is the maximum number of aliased fields in a selection set
+	MaxAliases int `json:"max-aliases,omitempty"`
+	// MaxRootFields is the maximum number of root fields
+	MaxRootFields int `json:"max-root-fields,omitempty"`
+}
+
+// Override returns the limits with the non-zero limits of o replacing the
+// current ones
+func (l OperationLimits) Override(o OperationLimits) OperationLimits {
+	if o.MaxComplexity != 0 {
+		l.MaxComplexity = o.MaxComplexity
+	}
+	if o.MaxDepth != 0 {
+		l.MaxDepth = o.MaxDepth
+	}
+	if o.MaxFields != 0 {
+		l.MaxFields = o.MaxFields
+	}
+	if o.MaxAliases != 0 {
+		l.MaxAliases = o.MaxAliases
+	}
+	if o.MaxRootFields != 0 {
+		l.MaxRootFields = o.MaxRootFields
+	}
+	return l
+}
+
+// mergeOperationLimits returns the most permissive of the given limits. A
+// zero limit is unset, so the largest limit set is kept.
+func mergeOperationLimits(limits ...OperationLimits) OperationLimits {
+	var res OperationLimits
+	for i, l := range limits {
+		if i == 0 {
+			res = l
+			continue
+		}
+		res.MaxComplexity = mergeLimit(res.MaxComplexity, l.MaxComplexity)
+		res.MaxDepth = mergeLimit(res.MaxDepth, l.MaxDepth)
+		res.MaxFields = mergeLimit(res.MaxFields, l.MaxFields)
+		res.MaxAliases = mergeLimit(res.MaxAliases, l.MaxAliases)
+		res.MaxRootFields = mergeLimit(res.MaxRootFields, l.MaxRootFields)
+	}
+	return res
+}
+
+func mergeLimit(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// operationLimits returns the limits applying to the request, the limits of
+// the role override the gateway limits
+func (s *ExecutableSchema) operationLimits(perms OperationPermissions, hasPerms bool) OperationLimits {
+	if !hasPerms {
+		return s.Limits
+	}
+	return s.Limits.Override(perms.Limits)
+}
+
+// checkOperationLimits returns an error for every limit exceeded by the
+// operation
+func checkOperationLimits(operation *ast.OperationDefinition, limits OperationLimits) gqlerror.List {
+	if limits.MaxDepth == 0 && limits.MaxFields == 0 && limits.MaxAliases == 0 && limits.MaxRootFields == 0 {
+		return nil
+	}
+
+	stats := selectionSetStats(operation.SelectionSet, make(map[string]operationStats))
+
+	var errs gqlerror.List
+	check := func(name, description string, value, limit int) {
+		if limit == 0 || value <= limit {
+			return
+		}
+		err := gqlerror.Errorf("operation has %d %s, which exceeds the limit of %d", value, description, limit)
+		errcode.Set(err, errOperationLimitCode)
+		err.Extensions["limit"] = name
+		err.Extensions["value"] = value
+		err.Extensions["max"] = limit
+		errs = append(errs, err)
+	}
+	check("max-depth", "levels of nesting", stats.depth, limits.MaxDepth)
+	check("max-fields", "fields", stats.fields, limits.MaxFields)
+	check("max-aliases", "aliases in a selection set", stats.maxAliases, limits.MaxAliases)
+	check("max-root-fields", "root fields", stats.directFields, limits.MaxRootFields)
+	return errs
+}
+
+// operationStats are the measures of a selection set checked against the
+// operation limits
+type operationStats struct {
+	// fields is the total number of fields
+	fields int
+	// depth is the maximum nesting of fields
+	depth int
+	// directFields and aliases are the number of fields and aliases directly
+	// in the selection set, including the fields of its fragments
+	directFields int
+	aliases      int
+	// maxAliases is the maximum number of aliases in the selection set or any
+	// of its nested selection sets
+	maxAliases int
+}
+
+// selectionSetStats returns the measures of the selection set. The measures
+// of fragments are memoized, so that spreading the same fragment many times
+// doesn't make the computation grow exponentially. Introspection fields are
+// not counted.
+func selectionSetStats(selectionSet ast.SelectionSet, fragments map[string]operationStats) operationStats {
+	var stats operationStats
+	addFragment := func(fragment operationStats) {
+		stats.fields = saturatingAdd(stats.fields, fragment.fields)
+		stats.directFields = saturatingAdd(stats.directFields, fragment.directFields)
+		stats.aliases = saturatingAdd(stats.aliases, fragment.aliases)
+		stats.depth = maxInt(stats.depth, fragment.depth)
+		stats.maxAliases = maxInt(stats.maxAliases, fragment.maxAliases)
+	}
+
+	for _, selection := range selectionSet {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			if strings.HasPrefix(selection.Name, "__") {
+				continue
+			}
+			child := selectionSetStats(selection.SelectionSet, fragments)
+			stats.fields = saturatingAdd(stats.fields, saturatingAdd(1, child.fields))
+			stats.directFields = saturatingAdd(stats.directFields, 1)
+			if selection.Alias != "" && selection.Alias != selection.Name {
+				stats.aliases = saturatingAdd(stats.aliases, 1)
+			}
+			stats.depth = maxInt(stats.depth, saturatingAdd(1, child.depth))
+			stats.maxAliases = maxInt(stats.maxAliases, child.maxAliases)
+		case *ast.InlineFragment:
+			addFragment(selectionSetStats(selection.SelectionSet, fragments))
+		case *ast.FragmentSpread:
+			fragment, ok := fragments[selection.Name]
+			if !ok && selection.Definition != nil {
+				fragment = selectionSetStats(selection.Definition.SelectionSet, fragments)
+				fragments[selection.Name] = fragment
+			}
+			addFragment(fragment)
+		}
+	}
+
+	stats.maxAliases = maxInt(stats.maxAliases, stats.aliases)
+	return stats
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
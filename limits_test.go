@@ -0,0 +1,132 @@
+package bramble
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+func TestOperationLimits(t *testing.T) {
+	schema := gqlparser.MustLoadSchema(&ast.Source{Input: `
+	type Movie {
+		id: ID!
+		title: String!
+		related: [Movie!]!
+	}
+
+	type Query {
+		movie(id: ID!): Movie
+		movies: [Movie!]!
+	}`})
+
+	stats := func(query string) operationStats {
+		doc := gqlparser.MustLoadQuery(schema, query)
+		return selectionSetStats(doc.Operations[0].SelectionSet, make(map[string]operationStats))
+	}
+
+	t.Run("depth and fields", func(t *testing.T) {
+		s := stats(`{ movies { title related { title related { id } } } }`)
+		assert.Equal(t, 4, s.depth)
+		assert.Equal(t, 6, s.fields)
+		assert.Equal(t, 1, s.directFields)
+	})
+
+	t.Run("fragments", func(t *testing.T) {
+		s := stats(`
+		query {
+			movies { ...MovieFields }
+			movie(id: "1") { ...MovieFields ... on Movie { id } }
+		}
+		fragment MovieFields on Movie { title related { id } }`)
+		assert.Equal(t, 3, s.depth)
+		assert.Equal(t, 9, s.fields)
+		assert.Equal(t, 2, s.directFields)
+	})
+
+	t.Run("aliases are counted per selection set", func(t *testing.T) {
+		s := stats(`
+		query {
+			a: movie(id: "1") { t1: title t2: title ...Aliases }
+			b: movie(id: "2") { t1: title }
+			movies { id }
+		}
+		fragment Aliases on Movie { t3: title }`)
+		assert.Equal(t, 3, s.maxAliases)
+		assert.Equal(t, 3, s.directFields)
+	})
+
+	t.Run("introspection fields are ignored", func(t *testing.T) {
+		s := stats(`{ __typename __schema { types { name fields { name type { name ofType { name } } } } } movies { id } }`)
+		assert.Equal(t, 2, s.depth)
+		assert.Equal(t, 2, s.fields)
+		assert.Equal(t, 1, s.directFields)
+	})
+
+	t.Run("fragments spread many times are only measured once", func(t *testing.T) {
+		// every fragment spreads the previous one twice, the expanded
+		// operation has 2^30 fields
+		var sb strings.Builder
+		sb.WriteString(`query { movies { ...F30 } }
+		fragment F0 on Movie { id }`)
+		for i := 1; i <= 30; i++ {
+			fmt.Fprintf(&sb, "\nfragment F%d on Movie { ...F%d related { ...F%d } }", i, i-1, i-1)
+		}
+		s := stats(sb.String())
+		assert.Equal(t, 32, s.depth)
+		assert.Greater(t, s.fields, 1<<30)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		doc := gqlparser.MustLoadQuery(schema, `{ a: movie(id: "1") { title } b: movie(id: "2") { related { title } } }`)
+		errs := checkOperationLimits(doc.Operations[0], OperationLimits{
+			MaxDepth:      2,
+			MaxFields:     5,
+			MaxAliases:    1,
+			MaxRootFields: 2,
+		})
+		require.Len(t, errs, 2)
+		assert.Equal(t, "operation has 3 levels of nesting, which exceeds the limit of 2", errs[0].Message)
+		assert.Equal(t, errOperationLimitCode, errs[0].Extensions["code"])
+		assert.Equal(t, "max-depth", errs[0].Extensions["limit"])
+		assert.Equal(t, 3, errs[0].Extensions["value"])
+		assert.Equal(t, 2, errs[0].Extensions["max"])
+		assert.Equal(t, "operation has 2 aliases in a selection set, which exceeds the limit of 1", errs[1].Message)
+
+		assert.Empty(t, checkOperationLimits(doc.Operations[0], OperationLimits{}))
+	})
+}
+
+func TestOperationLimitsExecution(t *testing.T) {
+	f := &queryExecutionFixture{
+		services: []testService{
+			{
+				schema: `type Query { a: String b: String }`,
+				handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Write([]byte(`{"data": {"a": "A", "b": "B"}}`))
+				}),
+			},
+		},
+	}
+	es := f.setup(t)
+	es.Limits = OperationLimits{MaxRootFields: 1}
+	query := gqlparser.MustLoadQuery(f.mergedSchema, `{ a b }`)
+
+	resp := es.ExecuteQuery(testContextWithVariables(nil, query.Operations[0]))
+	require.Len(t, resp.Errors, 1)
+	assert.Equal(t, "operation has 2 root fields, which exceeds the limit of 1", resp.Errors[0].Message)
+	assert.Nil(t, resp.Data)
+
+	ctx := AddPermissionsToContext(testContextWithVariables(nil, query.Operations[0]), OperationPermissions{
+		AllowedRootQueryFields: AllowedFields{AllowAll: true},
+		Limits:                 OperationLimits{MaxRootFields: 2},
+	})
+	resp = es.ExecuteQuery(ctx)
+	require.Empty(t, resp.Errors)
+	assert.JSONEq(t, `{"a": "A", "b": "B"}`, string(resp.Data))
+}
@@ -41,7 +41,7 @@ func Main() {
 	gtw := NewGateway(cfg.executableSchema, cfg.plugins)
 	RegisterMetrics()
 
-	go gtw.UpdateSchemas(cfg.PollIntervalDuration)
+	go gtw.updateSchemas(gtw.ExecutableSchema.schemaPollInterval, gtw.ExecutableSchema.pollUpdates)
 
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer cancel()
@@ -54,6 +54,12 @@ func Main() {
 	go runHandler(ctx, &wg, "public", cfg.GatewayAddress(), gtw.Router(cfg))
 
 	wg.Wait()
+
+	if cfg.tracerProvider != nil {
+		if err := shutdownTracing(cfg.tracerProvider, 5*time.Second); err != nil {
+			log.WithError(err).Error("error flushing traces")
+		}
+	}
 }
 
 func runHandler(ctx context.Context, wg *sync.WaitGroup, name, addr string, handler http.Handler) {
@@ -47,6 +47,9 @@ func MergeSchemas(schemas ...*ast.Schema) (*ast.Schema, error) {
 	merged.Implements = mergeImplements(schemas)
 	merged.PossibleTypes = mergePossibleTypes(schemas, merged.Types)
 	merged.Directives = mergeDirectives(schemas)
+	for name, definition := range incrementalDeliveryDirectives {
+		merged.Directives[name] = definition
+	}
 
 	merged.Query = merged.Types[queryObjectName]
 	merged.Mutation = merged.Types[mutationObjectName]
@@ -112,6 +115,12 @@ func buildBoundaryFieldsMap(services ...*Service) BoundaryFieldsMap {
 				result.RegisterField(rs.ServiceURL, typeName, f.Name, f.Arguments[0].Name, array)
 			}
 		}
+		for typeName, field := range result[rs.ServiceURL] {
+			if def := rs.Schema.Types[typeName]; def != nil {
+				field.MaxAge = cacheControlMaxAge(def.Directives)
+				result[rs.ServiceURL][typeName] = field
+			}
+		}
 	}
 	return result
 }
@@ -148,6 +157,11 @@ func mergeTypes(a, b map[string]*ast.Definition) (map[string]*ast.Definition, er
 			continue
 		}
 
+		// the scope enum of @cacheControl is defined by every service using it
+		if k == cacheControlScopeTypeName {
+			continue
+		}
+
 		if newVB.Kind != va.Kind {
 			return nil, fmt.Errorf("name collision: %s(%s) conflicts with %s(%s)", newVB.Name, newVB.Kind, va.Name, va.Kind)
 		}
@@ -162,7 +176,7 @@ func mergeTypes(a, b map[string]*ast.Definition) (map[string]*ast.Definition, er
 		}
 
 		if !hasFederationDirectives(&newVB) || !hasFederationDirectives(va) {
-			if k != queryObjectName && k != mutationObjectName {
+			if !isRootObjectName(k) {
 				if newVB.Kind == ast.Interface {
 					return nil, fmt.Errorf("conflicting interface: %s (interfaces may not span multiple services)", k)
 				}
@@ -293,7 +307,7 @@ func mergeBoundaryObjects(aTypes, bTypes map[string]*ast.Definition, a, b *ast.D
 		Kind:        ast.Object,
 		Description: mergeDescriptions(a, b),
 		Name:        a.Name,
-		Directives:  a.Directives.ForNames(boundaryDirectiveName),
+		Directives:  append(a.Directives.ForNames(boundaryDirectiveName), mergeCacheControlDirectives(a.Directives, b.Directives)...),
 		Interfaces:  append(a.Interfaces, b.Interfaces...),
 		Fields:      nil,
 	}
@@ -391,7 +405,8 @@ func cleanFields(fields ast.FieldList) ast.FieldList {
 
 func allowedDirective(name string) bool {
 	switch name {
-	case boundaryDirectiveName, namespaceDirectiveName, "skip", "include", "deprecated", skipMergeDirectiveName:
+	case boundaryDirectiveName, namespaceDirectiveName, "skip", "include", "deprecated", skipMergeDirectiveName,
+		costDirectiveName, listSizeDirectiveName, cacheControlDirectiveName, sensitiveDirectiveName:
 		return true
 	default:
 		return false
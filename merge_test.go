@@ -105,6 +105,57 @@ func TestMergeTwoSchemasNoBoundaryTypes(t *testing.T) {
 	fixture.CheckSuccess(t)
 }
 
+func TestMergeSubscriptionsFromTwoSchemas(t *testing.T) {
+	fixture := MergeTestFixture{
+		Input1: `
+			type Gizmo {
+				id: ID!
+			}
+
+			type Query {
+				gizmo(id: ID!): Gizmo!
+			}
+
+			type Subscription {
+				gizmoCreated: Gizmo!
+			}
+		`,
+		Input2: `
+			type Gimmick {
+				id: ID!
+			}
+
+			type Query {
+				gimmick(id: ID!): Gimmick!
+			}
+
+			type Subscription {
+				gimmickCreated: Gimmick!
+			}
+		`,
+		Expected: `
+			type Gizmo {
+				id: ID!
+			}
+
+			type Gimmick {
+				id: ID!
+			}
+
+			type Query {
+				gimmick(id: ID!): Gimmick!
+				gizmo(id: ID!): Gizmo!
+			}
+
+			type Subscription {
+				gimmickCreated: Gimmick!
+				gizmoCreated: Gizmo!
+			}
+		`,
+	}
+	fixture.CheckSuccess(t)
+}
+
 func TestMergeTwoSchemasWithCollidingInterface(t *testing.T) {
 	fixture := MergeTestFixture{
 		Input1: `
@@ -0,0 +1,118 @@
+package bramble
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	defaultClientNameHeader     = "apollographql-client-name"
+	defaultClientVersionHeader  = "apollographql-client-version"
+	defaultMaxLabelCombinations = 1000
+	// otherLabelValue replaces the label values beyond the maximum number of
+	// distinct label combinations
+	otherLabelValue = "other"
+)
+
+// MetricsConfig is the configuration of the operation and client labels of
+// the HTTP metrics
+type MetricsConfig struct {
+	// ClientNameHeader is the request header holding the name of the client,
+	// defaults to apollographql-client-name
+	ClientNameHeader string `json:"client-name-header"`
+	// ClientVersionHeader is the request header holding the version of the
+	// client, defaults to apollographql-client-version
+	ClientVersionHeader string `json:"client-version-header"`
+	// MaxLabelCombinations is the maximum number of distinct combinations of
+	// the operation and client labels, the labels of further combinations
+	// are reported as "other". Defaults to 1000.
+	MaxLabelCombinations int `json:"max-label-combinations"`
+}
+
+// MetricLabels computes the operation and client labels of the HTTP metrics
+type MetricLabels struct {
+	clientNameHeader    string
+	clientVersionHeader string
+	maxCombinations     int
+
+	mutex        sync.RWMutex
+	combinations map[metricLabelCombination]struct{}
+}
+
+type metricLabelCombination struct {
+	operation     string
+	clientName    string
+	clientVersion string
+}
+
+// NewMetricLabels returns the metric labels for the configuration
+func NewMetricLabels(cfg MetricsConfig) *MetricLabels {
+	if cfg.ClientNameHeader == "" {
+		cfg.ClientNameHeader = defaultClientNameHeader
+	}
+	if cfg.ClientVersionHeader == "" {
+		cfg.ClientVersionHeader = defaultClientVersionHeader
+	}
+	if cfg.MaxLabelCombinations <= 0 {
+		cfg.MaxLabelCombinations = defaultMaxLabelCombinations
+	}
+	return &MetricLabels{
+		clientNameHeader:    cfg.ClientNameHeader,
+		clientVersionHeader: cfg.ClientVersionHeader,
+		maxCombinations:     cfg.MaxLabelCombinations,
+		combinations:        make(map[metricLabelCombination]struct{}),
+	}
+}
+
+// labels returns the labels of the request. The operation name is the name
+// of the operation executed for the request, it is empty when the operation
+// couldn't be parsed and validated. The labels are empty when the metric
+// labels are disabled.
+func (m *MetricLabels) labels(r *http.Request, operationName string) prometheus.Labels {
+	if m == nil {
+		return prometheus.Labels{
+			"operation":      "",
+			"client_name":    "",
+			"client_version": "",
+		}
+	}
+	combination := m.combination(metricLabelCombination{
+		operation:     operationName,
+		clientName:    r.Header.Get(m.clientNameHeader),
+		clientVersion: r.Header.Get(m.clientVersionHeader),
+	})
+	return prometheus.Labels{
+		"operation":      combination.operation,
+		"client_name":    combination.clientName,
+		"client_version": combination.clientVersion,
+	}
+}
+
+// combination returns the combination if it is known or if the maximum
+// number of combinations isn't reached, the first combinations seen are kept
+// and the others are replaced by "other"
+func (m *MetricLabels) combination(combination metricLabelCombination) metricLabelCombination {
+	m.mutex.RLock()
+	_, ok := m.combinations[combination]
+	m.mutex.RUnlock()
+	if ok {
+		return combination
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.combinations[combination]; ok {
+		return combination
+	}
+	if len(m.combinations) >= m.maxCombinations {
+		return metricLabelCombination{
+			operation:     otherLabelValue,
+			clientName:    otherLabelValue,
+			clientVersion: otherLabelValue,
+		}
+	}
+	m.combinations[combination] = struct{}{}
+	return combination
+}
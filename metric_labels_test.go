@@ -0,0 +1,90 @@
+package bramble
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricLabels(t *testing.T) {
+	t.Run("labels from operation and headers", func(t *testing.T) {
+		labels := NewMetricLabels(MetricsConfig{})
+		req := httptest.NewRequest(http.MethodPost, "/query", nil)
+		req.Header.Set("apollographql-client-name", "web")
+		req.Header.Set("apollographql-client-version", "1.2.0")
+		assert.Equal(t, prometheus.Labels{
+			"operation":      "GetMovie",
+			"client_name":    "web",
+			"client_version": "1.2.0",
+		}, labels.labels(req, "GetMovie"))
+	})
+
+	t.Run("labels from custom headers", func(t *testing.T) {
+		labels := NewMetricLabels(MetricsConfig{ClientNameHeader: "X-Client", ClientVersionHeader: "X-Client-Version"})
+		req := httptest.NewRequest(http.MethodGet, "/query", nil)
+		req.Header.Set("X-Client", "ios")
+		req.Header.Set("X-Client-Version", "3")
+		assert.Equal(t, prometheus.Labels{
+			"operation":      "GetMovie",
+			"client_name":    "ios",
+			"client_version": "3",
+		}, labels.labels(req, "GetMovie"))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		var labels *MetricLabels
+		req := httptest.NewRequest(http.MethodGet, "/query", nil)
+		assert.Equal(t, prometheus.Labels{
+			"operation":      "",
+			"client_name":    "",
+			"client_version": "",
+		}, labels.labels(req, "GetMovie"))
+	})
+
+	t.Run("combinations beyond the maximum are folded into other", func(t *testing.T) {
+		labels := NewMetricLabels(MetricsConfig{MaxLabelCombinations: 2})
+		var operations []string
+		for _, request := range []struct{ operation, client string }{
+			{"A", "web"}, {"A", "ios"}, {"B", "web"}, {"A", "web"}, {"A", "android"}, {"A", "ios"},
+		} {
+			req := httptest.NewRequest(http.MethodGet, "/query", nil)
+			req.Header.Set("apollographql-client-name", request.client)
+			l := labels.labels(req, request.operation)
+			operations = append(operations, l["operation"]+"/"+l["client_name"])
+		}
+		assert.Equal(t, []string{"A/web", "A/ios", "other/other", "A/web", "other/other", "A/ios"}, operations)
+	})
+}
+
+func TestMonitoringMiddlewareMetricLabels(t *testing.T) {
+	handler := monitoringMiddleware(NewMetricLabels(MetricsConfig{}), nil, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Valid") != "" {
+			AddField(r.Context(), "operation.name", "MonitoredMovie")
+		}
+		w.Write([]byte(`{"data": {}}`))
+	}))
+
+	request := func(valid bool) {
+		req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"query": "query MonitoredMovie { movie { id } }", "operationName": "MonitoredMovie"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("apollographql-client-name", "monitored")
+		req.Header.Set("apollographql-client-version", "1.2.0")
+		if valid {
+			req.Header.Set("X-Valid", "1")
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	validated := promHTTPRequestCounter.WithLabelValues("2XX", "MonitoredMovie", "monitored", "1.2.0")
+	unvalidated := promHTTPRequestCounter.WithLabelValues("2XX", "", "monitored", "1.2.0")
+	validatedCount, unvalidatedCount := testutil.ToFloat64(validated), testutil.ToFloat64(unvalidated)
+	request(true)
+	request(false)
+
+	assert.Equal(t, validatedCount+1, testutil.ToFloat64(validated))
+	assert.Equal(t, unvalidatedCount+1, testutil.ToFloat64(unvalidated), "the operation name of the body is ignored until the operation is validated")
+}
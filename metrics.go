@@ -45,6 +45,127 @@ var (
 		},
 	)
 
+	// promServiceCoalescingCounter is a counter of coalesced requests to
+	// services, the result is "sent" for requests sent to the service and
+	// "shared" for requests that used the response of an identical request
+	promServiceCoalescingCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "service_coalescing_total",
+			Help: "A counter indicating how many requests to services were sent or shared an identical in-flight request",
+		},
+		[]string{
+			"service",
+			"result",
+		},
+	)
+
+	// promServiceRetryCounter is a counter of retried requests to services
+	promServiceRetryCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "service_retry_total",
+			Help: "A counter indicating how many times requests to services have been retried",
+		},
+		[]string{
+			"service",
+		},
+	)
+
+	// promServiceCircuitBreakerState is a gauge set to 1 for the current
+	// circuit breaker state of every service
+	promServiceCircuitBreakerState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "service_circuit_breaker_state",
+			Help: "A gauge set to 1 for the current circuit breaker state of services",
+		},
+		[]string{
+			"service",
+			"state",
+		},
+	)
+
+	// promServiceRequestCounter is a counter of requests sent to services
+	// while executing queries, every attempt of retried requests is counted.
+	// The kind is "root" or "boundary" and the outcome is one of "success",
+	// "graphql_error", "timeout", "canceled", "circuit_open" or "error"
+	promServiceRequestCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "service_requests_total",
+			Help: "A counter indicating how many requests were sent to services while executing queries",
+		},
+		[]string{
+			"service",
+			"kind",
+			"operation_type",
+			"outcome",
+		},
+	)
+
+	// promServiceRequestDurations is a histogram of the latencies of every
+	// attempt of the requests sent to services
+	promServiceRequestDurations = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "service_request_duration_seconds",
+			Help:    "A histogram of the latencies of requests sent to services",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{
+			"service",
+			"kind",
+			"operation_type",
+			"outcome",
+		},
+	)
+
+	// promServiceBoundaryIDs is a histogram of the number of boundary ids
+	// fetched by a request to a service
+	promServiceBoundaryIDs = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "service_boundary_ids",
+			Help:    "A histogram of the number of boundary ids fetched per request to services",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 10),
+		},
+		[]string{
+			"service",
+			"type",
+		},
+	)
+
+	// promPlanCacheHitCounter is a counter of query plans served from the plan cache
+	promPlanCacheHitCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "plan_cache_hit_total",
+		Help: "A counter indicating how many query plans were served from the plan cache",
+	})
+
+	// promPlanCacheMissCounter is a counter of query plans missing from the plan cache
+	promPlanCacheMissCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "plan_cache_miss_total",
+		Help: "A counter indicating how many query plans were missing from the plan cache",
+	})
+
+	// promEntityCacheHitCounter is a counter of boundary entities served from the entity cache
+	promEntityCacheHitCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "entity_cache_hit_total",
+			Help: "A counter indicating how many boundary entities were served from the entity cache",
+		},
+		[]string{
+			"service",
+			"type",
+		},
+	)
+
+	// promEntityCacheMissCounter is a counter of boundary entities missing from the entity cache
+	promEntityCacheMissCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "entity_cache_miss_total",
+			Help: "A counter indicating how many boundary entities were missing from the entity cache",
+		},
+		[]string{
+			"service",
+			"type",
+		},
+	)
+
 	// promHTTPInFlightGauge is a gauge of requests currently being served by the wrapped handler
 	promHTTPInFlightGauge = prometheus.NewGauge(prometheus.GaugeOpts{
 		Name: "http_in_flight_requests",
@@ -57,7 +178,12 @@ var (
 			Name: "http_api_requests_total",
 			Help: "A counter for served requests",
 		},
-		[]string{"code"},
+		[]string{
+			"code",
+			"operation",
+			"client_name",
+			"client_version",
+		},
 	)
 
 	// promHTTPResponseDurations is a histogram of request latencies
@@ -67,7 +193,11 @@ var (
 			Help:    "A histogram of request latencies",
 			Buckets: prometheus.DefBuckets,
 		},
-		[]string{},
+		[]string{
+			"operation",
+			"client_name",
+			"client_version",
+		},
 	)
 
 	// promHTTPRequestSizes is a histogram of request sizes for requests
@@ -97,6 +227,16 @@ func RegisterMetrics() {
 	prometheus.MustRegister(promServiceTimeoutErrorCounter)
 	prometheus.MustRegister(promServiceUpdateErrorCounter)
 	prometheus.MustRegister(promServiceUpdateErrorGauge)
+	prometheus.MustRegister(promServiceRetryCounter)
+	prometheus.MustRegister(promServiceCoalescingCounter)
+	prometheus.MustRegister(promServiceCircuitBreakerState)
+	prometheus.MustRegister(promServiceRequestCounter)
+	prometheus.MustRegister(promServiceRequestDurations)
+	prometheus.MustRegister(promServiceBoundaryIDs)
+	prometheus.MustRegister(promPlanCacheHitCounter)
+	prometheus.MustRegister(promPlanCacheMissCounter)
+	prometheus.MustRegister(promEntityCacheHitCounter)
+	prometheus.MustRegister(promEntityCacheMissCounter)
 	prometheus.MustRegister(promHTTPInFlightGauge)
 	prometheus.MustRegister(promHTTPRequestCounter)
 	prometheus.MustRegister(promHTTPResponseDurations)
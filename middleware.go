@@ -6,11 +6,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"strings"
 
 	"github.com/felixge/httpsnoop"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vektah/gqlparser/v2/ast"
 )
 
 type middleware func(http.Handler) http.Handler
@@ -60,67 +62,87 @@ func debugMiddleware(h http.Handler) http.Handler {
 	})
 }
 
-func monitoringMiddleware(h http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ctx, event := startEvent(r.Context(), "request")
-		if !strings.HasPrefix(r.Header.Get("user-agent"), "Bramble") {
-			defer event.finish()
-		}
-
-		if host := r.Header.Get("X-Forwarded-Host"); host != "" {
-			event.addField("forwarded_host", host)
-		}
-
-		var buf bytes.Buffer
-		_, err := io.Copy(&buf, r.Body)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-		r.Body = io.NopCloser(&buf)
+// monitoringMiddleware records the metrics and the event of the requests. The
+// metrics are labelled by operation and client when metricLabels is not nil.
+// The request bodies are logged according to logging, the schema is used to
+// mask sensitive values.
+func monitoringMiddleware(metricLabels *MetricLabels, logging *RequestLogging, executableSchema *ExecutableSchema) middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, event := startEvent(r.Context(), "request")
+			logging.startEvent(event)
+			if !strings.HasPrefix(r.Header.Get("user-agent"), "Bramble") {
+				defer logging.finishEvent(event)
+			}
 
-		r = r.WithContext(ctx)
+			if host := r.Header.Get("X-Forwarded-Host"); host != "" {
+				event.addField("forwarded_host", host)
+			}
 
-		addRequestBody(event, r, buf)
+			var buf bytes.Buffer
+			_, err := io.Copy(&buf, r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			r.Body = io.NopCloser(&buf)
 
-		m := httpsnoop.CaptureMetrics(h, w, r)
+			r = r.WithContext(ctx)
 
-		event.addFields(EventFields{
-			"response.status": m.Code,
-			"request.path":    r.URL.Path,
-			"response.size":   m.Written,
+			var schema *ast.Schema
+			if executableSchema != nil {
+				schema = executableSchema.Schema()
+			}
+			addRequestBody(event, r, buf, logging, schema)
+
+			m := httpsnoop.CaptureMetrics(h, w, r)
+
+			// the operation name is only set once the operation is parsed
+			// and validated
+			operationName, _ := event.field("operation.name").(string)
+			labels := metricLabels.labels(r, operationName)
+
+			event.addFields(EventFields{
+				"response.status": m.Code,
+				"request.path":    r.URL.Path,
+				"response.size":   m.Written,
+			})
+
+			promHTTPResponseDurations.With(labels).Observe(m.Duration.Seconds())
+			labels["code"] = fmt.Sprintf("%dXX", m.Code/100)
+			promHTTPRequestCounter.With(labels).Inc()
+			promHTTPRequestSizes.With(prometheus.Labels{}).Observe(float64(buf.Len()))
+			promHTTPResponseSizes.With(prometheus.Labels{}).Observe(float64(m.Written))
 		})
-
-		promHTTPRequestCounter.With(prometheus.Labels{
-			"code": fmt.Sprintf("%dXX", m.Code/100),
-		}).Inc()
-		promHTTPRequestSizes.With(prometheus.Labels{}).Observe(float64(buf.Len()))
-		promHTTPResponseSizes.With(prometheus.Labels{}).Observe(float64(m.Written))
-		promHTTPResponseDurations.With(prometheus.Labels{}).Observe(m.Duration.Seconds())
-	})
+	}
 }
 
-func addRequestBody(e *event, r *http.Request, buf bytes.Buffer) {
+func addRequestBody(e *event, r *http.Request, buf bytes.Buffer, logging *RequestLogging, schema *ast.Schema) {
 	contentType := r.Header.Get("Content-Type")
 	e.addField("request.content-type", contentType)
+	if logging.bodyDisabled() {
+		return
+	}
 
+	mediaType, _, _ := mime.ParseMediaType(contentType)
 	if r.Method != http.MethodHead &&
 		r.Method != http.MethodGet &&
-		contentType == "application/json" {
+		mediaType == "application/json" {
 		var payload map[string]interface{}
 		if err := json.Unmarshal(buf.Bytes(), &payload); err == nil {
+			logging.redactPayload(schema, payload)
 			if _, ok := payload["query"]; ok {
 				if query, ok := payload["query"].(string); ok {
 					payload["query"] = strings.Replace(query, "\n", "", -1)
 				}
 			}
-			e.addField("request.body", &payload)
+			logging.addBody(e, &payload)
 		} else {
-			e.addField("request.body", buf.String())
+			logging.addBody(e, buf.String())
 			e.addField("request.error", err)
 		}
 	} else {
-		e.addField("request.body", buf.String())
+		logging.addBody(e, buf.String())
 	}
 }
 
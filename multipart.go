@@ -0,0 +1,117 @@
+package bramble
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/99designs/gqlgen/graphql"
+	log "github.com/sirupsen/logrus"
+)
+
+const multipartMixedContentType = "multipart/mixed"
+
+// MultipartMixedTransport is a gqlgen transport writing every result of the
+// operation as a part of a multipart/mixed response. It is used to deliver
+// the incremental payloads of @defer and @stream over HTTP, see
+// https://github.com/graphql/graphql-over-http/blob/main/rfcs/IncrementalDelivery.md
+type MultipartMixedTransport struct{}
+
+var _ graphql.Transport = MultipartMixedTransport{}
+
+// Supports returns whether the request accepts a multipart/mixed response
+func (t MultipartMixedTransport) Supports(r *http.Request) bool {
+	if r.Header.Get("Upgrade") != "" {
+		return false
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		return false
+	}
+	return acceptsMediaType(r, multipartMixedContentType)
+}
+
+// Do executes the operation and writes every result as a JSON part
+func (t MultipartMixedTransport) Do(w http.ResponseWriter, r *http.Request, exec graphql.GraphExecutor) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	start := graphql.Now()
+	params, err := readRawParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	params.ReadTime = graphql.TraceTiming{
+		Start: start,
+		End:   graphql.Now(),
+	}
+
+	ctx := withIncrementalDelivery(r.Context())
+
+	rc, errs := exec.CreateOperationContext(ctx, params)
+	if errs == nil && isGETMutation(r, rc) {
+		rejectGETMutation(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", multipartMixedContentType+`; boundary="-"; deferSpec=20220824`)
+	w.WriteHeader(http.StatusOK)
+	writer := &multipartWriter{w: w, flusher: flusher}
+	defer writer.close()
+
+	if errs != nil {
+		writer.write(exec.DispatchError(graphql.WithOperationContext(ctx, rc), errs))
+		return
+	}
+
+	responses, ctx := exec.DispatchOperation(ctx, rc)
+	for {
+		response := responses(ctx)
+		if response == nil {
+			return
+		}
+		if err := writer.write(response); err != nil {
+			return
+		}
+	}
+}
+
+type multipartWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	started bool
+}
+
+func (m *multipartWriter) write(response *graphql.Response) error {
+	data, err := marshalResponse(response)
+	if err != nil {
+		log.WithError(err).Error("unable to encode multipart response")
+		return err
+	}
+	if !m.started {
+		m.started = true
+		if _, err := fmt.Fprint(m.w, "\r\n---"); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(m.w, "\r\nContent-Type: application/json; charset=utf-8\r\n\r\n%s\r\n---", data); err != nil {
+		log.WithError(err).Debug("multipart response write error")
+		return err
+	}
+	m.flusher.Flush()
+	return nil
+}
+
+// close writes the closing boundary
+func (m *multipartWriter) close() {
+	if !m.started {
+		return
+	}
+	if _, err := fmt.Fprint(m.w, "--\r\n"); err != nil {
+		log.WithError(err).Debug("multipart response write error")
+		return
+	}
+	m.flusher.Flush()
+}
@@ -0,0 +1,175 @@
+package bramble
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/99designs/gqlgen/graphql"
+	lru "github.com/hashicorp/golang-lru"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	persistedQueryStoreMemory = "memory"
+	persistedQueryStoreFile   = "file"
+
+	defaultPersistedQueryCacheSize = 1000
+)
+
+// PersistedQueryStore stores the queries registered by clients using
+// automatic persisted queries, indexed by their sha256 hash.
+type PersistedQueryStore interface {
+	// Get returns the query for the hash
+	Get(ctx context.Context, hash string) (string, bool)
+	// Add stores the query, the hash has already been verified
+	Add(ctx context.Context, hash string, query string)
+}
+
+// PersistedQueriesConfig is the configuration of automatic persisted queries
+type PersistedQueriesConfig struct {
+	// Store is the type of store, either "memory" (default) or "file"
+	Store string `json:"store"`
+	// CacheSize is the maximum number of queries kept by the memory store
+	CacheSize int `json:"cache-size"`
+	// Directory is where the file store saves the queries
+	Directory string `json:"directory"`
+}
+
+// NewPersistedQueryStore returns the store described by the configuration
+func NewPersistedQueryStore(cfg PersistedQueriesConfig) (PersistedQueryStore, error) {
+	switch cfg.Store {
+	case "", persistedQueryStoreMemory:
+		size := cfg.CacheSize
+		if size == 0 {
+			size = defaultPersistedQueryCacheSize
+		}
+		return NewMemoryPersistedQueryStore(size)
+	case persistedQueryStoreFile:
+		return NewFilePersistedQueryStore(cfg.Directory)
+	default:
+		return nil, fmt.Errorf("unknown persisted query store %q", cfg.Store)
+	}
+}
+
+// MemoryPersistedQueryStore keeps the most recently used queries in memory
+type MemoryPersistedQueryStore struct {
+	cache *lru.Cache
+}
+
+// NewMemoryPersistedQueryStore returns a store keeping up to size queries
+func NewMemoryPersistedQueryStore(size int) (*MemoryPersistedQueryStore, error) {
+	cache, err := lru.New(size)
+	if err != nil {
+		return nil, fmt.Errorf("invalid persisted query cache size: %w", err)
+	}
+	return &MemoryPersistedQueryStore{cache: cache}, nil
+}
+
+// Get returns the query for the hash
+func (s *MemoryPersistedQueryStore) Get(ctx context.Context, hash string) (string, bool) {
+	query, ok := s.cache.Get(hash)
+	if !ok {
+		return "", false
+	}
+	return query.(string), true
+}
+
+// Add stores the query
+func (s *MemoryPersistedQueryStore) Add(ctx context.Context, hash string, query string) {
+	s.cache.Add(hash, query)
+}
+
+// FilePersistedQueryStore saves every query in its own file, so that
+// queries are shared between gateway instances using the same directory and
+// survive restarts.
+type FilePersistedQueryStore struct {
+	directory string
+}
+
+// NewFilePersistedQueryStore returns a store saving queries in the directory,
+// the directory is created if needed.
+func NewFilePersistedQueryStore(directory string) (*FilePersistedQueryStore, error) {
+	if directory == "" {
+		return nil, fmt.Errorf("missing directory for the persisted query file store")
+	}
+	if err := os.MkdirAll(directory, 0o755); err != nil {
+		return nil, fmt.Errorf("unable to create persisted query directory: %w", err)
+	}
+	return &FilePersistedQueryStore{directory: directory}, nil
+}
+
+// Get returns the query for the hash
+func (s *FilePersistedQueryStore) Get(ctx context.Context, hash string) (string, bool) {
+	path, ok := s.path(hash)
+	if !ok {
+		return "", false
+	}
+	query, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.WithError(err).WithField("hash", hash).Error("unable to read persisted query")
+		}
+		return "", false
+	}
+	return string(query), true
+}
+
+// Add stores the query, the file is written atomically so that concurrent
+// readers never see a partial query.
+func (s *FilePersistedQueryStore) Add(ctx context.Context, hash string, query string) {
+	path, ok := s.path(hash)
+	if !ok {
+		return
+	}
+	logger := log.WithField("hash", hash)
+	f, err := os.CreateTemp(s.directory, hash+".*.tmp")
+	if err != nil {
+		logger.WithError(err).Error("unable to write persisted query")
+		return
+	}
+	_, err = f.WriteString(query)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		logger.WithError(err).Error("unable to write persisted query")
+	}
+}
+
+// path returns the file path of the query, the hash comes from the client so
+// it must be a valid sha256 hash.
+func (s *FilePersistedQueryStore) path(hash string) (string, bool) {
+	if b, err := hex.DecodeString(hash); err != nil || len(b) != 32 {
+		return "", false
+	}
+	return filepath.Join(s.directory, hash+".graphql"), true
+}
+
+// persistedQueryCache adapts a persisted query store to the cache interface
+// of the gqlgen APQ extension
+type persistedQueryCache struct {
+	store PersistedQueryStore
+}
+
+var _ graphql.Cache = persistedQueryCache{}
+
+func (c persistedQueryCache) Get(ctx context.Context, key string) (interface{}, bool) {
+	query, ok := c.store.Get(ctx, key)
+	if !ok {
+		return nil, false
+	}
+	return query, true
+}
+
+func (c persistedQueryCache) Add(ctx context.Context, key string, value interface{}) {
+	if query, ok := value.(string); ok {
+		c.store.Add(ctx, key, query)
+	}
+}
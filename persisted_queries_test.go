@@ -0,0 +1,152 @@
+package bramble
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func queryHash(query string) string {
+	hash := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(hash[:])
+}
+
+func TestPersistedQueryStores(t *testing.T) {
+	ctx := context.Background()
+	query := "{ test }"
+	hash := queryHash(query)
+
+	t.Run("memory", func(t *testing.T) {
+		store, err := NewPersistedQueryStore(PersistedQueriesConfig{CacheSize: 1})
+		require.NoError(t, err)
+
+		_, ok := store.Get(ctx, hash)
+		assert.False(t, ok)
+
+		store.Add(ctx, hash, query)
+		stored, ok := store.Get(ctx, hash)
+		assert.True(t, ok)
+		assert.Equal(t, query, stored)
+
+		// the least recently used query is evicted
+		store.Add(ctx, queryHash("{ other }"), "{ other }")
+		_, ok = store.Get(ctx, hash)
+		assert.False(t, ok)
+	})
+
+	t.Run("file", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "queries")
+		store, err := NewPersistedQueryStore(PersistedQueriesConfig{Store: "file", Directory: dir})
+		require.NoError(t, err)
+
+		_, ok := store.Get(ctx, hash)
+		assert.False(t, ok)
+
+		store.Add(ctx, hash, query)
+		stored, ok := store.Get(ctx, hash)
+		assert.True(t, ok)
+		assert.Equal(t, query, stored)
+
+		// queries are shared between stores using the same directory
+		other, err := NewFilePersistedQueryStore(dir)
+		require.NoError(t, err)
+		stored, ok = other.Get(ctx, hash)
+		assert.True(t, ok)
+		assert.Equal(t, query, stored)
+
+		files, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		require.Len(t, files, 1)
+		assert.Equal(t, hash+".graphql", files[0].Name())
+	})
+
+	t.Run("file store ignores invalid hashes", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "secret.graphql"), []byte("{ secret }"), 0o644))
+		store, err := NewFilePersistedQueryStore(filepath.Join(dir, "queries"))
+		require.NoError(t, err)
+
+		_, ok := store.Get(ctx, "../secret")
+		assert.False(t, ok)
+		store.Add(ctx, "../other", "{ other }")
+		_, err = os.Stat(filepath.Join(dir, "other.graphql"))
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("unknown store", func(t *testing.T) {
+		_, err := NewPersistedQueryStore(PersistedQueriesConfig{Store: "redis"})
+		assert.Error(t, err)
+	})
+}
+
+func TestGatewayPersistedQueries(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Query string
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+
+		if strings.Contains(req.Query, "service") {
+			schema := `type Service {
+				name: String!
+				version: String!
+				schema: String!
+			}
+
+			type Query {
+				test: String
+				service: Service!
+			}`
+			encodedSchema, _ := json.Marshal(schema)
+			fmt.Fprintf(w, `{"data": {"service": {"schema": %s, "version": "1.0", "name": "test-service"}}}`, string(encodedSchema))
+			return
+		}
+		w.Write([]byte(`{ "data": { "test": "Hello" }}`))
+	}))
+	t.Cleanup(server.Close)
+
+	executableSchema := NewExecutableSchema(nil, 50, nil, NewService(server.URL))
+	require.NoError(t, executableSchema.UpdateSchema(true))
+	store, err := NewMemoryPersistedQueryStore(10)
+	require.NoError(t, err)
+	router := NewGateway(executableSchema, nil).Router(&Config{PersistedQueryStore: store})
+
+	query := "query { test }"
+	extensions := fmt.Sprintf(`{"persistedQuery": {"version": 1, "sha256Hash": %q}}`, queryHash(query))
+	get := func() string {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/query?extensions="+url.QueryEscape(extensions), nil)
+		router.ServeHTTP(rec, req)
+		return rec.Body.String()
+	}
+
+	assert.Contains(t, get(), "PersistedQueryNotFound")
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(fmt.Sprintf(`{"query": %q, "extensions": %s}`, query, extensions)))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(rec, req)
+	assert.JSONEq(t, `{"data": {"test": "Hello"}, "extensions": {"complexity": 1}}`, rec.Body.String())
+
+	assert.JSONEq(t, `{"data": {"test": "Hello"}, "extensions": {"complexity": 1}}`, get())
+
+	t.Run("hash mismatch", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(fmt.Sprintf(`{"query": "{ test }", "extensions": %s}`, extensions)))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(rec, req)
+		assert.Contains(t, rec.Body.String(), "provided APQ hash does not match query")
+	})
+}
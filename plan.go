@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/99designs/gqlgen/graphql"
 	"github.com/vektah/gqlparser/v2/ast"
@@ -19,6 +20,11 @@ type QueryPlanStep struct {
 	SelectionSet   ast.SelectionSet
 	InsertionPoint []string
 	Then           []*QueryPlanStep
+	// Defer is the deferred fragment the step belongs to, nil if the step
+	// is part of the initial response
+	Defer *DeferredFragment
+
+	deferDirective *ast.Directive
 }
 
 // MarshalJSON marshals the step the JSON
@@ -32,18 +38,26 @@ func (s *QueryPlanStep) MarshalJSON() ([]byte, error) {
 		SelectionSet   string
 		InsertionPoint []string
 		Then           []*QueryPlanStep
+		Deferred       bool `json:",omitempty"`
 	}{
 		ServiceURL:     s.ServiceURL,
 		ParentType:     s.ParentType,
 		SelectionSet:   formatSelectionSetSingleLine(ctx, nil, s.SelectionSet),
 		InsertionPoint: s.InsertionPoint,
 		Then:           s.Then,
+		Deferred:       s.Defer != nil,
 	})
 }
 
 // QueryPlan is a query execution plan
 type QueryPlan struct {
 	RootSteps []*QueryPlanStep
+	// DeferredFragments are the fragments marked with @defer, in document
+	// order
+	DeferredFragments []*DeferredFragment `json:"-"`
+	// StreamedFields are the list fields marked with @stream outside of
+	// deferred fragments
+	StreamedFields []*StreamedField `json:"-"`
 }
 
 // PlanningContext contains the necessary information used to plan a query.
@@ -63,17 +77,33 @@ func Plan(ctx *PlanningContext) (*QueryPlan, error) {
 		parentType = queryObjectName
 	case ast.Mutation:
 		parentType = mutationObjectName
+	case ast.Subscription:
+		parentType = subscriptionObjectName
 	default:
 		return nil, fmt.Errorf("not implemented")
 	}
 
-	steps, err := createSteps(ctx, nil, parentType, "", ctx.Operation.SelectionSet)
+	var steps []*QueryPlanStep
+	var err error
+	if parentType == mutationObjectName {
+		steps, err = createSerialSteps(ctx, parentType, ctx.Operation.SelectionSet)
+	} else {
+		steps, err = createSteps(ctx, nil, parentType, "", ctx.Operation.SelectionSet)
+	}
 	if err != nil {
 		return nil, err
 	}
-	return &QueryPlan{
+
+	plan := &QueryPlan{
 		RootSteps: steps,
-	}, nil
+	}
+	// incremental delivery is not supported for subscriptions, every event is
+	// sent as a whole
+	if ctx.Operation.Operation != ast.Subscription {
+		plan.DeferredFragments, plan.StreamedFields = collectIncrementalSelections(ctx.Operation.SelectionSet)
+		bindDeferredSteps(plan.RootSteps, plan.DeferredFragments)
+	}
+	return plan, nil
 }
 
 func createSteps(ctx *PlanningContext, insertionPoint []string, parentType string, parentLocation string, selectionSet ast.SelectionSet) ([]*QueryPlanStep, error) {
@@ -116,6 +146,57 @@ func createSteps(ctx *PlanningContext, insertionPoint []string, parentType strin
 	return result, nil
 }
 
+// createSerialSteps creates the root steps of a mutation. Top-level mutation
+// fields must be executed in order, so consecutive fields of the same service
+// are grouped and every group gets its own steps, in document order. The
+// steps are then executed one after another.
+func createSerialSteps(ctx *PlanningContext, parentType string, selectionSet ast.SelectionSet) ([]*QueryPlanStep, error) {
+	var (
+		groups         []ast.SelectionSet
+		groupLocations []string
+		groupByAlias   = make(map[string]int)
+	)
+	for _, field := range selectionSetToFields(selectionSet) {
+		// fields with the same response key are executed once, at the
+		// position of the first one
+		if i, ok := groupByAlias[field.Alias]; ok {
+			groups[i] = append(groups[i], field)
+			continue
+		}
+
+		routed, err := routeSelectionSet(ctx, parentType, "", ast.SelectionSet{field})
+		if err != nil {
+			return nil, err
+		}
+		// namespaces can span several services, they get their own group
+		var location string
+		if len(routed) == 1 {
+			for loc := range routed {
+				location = loc
+			}
+		}
+
+		last := len(groups) - 1
+		if last >= 0 && location != "" && groupLocations[last] == location {
+			groups[last] = append(groups[last], field)
+		} else {
+			groups = append(groups, ast.SelectionSet{field})
+			groupLocations = append(groupLocations, location)
+		}
+		groupByAlias[field.Alias] = len(groups) - 1
+	}
+
+	var result []*QueryPlanStep
+	for _, group := range groups {
+		steps, err := createSteps(ctx, nil, parentType, "", group)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, steps...)
+	}
+	return result, nil
+}
+
 var reservedAliases = map[string]string{
 	"_bramble__typename": "__typename",
 	"_bramble_id":        IdFieldName,
@@ -133,7 +214,7 @@ func extractSelectionSet(ctx *PlanningContext, insertionPoint []string, parentTy
 					return nil, nil, gqlerror.Errorf("%s.%s: alias \"%s\" is reserved for system use", strings.Join(insertionPoint, "."), reservedAlias, reservedAlias)
 				}
 			}
-			if parentType != queryObjectName && parentType != mutationObjectName && ctx.IsBoundary[parentType] && selection.Name == IdFieldName {
+			if !isRootObjectName(parentType) && ctx.IsBoundary[parentType] && selection.Name == IdFieldName {
 				selectionSetResult = append(selectionSetResult, selection)
 				continue
 			}
@@ -163,17 +244,24 @@ func extractSelectionSet(ctx *PlanningContext, insertionPoint []string, parentTy
 				childrenStepsResult = append(childrenStepsResult, childrenSteps...)
 			}
 		case *ast.InlineFragment:
+			// an inline fragment without type condition applies to the parent type
+			typeCondition := selection.TypeCondition
+			if typeCondition == "" {
+				typeCondition = parentType
+			}
 			selectionSet, childrenSteps, err := extractSelectionSet(
 				ctx,
 				insertionPoint,
-				selection.TypeCondition,
+				typeCondition,
 				selection.SelectionSet,
 				location,
 			)
 			if err != nil {
 				return nil, nil, err
 			}
+			deferSteps(selection.Directives, childrenSteps)
 			inlineFragment := *selection
+			inlineFragment.TypeCondition = typeCondition
 			inlineFragment.SelectionSet = selectionSet
 			selectionSetResult = append(selectionSetResult, &inlineFragment)
 			childrenStepsResult = append(childrenStepsResult, childrenSteps...)
@@ -188,6 +276,7 @@ func extractSelectionSet(ctx *PlanningContext, insertionPoint []string, parentTy
 			if err != nil {
 				return nil, nil, err
 			}
+			deferSteps(selection.Directives, childrenSteps)
 			inlineFragment := ast.InlineFragment{
 				TypeCondition: selection.Definition.TypeCondition,
 				SelectionSet:  selectionSet,
@@ -209,11 +298,19 @@ func extractSelectionSet(ctx *PlanningContext, insertionPoint []string, parentTy
 	}
 
 	if len(childrenStepsResult) > 1 {
-		// Merge steps targeting distinct service/path locations
+		// Merge steps targeting distinct service/path locations, steps of
+		// deferred fragments are kept apart
+		type stepKey struct {
+			location       string
+			deferDirective *ast.Directive
+		}
 		mergedSteps := []*QueryPlanStep{}
-		mergedStepsMap := map[string]*QueryPlanStep{}
+		mergedStepsMap := map[stepKey]*QueryPlanStep{}
 		for _, step := range childrenStepsResult {
-			key := strings.Join(append([]string{step.ServiceURL}, step.InsertionPoint...), "/")
+			key := stepKey{
+				location:       strings.Join(append([]string{step.ServiceURL}, step.InsertionPoint...), "/"),
+				deferDirective: step.deferDirective,
+			}
 			if existingStep, ok := mergedStepsMap[key]; ok {
 				existingStep.SelectionSet = append(existingStep.SelectionSet, step.SelectionSet...)
 				existingStep.Then = append(existingStep.Then, step.Then...)
@@ -259,7 +356,7 @@ func extractSelectionSet(ctx *PlanningContext, insertionPoint []string, parentTy
 			Name:       "__typename",
 			Definition: &ast.FieldDefinition{Name: "__typename", Type: ast.NamedType("String", nil)},
 		})
-	} else if parentType != queryObjectName && parentType != mutationObjectName && ctx.IsBoundary[parentType] {
+	} else if !isRootObjectName(parentType) && ctx.IsBoundary[parentType] {
 		// Otherwise, add an id selection to all boundary types
 		if idDef := parentDef.Fields.ForName(IdFieldName); idDef != nil {
 			selectionSetResult = append(selectionSetResult,
@@ -384,6 +481,9 @@ type BoundaryField struct {
 	Argument string
 	// Whether the query is in the array format
 	Array bool
+	// MaxAge is how long results are kept in the entity cache, set by the
+	// @cacheControl directive of the type
+	MaxAge time.Duration
 }
 
 // BoundaryFieldsMap is a mapping service -> type -> boundary query
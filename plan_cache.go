@@ -0,0 +1,149 @@
+package bramble
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/99designs/gqlgen/graphql"
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/formatter"
+)
+
+const defaultPlanCacheSize = 1000
+
+// PlanCache is a LRU cache of query plans. Plans are keyed by the normalized
+// query document, the operation name, the values of the variables used by
+// conditional directives (@skip, @include, @defer and @stream) and the
+// permissions of the request.
+type PlanCache struct {
+	cache *lru.Cache
+}
+
+// NewPlanCache returns a plan cache holding up to size plans, a size of 0
+// disables the cache.
+func NewPlanCache(size int) *PlanCache {
+	if size <= 0 {
+		return nil
+	}
+	// lru.New only fails when the size isn't positive
+	cache, _ := lru.New(size)
+	return &PlanCache{cache: cache}
+}
+
+// Get returns the cached plan for the key
+func (c *PlanCache) Get(key string) (*QueryPlan, bool) {
+	if c == nil || key == "" {
+		return nil, false
+	}
+	plan, ok := c.cache.Get(key)
+	if !ok {
+		promPlanCacheMissCounter.Inc()
+		return nil, false
+	}
+	promPlanCacheHitCounter.Inc()
+	return plan.(*QueryPlan), true
+}
+
+// Add adds the plan to the cache
+func (c *PlanCache) Add(key string, plan *QueryPlan) {
+	if c == nil || key == "" {
+		return
+	}
+	c.cache.Add(key, plan)
+}
+
+// Purge removes all the plans from the cache
+func (c *PlanCache) Purge() {
+	if c == nil {
+		return
+	}
+	c.cache.Purge()
+}
+
+// Len returns the number of cached plans
+func (c *PlanCache) Len() int {
+	if c == nil {
+		return 0
+	}
+	return c.cache.Len()
+}
+
+// planCacheKey returns the cache key of the operation plan, or an empty
+// string if the operation can't be cached.
+func planCacheKey(operationCtx *graphql.OperationContext, perms *OperationPermissions) string {
+	if operationCtx.Doc == nil {
+		return ""
+	}
+
+	conditionVariables := make(map[string]interface{})
+	collectConditionVariables(operationCtx.Operation.SelectionSet, operationCtx.Variables, conditionVariables, make(map[string]bool))
+
+	return operationCacheKey(operationCtx, conditionVariables, perms)
+}
+
+// operationCacheKey returns the cache key of the normalized query document
+// and operation name of the operation, along with the given variables and
+// permissions
+func operationCacheKey(operationCtx *graphql.OperationContext, variables map[string]interface{}, perms *OperationPermissions) string {
+	var buf bytes.Buffer
+	formatter.NewFormatter(&buf).FormatQueryDocument(operationCtx.Doc)
+
+	return hashCacheKey(struct {
+		Document      string
+		OperationName string
+		Variables     map[string]interface{}
+		Permissions   *OperationPermissions
+	}{
+		Document:      buf.String(),
+		OperationName: operationCtx.OperationName,
+		Variables:     variables,
+		Permissions:   perms,
+	})
+}
+
+// hashCacheKey returns the SHA-256 hash of the JSON encoding of the key, or
+// an empty string if the key can't be encoded. Maps are encoded with sorted
+// keys, so the hash is deterministic.
+func hashCacheKey(key interface{}) string {
+	data, err := json.Marshal(key)
+	if err != nil {
+		return ""
+	}
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// collectConditionVariables collects the values of the variables used in
+// the "if" argument of directives, as they change the plan
+func collectConditionVariables(selectionSet ast.SelectionSet, variables map[string]interface{}, result map[string]interface{}, visitedFragments map[string]bool) {
+	for _, selection := range selectionSet {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			collectDirectiveConditionVariables(selection.Directives, variables, result)
+			collectConditionVariables(selection.SelectionSet, variables, result, visitedFragments)
+		case *ast.InlineFragment:
+			collectDirectiveConditionVariables(selection.Directives, variables, result)
+			collectConditionVariables(selection.SelectionSet, variables, result, visitedFragments)
+		case *ast.FragmentSpread:
+			collectDirectiveConditionVariables(selection.Directives, variables, result)
+			if visitedFragments[selection.Name] || selection.Definition == nil {
+				continue
+			}
+			visitedFragments[selection.Name] = true
+			collectConditionVariables(selection.Definition.SelectionSet, variables, result, visitedFragments)
+		}
+	}
+}
+
+func collectDirectiveConditionVariables(directives ast.DirectiveList, variables map[string]interface{}, result map[string]interface{}) {
+	for _, directive := range directives {
+		arg := directive.Arguments.ForName("if")
+		if arg == nil || arg.Value == nil || arg.Value.Kind != ast.Variable {
+			continue
+		}
+		result[arg.Value.Raw] = variables[arg.Value.Raw]
+	}
+}
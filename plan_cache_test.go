@@ -0,0 +1,152 @@
+package bramble
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+func TestPlanCache(t *testing.T) {
+	f := &queryExecutionFixture{
+		services: []testService{
+			{
+				schema: `type Query {
+					test: String
+					other: String
+				}`,
+				handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					var req Request
+					require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+					if strings.Contains(req.Query, "other") {
+						w.Write([]byte(`{"data": {"test": "Hello", "other": "World"}}`))
+						return
+					}
+					w.Write([]byte(`{"data": {"test": "Hello"}}`))
+				}),
+			},
+		},
+	}
+	es := f.setup(t)
+
+	gateway := handler.New(es)
+	gateway.AddTransport(transport.POST{})
+	srv := httptest.NewServer(gateway)
+	t.Cleanup(srv.Close)
+
+	query := func(t *testing.T, query string, variables map[string]interface{}) string {
+		body, err := json.Marshal(map[string]interface{}{"query": query, "variables": variables})
+		require.NoError(t, err)
+		resp, err := http.Post(srv.URL, "application/json", strings.NewReader(string(body)))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var result struct {
+			Data   json.RawMessage
+			Errors []interface{}
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		require.Empty(t, result.Errors)
+		return string(result.Data)
+	}
+
+	t.Run("reuses the plan of identical operations", func(t *testing.T) {
+		es.PlanCache.Purge()
+		hits := testutil.ToFloat64(promPlanCacheHitCounter)
+
+		assert.JSONEq(t, `{"test": "Hello"}`, query(t, "{ test }", nil))
+		assert.JSONEq(t, `{"test": "Hello"}`, query(t, "query {\n  test\n}", nil))
+		assert.Equal(t, 1, es.PlanCache.Len())
+		assert.Equal(t, hits+1, testutil.ToFloat64(promPlanCacheHitCounter))
+	})
+
+	t.Run("plans depend on skip and include variables", func(t *testing.T) {
+		es.PlanCache.Purge()
+		q := "query($skip: Boolean!) { test other @skip(if: $skip) }"
+
+		assert.JSONEq(t, `{"test": "Hello"}`, query(t, q, map[string]interface{}{"skip": true}))
+		assert.JSONEq(t, `{"test": "Hello", "other": "World"}`, query(t, q, map[string]interface{}{"skip": false}))
+		assert.JSONEq(t, `{"test": "Hello"}`, query(t, q, map[string]interface{}{"skip": true}))
+		assert.Equal(t, 2, es.PlanCache.Len())
+	})
+}
+
+func TestPlanCacheKey(t *testing.T) {
+	schema := gqlparser.MustLoadSchema(&ast.Source{Input: `type Query { test: String other(arg: String): String }`})
+	operationContext := func(query string, variables map[string]interface{}) *graphql.OperationContext {
+		doc := gqlparser.MustLoadQuery(schema, query)
+		return &graphql.OperationContext{
+			Doc:           doc,
+			Operation:     doc.Operations[0],
+			OperationName: doc.Operations[0].Name,
+			Variables:     variables,
+		}
+	}
+
+	t.Run("permissions", func(t *testing.T) {
+		ctx := operationContext("{ test }", nil)
+		allowAll := &OperationPermissions{AllowedRootQueryFields: AllowedFields{AllowAll: true}}
+		allowTest := &OperationPermissions{AllowedRootQueryFields: AllowedFields{AllowedSubfields: map[string]AllowedFields{"test": {}}}}
+
+		assert.Equal(t, planCacheKey(ctx, allowAll), planCacheKey(ctx, &OperationPermissions{AllowedRootQueryFields: AllowedFields{AllowAll: true}}))
+		assert.NotEqual(t, planCacheKey(ctx, allowAll), planCacheKey(ctx, allowTest))
+		assert.NotEqual(t, planCacheKey(ctx, nil), planCacheKey(ctx, allowAll))
+	})
+
+	t.Run("operation name", func(t *testing.T) {
+		q := "query A { test } query B { other }"
+		docA := operationContext(q, nil)
+		docB := operationContext(q, nil)
+		docB.Operation, docB.OperationName = docB.Doc.Operations[1], "B"
+		assert.NotEqual(t, planCacheKey(docA, nil), planCacheKey(docB, nil))
+	})
+
+	t.Run("only condition variables are part of the key", func(t *testing.T) {
+		q := "query($a: Boolean!, $b: String) { test @include(if: $a) other(arg: $b) @skip(if: false) }"
+		key := planCacheKey(operationContext(q, map[string]interface{}{"a": true, "b": "x"}), nil)
+		assert.Equal(t, key, planCacheKey(operationContext(q, map[string]interface{}{"a": true, "b": "y"}), nil))
+		assert.NotEqual(t, key, planCacheKey(operationContext(q, map[string]interface{}{"a": false, "b": "x"}), nil))
+	})
+
+	t.Run("no document", func(t *testing.T) {
+		assert.Equal(t, "", planCacheKey(&graphql.OperationContext{Operation: &ast.OperationDefinition{}}, nil))
+	})
+}
+
+func TestPlanCachePurgedOnSchemaUpdate(t *testing.T) {
+	schema := `type Service {
+		name: String!
+		version: String!
+		schema: String!
+	}
+	type Query {
+		test: String
+		service: Service!
+	}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encodedSchema, _ := json.Marshal(schema)
+		fmt.Fprintf(w, `{"data": {"service": {"schema": %s, "version": "1.0", "name": "test-service"}}}`, string(encodedSchema))
+	}))
+	t.Cleanup(server.Close)
+
+	es := NewExecutableSchema(nil, 50, nil, NewService(server.URL))
+	require.NoError(t, es.UpdateSchema(true))
+	es.PlanCache.Add("key", &QueryPlan{})
+	require.Equal(t, 1, es.PlanCache.Len())
+
+	require.NoError(t, es.UpdateSchema(false))
+	assert.Equal(t, 1, es.PlanCache.Len(), "the plans are kept when the schema is unchanged")
+
+	require.NoError(t, es.UpdateSchema(true))
+	assert.Equal(t, 0, es.PlanCache.Len())
+}
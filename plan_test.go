@@ -1,8 +1,11 @@
 package bramble
 
 import (
+	"context"
 	"testing"
 
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -681,6 +684,49 @@ func TestQueryPlanSupportsMutations(t *testing.T) {
 	`)
 }
 
+func TestQueryPlanSerialMutations(t *testing.T) {
+	f := &PlanTestFixture{
+		Schema: `
+		type Query {
+			version: String
+		}
+
+		type Mutation {
+			createMovie(title: String): String
+			deleteMovie(id: ID!): String
+			rateMovie(id: ID!, rating: Int): String
+		}
+		`,
+		Locations: map[string]string{
+			"Mutation.createMovie": "A",
+			"Mutation.deleteMovie": "A",
+			"Mutation.rateMovie":   "B",
+		},
+	}
+
+	plan, err := f.Plan(t, `mutation {
+		a: createMovie(title: "a")
+		b: createMovie(title: "b")
+		c: rateMovie(id: "1", rating: 5)
+		d: deleteMovie(id: "1")
+		a: createMovie(title: "a")
+	}`)
+	require.NoError(t, err)
+
+	ctx := graphql.WithOperationContext(context.Background(), &graphql.OperationContext{
+		Variables: map[string]interface{}{},
+	})
+	var steps []string
+	for _, step := range plan.RootSteps {
+		steps = append(steps, step.ServiceURL+" "+formatSelectionSetSingleLine(ctx, nil, step.SelectionSet))
+	}
+	assert.Equal(t, []string{
+		`A { a: createMovie(title: "a") b: createMovie(title: "b") a: createMovie(title: "a") }`,
+		`B { c: rateMovie(id: "1", rating: 5) }`,
+		`A { d: deleteMovie(id: "1") }`,
+	}, steps)
+}
+
 func TestQueryPlanWithPaginatedBoundaryType(t *testing.T) {
 	PlanTestFixture5.Check(t, "{ foo { foos { cursor page { id name size } } } }", `
     {
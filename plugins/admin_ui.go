@@ -65,6 +65,9 @@ type service struct {
 	ServiceURL string
 	Schema     string
 	Status     string
+	// CircuitBreaker is the state of the service circuit breaker, empty when
+	// circuit breakers are disabled
+	CircuitBreaker string
 }
 
 type templateVariables struct {
@@ -86,14 +89,23 @@ func (p *AdminUIPlugin) handler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	var breakers *bramble.CircuitBreakers
+	if p.executableSchema.GraphqlClient != nil {
+		breakers = p.executableSchema.GraphqlClient.CircuitBreakers
+	}
+
 	for _, s := range p.executableSchema.Services {
-		vars.Services = append(vars.Services, service{
+		svc := service{
 			Name:       s.Name,
 			Version:    s.Version,
 			ServiceURL: s.ServiceURL,
 			Schema:     s.SchemaSource,
 			Status:     s.Status,
-		})
+		}
+		if breakers != nil {
+			svc.CircuitBreaker = breakers.State(s.ServiceURL).String()
+		}
+		vars.Services = append(vars.Services, svc)
 	}
 
 	sort.Sort(vars.Services)
@@ -189,6 +201,12 @@ const htmlTemplate = `
             top: 20px;
         }
 
+        .header .circuit-breaker-open,
+        .header .circuit-breaker-half-open {
+            color: #f6ad55;
+            font-weight: bold;
+        }
+
         .header .url {
             width: 460px;
             word-wrap: break-word;
@@ -290,6 +308,9 @@ const htmlTemplate = `
                 <div class="version">{{.Version}}</div>
                 <div class="url">{{.ServiceURL}}</div>
                 <div class="status">{{.Status}}</div>
+                {{if ne .CircuitBreaker ""}}
+                <div class="circuit-breaker circuit-breaker-{{.CircuitBreaker}}">Circuit breaker: {{.CircuitBreaker}}</div>
+                {{end}}
             </div>
             <label class="collapsible">
                 <input type="checkbox" />
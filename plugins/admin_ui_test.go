@@ -55,4 +55,27 @@ func TestAdminUI(t *testing.T) {
 
 		assert.NotContains(t, rr.Body.String(), "Schema merged successfully")
 	})
+
+	t.Run("circuit breaker state", func(t *testing.T) {
+		breakers, err := bramble.NewCircuitBreakers(bramble.CircuitBreakerConfig{})
+		assert.NoError(t, err)
+		plugin := &AdminUIPlugin{}
+		plugin.Init(&bramble.ExecutableSchema{
+			Services: map[string]*bramble.Service{
+				"svc-a": {
+					ServiceURL: "http://svc-a",
+					Schema:     gqlparser.MustLoadSchema(&ast.Source{Input: ``}),
+				},
+			},
+			GraphqlClient: bramble.NewClient(bramble.WithCircuitBreakers(breakers)),
+		})
+		m := http.NewServeMux()
+		plugin.SetupPrivateMux(m)
+
+		req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+		rr := httptest.NewRecorder()
+		m.ServeHTTP(rr, req)
+
+		assert.Contains(t, rr.Body.String(), "Circuit breaker: closed")
+	})
 }
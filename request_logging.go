@@ -0,0 +1,508 @@
+package bramble
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/formatter"
+	"github.com/vektah/gqlparser/v2/parser"
+)
+
+// redactedValue replaces the values masked in the logged request bodies
+const redactedValue = "[REDACTED]"
+
+// RequestLoggingConfig is the configuration of the request events logged by
+// the gateway
+type RequestLoggingConfig struct {
+	// DisableBody disables the logging of request bodies
+	DisableBody bool `json:"disable-body"`
+	// MaxBodySize is the maximum size in bytes of the logged request bodies,
+	// longer bodies are truncated. No limit when 0.
+	MaxBodySize int `json:"max-body-size"`
+	// RedactVariables are the names of the variables whose values are
+	// masked, case insensitive. Fields of input objects are masked too.
+	RedactVariables []string `json:"redact-variables"`
+	// RedactVariablePatterns are regular expressions, variables and fields
+	// of input objects with a matching name are masked
+	RedactVariablePatterns []string `json:"redact-variable-patterns"`
+	// SampleRate is the fraction of the successful requests that are
+	// logged, between 0 and 1. Requests with errors and slow requests are
+	// always logged. Defaults to 1.
+	SampleRate *float64 `json:"sample-rate"`
+	// SlowThreshold is the duration above which requests are logged as
+	// slow, along with their query plan and the timings of its steps.
+	// Disabled when empty.
+	SlowThreshold string `json:"slow-threshold"`
+}
+
+// RequestLogging applies the request logging configuration to the request
+// events. Arguments and input fields with the @sensitive directive are
+// always masked, even when the request logging isn't configured.
+type RequestLogging struct {
+	disableBody bool
+	maxBodySize int
+	variables   map[string]bool
+	patterns    []*regexp.Regexp
+	sampleRate  float64
+	slow        time.Duration
+}
+
+// NewRequestLogging returns the request logging for the configuration
+func NewRequestLogging(cfg RequestLoggingConfig) (*RequestLogging, error) {
+	if cfg.MaxBodySize < 0 {
+		return nil, fmt.Errorf("invalid max body size %d", cfg.MaxBodySize)
+	}
+	logging := &RequestLogging{
+		disableBody: cfg.DisableBody,
+		maxBodySize: cfg.MaxBodySize,
+		variables:   make(map[string]bool),
+		sampleRate:  1,
+	}
+	if cfg.SampleRate != nil {
+		if *cfg.SampleRate < 0 || *cfg.SampleRate > 1 {
+			return nil, fmt.Errorf("invalid sample rate %v, must be between 0 and 1", *cfg.SampleRate)
+		}
+		logging.sampleRate = *cfg.SampleRate
+	}
+	if cfg.SlowThreshold != "" {
+		slow, err := time.ParseDuration(cfg.SlowThreshold)
+		if err != nil {
+			return nil, fmt.Errorf("invalid slow threshold: %w", err)
+		}
+		logging.slow = slow
+	}
+	for _, name := range cfg.RedactVariables {
+		logging.variables[strings.ToLower(name)] = true
+	}
+	for _, pattern := range cfg.RedactVariablePatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid variable pattern %q: %w", pattern, err)
+		}
+		logging.patterns = append(logging.patterns, re)
+	}
+	return logging, nil
+}
+
+// startEvent prepares the event to record the details of slow requests
+func (l *RequestLogging) startEvent(e *event) {
+	if l != nil && l.slow > 0 {
+		e.recordSlowFields()
+	}
+}
+
+// finishEvent logs the event, unless the request was successful, fast and
+// not sampled
+func (l *RequestLogging) finishEvent(e *event) {
+	if l == nil {
+		e.finish()
+		return
+	}
+	if l.slow > 0 && time.Since(e.timestamp) >= l.slow {
+		e.addField("slow", true)
+		e.addSlowFields()
+		e.finish()
+		return
+	}
+	if e.failed() || l.sampleRate >= 1 || rand.Float64() < l.sampleRate {
+		e.finish()
+	}
+}
+
+func (l *RequestLogging) bodyDisabled() bool {
+	return l != nil && l.disableBody
+}
+
+// redactedName returns whether the values of variables and input fields
+// named name are masked
+func (l *RequestLogging) redactedName(name string) bool {
+	if l == nil {
+		return false
+	}
+	if l.variables[strings.ToLower(name)] {
+		return true
+	}
+	for _, re := range l.patterns {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// addBody adds the body to the event, truncated to the maximum body size
+func (l *RequestLogging) addBody(e *event, body interface{}) {
+	if l == nil || l.maxBodySize == 0 {
+		e.addField("request.body", body)
+		return
+	}
+	s, ok := body.(string)
+	if !ok {
+		data, err := json.Marshal(body)
+		if err != nil {
+			e.addField("request.body", body)
+			return
+		}
+		s = string(data)
+	}
+	if len(s) <= l.maxBodySize {
+		e.addField("request.body", body)
+		return
+	}
+	e.addField("request.body", truncateUTF8(s, l.maxBodySize))
+	e.addField("request.body-truncated", true)
+}
+
+// redactPayload masks the values of the query and variables of the JSON
+// payload of a GraphQL request. The variables of payloads without a query,
+// such as persisted queries, are dropped as they can't be checked.
+func (l *RequestLogging) redactPayload(schema *ast.Schema, payload map[string]interface{}) {
+	variables, _ := payload["variables"].(map[string]interface{})
+	if schema != nil {
+		if query, ok := payload["query"].(string); ok {
+			payload["query"] = redactSensitiveValues(schema, query, variables)
+		} else {
+			dropVariables(variables, nil)
+		}
+	}
+	if variables != nil {
+		l.redactNames(variables)
+	}
+}
+
+// redactNames masks the values of the variables and input fields whose name
+// is redacted
+func (l *RequestLogging) redactNames(value interface{}) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for name, child := range value {
+			if l.redactedName(name) {
+				value[name] = redactedValue
+				continue
+			}
+			l.redactNames(child)
+		}
+	case []interface{}:
+		for _, child := range value {
+			l.redactNames(child)
+		}
+	}
+}
+
+// redactSensitiveValues masks the values of the arguments and input fields
+// with the @sensitive directive. Values given as literals are masked in the
+// returned query. Values given as variables are masked in variables, and the
+// variables that aren't defined by the query are dropped. When the query
+// isn't valid, sensitive values can't be told apart: every literal is masked
+// and every variable is dropped.
+func redactSensitiveValues(schema *ast.Schema, query string, variables map[string]interface{}) string {
+	doc, errs := gqlparser.LoadQuery(schema, query)
+	if len(errs) > 0 {
+		dropVariables(variables, nil)
+		return redactLiterals(query)
+	}
+
+	sensitiveVariables := make(map[string]bool)
+	modified := false
+	for _, operation := range doc.Operations {
+		modified = redactSensitiveArguments(operation.SelectionSet, sensitiveVariables) || modified
+	}
+	for _, fragment := range doc.Fragments {
+		modified = redactSensitiveArguments(fragment.SelectionSet, sensitiveVariables) || modified
+	}
+
+	definedVariables := make(map[string]bool)
+	for _, operation := range doc.Operations {
+		for _, definition := range operation.VariableDefinitions {
+			definedVariables[definition.Variable] = true
+			if sensitiveVariables[definition.Variable] && definition.DefaultValue != nil {
+				modified = redactSensitiveValue(definition.DefaultValue, true, sensitiveVariables) || modified
+			}
+			value, ok := variables[definition.Variable]
+			if !ok {
+				continue
+			}
+			if sensitiveVariables[definition.Variable] {
+				variables[definition.Variable] = redactedValue
+				continue
+			}
+			variables[definition.Variable] = redactSensitiveInput(schema, value, definition.Type)
+		}
+	}
+	dropVariables(variables, definedVariables)
+
+	if !modified {
+		return query
+	}
+	return formatQueryDocument(doc)
+}
+
+// redactLiterals masks the literal values of every argument and variable
+// default value of the query, the whole query is masked if it can't be parsed
+func redactLiterals(query string) string {
+	doc, err := parser.ParseQuery(&ast.Source{Input: query})
+	if err != nil {
+		return redactedValue
+	}
+	for _, operation := range doc.Operations {
+		for _, definition := range operation.VariableDefinitions {
+			redactLiteral(definition.DefaultValue)
+		}
+		redactArgumentLiterals(operation.SelectionSet)
+	}
+	for _, fragment := range doc.Fragments {
+		redactArgumentLiterals(fragment.SelectionSet)
+	}
+	return formatQueryDocument(doc)
+}
+
+func redactArgumentLiterals(selectionSet ast.SelectionSet) {
+	for _, selection := range selectionSet {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			for _, argument := range selection.Arguments {
+				redactLiteral(argument.Value)
+			}
+			redactArgumentLiterals(selection.SelectionSet)
+		case *ast.InlineFragment:
+			redactArgumentLiterals(selection.SelectionSet)
+		}
+	}
+}
+
+// redactLiteral masks the value unless it is a variable
+func redactLiteral(value *ast.Value) {
+	if value == nil || value.Kind == ast.Variable {
+		return
+	}
+	value.Kind = ast.StringValue
+	value.Raw = redactedValue
+	value.Children = nil
+}
+
+// dropVariables removes the variables that aren't defined, every variable
+// when defined is nil
+func dropVariables(variables map[string]interface{}, defined map[string]bool) {
+	for name := range variables {
+		if !defined[name] {
+			delete(variables, name)
+		}
+	}
+}
+
+func formatQueryDocument(doc *ast.QueryDocument) string {
+	var buf bytes.Buffer
+	formatter.NewFormatter(&buf).FormatQueryDocument(doc)
+	return buf.String()
+}
+
+// redactSensitiveArguments masks the literal values of sensitive arguments in
+// the selection set, and records the variables given to sensitive arguments
+func redactSensitiveArguments(selectionSet ast.SelectionSet, sensitiveVariables map[string]bool) bool {
+	modified := false
+	for _, selection := range selectionSet {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			if selection.Definition != nil {
+				for _, argument := range selection.Arguments {
+					definition := selection.Definition.Arguments.ForName(argument.Name)
+					if definition == nil {
+						continue
+					}
+					modified = redactSensitiveValue(argument.Value, isSensitive(definition.Directives), sensitiveVariables) || modified
+				}
+			}
+			modified = redactSensitiveArguments(selection.SelectionSet, sensitiveVariables) || modified
+		case *ast.InlineFragment:
+			modified = redactSensitiveArguments(selection.SelectionSet, sensitiveVariables) || modified
+		}
+	}
+	return modified
+}
+
+func redactSensitiveValue(value *ast.Value, sensitive bool, sensitiveVariables map[string]bool) bool {
+	if value == nil {
+		return false
+	}
+	if value.Kind == ast.Variable {
+		if sensitive {
+			sensitiveVariables[value.Raw] = true
+		}
+		return false
+	}
+	if sensitive {
+		value.Kind = ast.StringValue
+		value.Raw = redactedValue
+		value.Children = nil
+		return true
+	}
+
+	modified := false
+	for _, child := range value.Children {
+		childSensitive := false
+		if value.Kind == ast.ObjectValue && value.Definition != nil {
+			if field := value.Definition.Fields.ForName(child.Name); field != nil {
+				childSensitive = isSensitive(field.Directives)
+			}
+		}
+		modified = redactSensitiveValue(child.Value, childSensitive, sensitiveVariables) || modified
+	}
+	return modified
+}
+
+// redactSensitiveInput masks the sensitive input fields of the variable value
+func redactSensitiveInput(schema *ast.Schema, value interface{}, inputType *ast.Type) interface{} {
+	switch value := value.(type) {
+	case []interface{}:
+		if inputType.Elem == nil {
+			return value
+		}
+		for i, child := range value {
+			value[i] = redactSensitiveInput(schema, child, inputType.Elem)
+		}
+	case map[string]interface{}:
+		definition := schema.Types[inputType.Name()]
+		if definition == nil || definition.Kind != ast.InputObject {
+			return value
+		}
+		for name, child := range value {
+			field := definition.Fields.ForName(name)
+			if field == nil {
+				continue
+			}
+			if isSensitive(field.Directives) {
+				value[name] = redactedValue
+				continue
+			}
+			value[name] = redactSensitiveInput(schema, child, field.Type)
+		}
+	}
+	return value
+}
+
+func isSensitive(directives ast.DirectiveList) bool {
+	return directives.ForName(sensitiveDirectiveName) != nil
+}
+
+// truncateUTF8 truncates s to at most size bytes, without splitting a rune
+func truncateUTF8(s string, size int) string {
+	for size > 0 && !utf8.RuneStart(s[size]) {
+		size--
+	}
+	return s[:size]
+}
+
+// stepTiming is the duration of a query plan step
+type stepTiming struct {
+	ServiceURL     string
+	ServiceName    string
+	ParentType     string
+	InsertionPoint []string
+	Duration       string
+}
+
+// stepTimings records the durations of the steps of a query execution, they
+// are logged for slow requests
+type stepTimings struct {
+	mutex   sync.Mutex
+	timings []stepTiming
+}
+
+func (t *stepTimings) record(step *QueryPlanStep, start time.Time) {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.timings = append(t.timings, stepTiming{
+		ServiceURL:     step.ServiceURL,
+		ServiceName:    step.ServiceName,
+		ParentType:     step.ParentType,
+		InsertionPoint: step.InsertionPoint,
+		Duration:       time.Since(start).Round(time.Millisecond).String(),
+	})
+}
+
+// MarshalJSON marshals the timings recorded so far
+func (t *stepTimings) MarshalJSON() ([]byte, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return json.Marshal(t.timings)
+}
+
+// loggedQueryPlan is the query plan logged for slow requests, the values of
+// the sensitive arguments of its selection sets are masked
+type loggedQueryPlan struct {
+	plan *QueryPlan
+}
+
+// MarshalJSON marshals the plan with its sensitive values masked
+func (p loggedQueryPlan) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&QueryPlan{RootSteps: redactPlanSteps(p.plan.RootSteps)})
+}
+
+// redactPlanSteps returns a copy of the steps whose selection sets have their
+// sensitive values masked, the plan is cached and can't be modified
+func redactPlanSteps(steps []*QueryPlanStep) []*QueryPlanStep {
+	var result []*QueryPlanStep
+	for _, step := range steps {
+		redacted := *step
+		redacted.SelectionSet = copySelectionSet(step.SelectionSet)
+		redactSensitiveArguments(redacted.SelectionSet, make(map[string]bool))
+		redacted.Then = redactPlanSteps(step.Then)
+		result = append(result, &redacted)
+	}
+	return result
+}
+
+// copySelectionSet copies the fields, fragments and argument values of the
+// selection set so that the values can be masked
+func copySelectionSet(selectionSet ast.SelectionSet) ast.SelectionSet {
+	var result ast.SelectionSet
+	for _, selection := range selectionSet {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			field := *selection
+			field.Arguments = nil
+			for _, argument := range selection.Arguments {
+				copied := *argument
+				copied.Value = copyValue(argument.Value)
+				field.Arguments = append(field.Arguments, &copied)
+			}
+			field.SelectionSet = copySelectionSet(selection.SelectionSet)
+			result = append(result, &field)
+		case *ast.InlineFragment:
+			fragment := *selection
+			fragment.SelectionSet = copySelectionSet(selection.SelectionSet)
+			result = append(result, &fragment)
+		default:
+			result = append(result, selection)
+		}
+	}
+	return result
+}
+
+func copyValue(value *ast.Value) *ast.Value {
+	if value == nil {
+		return nil
+	}
+	copied := *value
+	copied.Children = nil
+	for _, child := range value.Children {
+		copied.Children = append(copied.Children, &ast.ChildValue{
+			Name:     child.Name,
+			Value:    copyValue(child.Value),
+			Position: child.Position,
+		})
+	}
+	return &copied
+}
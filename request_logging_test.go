@@ -0,0 +1,264 @@
+package bramble
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+func TestRequestLoggingSensitiveValues(t *testing.T) {
+	schema := gqlparser.MustLoadSchema(&ast.Source{Input: `
+	directive @sensitive on ARGUMENT_DEFINITION | INPUT_FIELD_DEFINITION
+
+	input Credentials {
+		login: String!
+		password: String! @sensitive
+	}
+
+	type Query {
+		login(credentials: Credentials!): String
+		verify(code: String! @sensitive, device: String!): Boolean
+		lookup(credentials: [Credentials!]!): String
+	}`})
+
+	t.Run("literals", func(t *testing.T) {
+		variables := map[string]interface{}{}
+		query := redactSensitiveValues(schema, `{ login(credentials: {login: "jane", password: "hunter2"}) verify(code: "1234", device: "phone") }`, variables)
+		assert.NotContains(t, query, "hunter2")
+		assert.NotContains(t, query, "1234")
+		assert.Contains(t, query, `"jane"`)
+		assert.Contains(t, query, `"phone"`)
+		assert.Contains(t, query, `"[REDACTED]"`)
+	})
+
+	t.Run("variables", func(t *testing.T) {
+		variables := map[string]interface{}{
+			"credentials": map[string]interface{}{"login": "jane", "password": "hunter2"},
+			"list":        []interface{}{map[string]interface{}{"login": "john", "password": "secret"}},
+			"code":        "1234",
+			"device":      "phone",
+			"undefined":   "secret",
+		}
+		query := `query($credentials: Credentials!, $list: [Credentials!]!, $code: String!, $device: String!) {
+			login(credentials: $credentials)
+			lookup(credentials: $list)
+			verify(code: $code, device: $device)
+		}`
+		assert.Equal(t, query, redactSensitiveValues(schema, query, variables), "the query is unchanged without literals to mask")
+		assert.Equal(t, map[string]interface{}{
+			"credentials": map[string]interface{}{"login": "jane", "password": "[REDACTED]"},
+			"list":        []interface{}{map[string]interface{}{"login": "john", "password": "[REDACTED]"}},
+			"code":        "[REDACTED]",
+			"device":      "phone",
+		}, variables)
+	})
+
+	t.Run("variable default values", func(t *testing.T) {
+		query := redactSensitiveValues(schema, `query($code: String! = "1234") { verify(code: $code, device: "phone") }`, nil)
+		assert.NotContains(t, query, "1234")
+		assert.Contains(t, query, `"phone"`)
+	})
+
+	t.Run("invalid query", func(t *testing.T) {
+		variables := map[string]interface{}{"code": "1234"}
+		query := redactSensitiveValues(schema, `query($code: String!) { verify(code: $code, device: "phone") login(credentials: {password: "hunter2"}) unknown }`, variables)
+		assert.NotContains(t, query, "hunter2")
+		assert.NotContains(t, query, "phone", "every literal is masked")
+		assert.Contains(t, query, `"[REDACTED]"`)
+		assert.Empty(t, variables, "variables are dropped")
+	})
+
+	t.Run("unparsable query", func(t *testing.T) {
+		assert.Equal(t, "[REDACTED]", redactSensitiveValues(schema, `{ verify(code: "1234"`, nil))
+	})
+
+	t.Run("persisted query", func(t *testing.T) {
+		var logging *RequestLogging
+		payload := map[string]interface{}{
+			"extensions": map[string]interface{}{"persistedQuery": map[string]interface{}{"version": 1, "sha256Hash": "abc"}},
+			"variables":  map[string]interface{}{"code": "1234"},
+		}
+		logging.redactPayload(schema, payload)
+		assert.Empty(t, payload["variables"], "variables are dropped without a query to check them against")
+	})
+}
+
+func TestRequestLoggingRedactNames(t *testing.T) {
+	logging, err := NewRequestLogging(RequestLoggingConfig{
+		RedactVariables:        []string{"Password"},
+		RedactVariablePatterns: []string{`(?i)token$`},
+	})
+	require.NoError(t, err)
+
+	payload := map[string]interface{}{
+		"query": "query($password: String, $input: Input) { login }",
+		"variables": map[string]interface{}{
+			"password": "hunter2",
+			"input": map[string]interface{}{
+				"accessToken": "abc",
+				"name":        "jane",
+			},
+		},
+	}
+	logging.redactPayload(nil, payload)
+	assert.Equal(t, map[string]interface{}{
+		"password": "[REDACTED]",
+		"input": map[string]interface{}{
+			"accessToken": "[REDACTED]",
+			"name":        "jane",
+		},
+	}, payload["variables"])
+
+	_, err = NewRequestLogging(RequestLoggingConfig{RedactVariablePatterns: []string{"("}})
+	assert.Error(t, err)
+}
+
+func TestRequestLoggingBody(t *testing.T) {
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/query", nil)
+		req.Header.Set("Content-Type", "application/json")
+		return req
+	}
+	body := *bytes.NewBufferString(`{"query": "{ movie(title: \"Ünïcödé\") { id } }"}`)
+
+	t.Run("disabled", func(t *testing.T) {
+		logging, err := NewRequestLogging(RequestLoggingConfig{DisableBody: true})
+		require.NoError(t, err)
+		e := newEvent("request")
+		addRequestBody(e, newRequest(), body, logging, nil)
+		assert.Equal(t, "application/json", e.fields["request.content-type"])
+		assert.NotContains(t, e.fields, "request.body")
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		logging, err := NewRequestLogging(RequestLoggingConfig{MaxBodySize: 28})
+		require.NoError(t, err)
+		e := newEvent("request")
+		addRequestBody(e, newRequest(), body, logging, nil)
+		assert.Equal(t, `{"query":"{ movie(title: \"`, e.fields["request.body"])
+		assert.Equal(t, true, e.fields["request.body-truncated"])
+	})
+
+	t.Run("content type parameters", func(t *testing.T) {
+		logging, err := NewRequestLogging(RequestLoggingConfig{})
+		require.NoError(t, err)
+		e := newEvent("request")
+		req := newRequest()
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+		addRequestBody(e, req, body, logging, nil)
+		assert.IsType(t, &map[string]interface{}{}, e.fields["request.body"])
+	})
+
+	t.Run("under the maximum size", func(t *testing.T) {
+		logging, err := NewRequestLogging(RequestLoggingConfig{MaxBodySize: 1000})
+		require.NoError(t, err)
+		e := newEvent("request")
+		addRequestBody(e, newRequest(), body, logging, nil)
+		assert.IsType(t, &map[string]interface{}{}, e.fields["request.body"])
+		assert.NotContains(t, e.fields, "request.body-truncated")
+	})
+}
+
+func TestRequestLoggingSampling(t *testing.T) {
+	sampleRate := 0.0
+	logging, err := NewRequestLogging(RequestLoggingConfig{SampleRate: &sampleRate})
+	require.NoError(t, err)
+
+	logrusLock.Lock()
+	defer logrusLock.Unlock()
+	hooks := log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+	defer log.StandardLogger().ReplaceHooks(hooks)
+	hook := logtest.NewGlobal()
+
+	logging.finishEvent(newEvent("request"))
+	assert.Empty(t, hook.AllEntries(), "successful requests are sampled")
+
+	failed := newEvent("request")
+	failed.addField("errors", "error")
+	logging.finishEvent(failed)
+	assert.Len(t, hook.AllEntries(), 1, "requests with errors are always logged")
+
+	serverError := newEvent("request")
+	serverError.addField("response.status", http.StatusInternalServerError)
+	logging.finishEvent(serverError)
+	assert.Len(t, hook.AllEntries(), 2, "failed requests are always logged")
+
+	rate := 1.5
+	_, err = NewRequestLogging(RequestLoggingConfig{SampleRate: &rate})
+	assert.Error(t, err)
+	_, err = NewRequestLogging(RequestLoggingConfig{SlowThreshold: "slow"})
+	assert.Error(t, err)
+}
+
+func TestRequestLoggingSlowRequests(t *testing.T) {
+	f := &queryExecutionFixture{
+		services: []testService{
+			{
+				schema: `directive @boundary on OBJECT
+				directive @sensitive on ARGUMENT_DEFINITION
+				type Movie @boundary {
+					id: ID!
+					title: String
+				}
+				type Query {
+					movie(id: ID!, token: String @sensitive): Movie!
+				}`,
+				handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Write([]byte(`{"data": {"movie": {"_bramble_id": "1", "_bramble__typename": "Movie", "title": "Test title"}}}`))
+				}),
+			},
+			{
+				schema: `directive @boundary on OBJECT | FIELD_DEFINITION
+				type Movie @boundary {
+					id: ID!
+					release: Int
+				}
+				type Query {
+					movie(id: ID!): Movie @boundary
+				}`,
+				handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Write([]byte(`{"data": {"_0": {"_bramble_id": "1", "_bramble__typename": "Movie", "release": 2007}}}`))
+				}),
+			},
+		},
+	}
+	es := f.setup(t)
+
+	sampleRate := 0.0
+	logging, err := NewRequestLogging(RequestLoggingConfig{SampleRate: &sampleRate, SlowThreshold: "1ns"})
+	require.NoError(t, err)
+	gateway := handler.New(es)
+	gateway.AddTransport(transport.POST{})
+	server := applyMiddleware(gateway, monitoringMiddleware(nil, logging, es))
+
+	req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"query": "{ movie(id: \"1\", token: \"secret\") { title release } }"}`))
+	req.Header.Set("Content-Type", "application/json")
+	obj := collectLogEvent(t, func() {
+		server.ServeHTTP(httptest.NewRecorder(), req)
+	})
+
+	assert.Equal(t, true, obj["slow"])
+	plan, err := json.Marshal(obj["plan"])
+	require.NoError(t, err)
+	assert.Contains(t, string(plan), `movie(id: \"1\", token: \"[REDACTED]\")`)
+	assert.NotContains(t, string(plan), "secret")
+	steps, ok := obj["steps"].([]interface{})
+	require.True(t, ok)
+	require.Len(t, steps, 2)
+	for _, step := range steps {
+		assert.Contains(t, step, "ServiceURL")
+		assert.Contains(t, step, "Duration")
+	}
+}
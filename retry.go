@@ -0,0 +1,246 @@
+package bramble
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	defaultRetryMaxAttempts    = 3
+	defaultRetryInitialBackoff = 100 * time.Millisecond
+	defaultRetryMaxBackoff     = time.Second
+
+	// retryErrorConnection matches connection errors: refused or reset
+	// connections and connections closed before the response was received
+	retryErrorConnection = "connection"
+	// retryErrorTimeout matches requests that timed out
+	retryErrorTimeout = "timeout"
+)
+
+var defaultRetryableStatusCodes = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+
+// RetryPolicy describes how failed requests to a service are retried. Only
+// idempotent requests are retried: query root steps and boundary lookups.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the first one
+	MaxAttempts int `json:"max-attempts"`
+	// InitialBackoff is the maximum delay before the first retry, the delay
+	// doubles with every retry
+	InitialBackoff string `json:"initial-backoff"`
+	// MaxBackoff is the maximum delay between two attempts
+	MaxBackoff string `json:"max-backoff"`
+	// RetryableStatusCodes are the HTTP status codes that are retried
+	RetryableStatusCodes []int `json:"retryable-status-codes"`
+	// RetryableErrors are the kinds of errors that are retried, either
+	// "connection" or "timeout"
+	RetryableErrors []string `json:"retryable-errors"`
+}
+
+// RetryConfig contains the retry policies of services
+type RetryConfig struct {
+	// Default is the policy of services without their own policy, requests
+	// are not retried when nil
+	Default *RetryPolicy `json:"default"`
+	// Services are the policies of specific services, by service URL
+	Services map[string]RetryPolicy `json:"services"`
+}
+
+// RetryPolicies are the parsed retry policies of services
+type RetryPolicies struct {
+	defaultPolicy *retryPolicy
+	services      map[string]*retryPolicy
+}
+
+type retryPolicy struct {
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	statusCodes    map[int]bool
+	errors         map[string]bool
+}
+
+// NewRetryPolicies parses the retry policies, unset values are replaced by
+// their defaults.
+func NewRetryPolicies(cfg RetryConfig) (*RetryPolicies, error) {
+	p := &RetryPolicies{
+		services: make(map[string]*retryPolicy),
+	}
+	if cfg.Default != nil {
+		policy, err := newRetryPolicy(*cfg.Default)
+		if err != nil {
+			return nil, fmt.Errorf("invalid default retry policy: %w", err)
+		}
+		p.defaultPolicy = policy
+	}
+	for service, policy := range cfg.Services {
+		parsed, err := newRetryPolicy(policy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retry policy for service %q: %w", service, err)
+		}
+		p.services[service] = parsed
+	}
+	return p, nil
+}
+
+func newRetryPolicy(policy RetryPolicy) (*retryPolicy, error) {
+	p := &retryPolicy{
+		maxAttempts:    policy.MaxAttempts,
+		initialBackoff: defaultRetryInitialBackoff,
+		maxBackoff:     defaultRetryMaxBackoff,
+		statusCodes:    make(map[int]bool),
+		errors:         make(map[string]bool),
+	}
+	if p.maxAttempts <= 0 {
+		p.maxAttempts = defaultRetryMaxAttempts
+	}
+
+	var err error
+	if policy.InitialBackoff != "" {
+		p.initialBackoff, err = time.ParseDuration(policy.InitialBackoff)
+		if err != nil {
+			return nil, fmt.Errorf("invalid initial backoff: %w", err)
+		}
+	}
+	if policy.MaxBackoff != "" {
+		p.maxBackoff, err = time.ParseDuration(policy.MaxBackoff)
+		if err != nil {
+			return nil, fmt.Errorf("invalid max backoff: %w", err)
+		}
+	}
+
+	statusCodes := policy.RetryableStatusCodes
+	if statusCodes == nil {
+		statusCodes = defaultRetryableStatusCodes
+	}
+	for _, code := range statusCodes {
+		p.statusCodes[code] = true
+	}
+
+	retryableErrors := policy.RetryableErrors
+	if retryableErrors == nil {
+		retryableErrors = []string{retryErrorConnection}
+	}
+	for _, kind := range retryableErrors {
+		if kind != retryErrorConnection && kind != retryErrorTimeout {
+			return nil, fmt.Errorf("unknown retryable error %q", kind)
+		}
+		p.errors[kind] = true
+	}
+
+	return p, nil
+}
+
+// policy returns the retry policy of the service, nil if requests to the
+// service are not retried
+func (p *RetryPolicies) policy(serviceURL string) *retryPolicy {
+	if p == nil {
+		return nil
+	}
+	if policy, ok := p.services[serviceURL]; ok {
+		return policy
+	}
+	return p.defaultPolicy
+}
+
+// retryable returns whether a request that failed with the status code and
+// error should be retried. The status code is 0 if no response was received.
+func (p *retryPolicy) retryable(statusCode int, err error) bool {
+	var circuitErr *CircuitOpenError
+	if errors.As(err, &circuitErr) {
+		return false
+	}
+	if statusCode != 0 {
+		return p.statusCodes[statusCode]
+	}
+
+	var timeoutErr interface{ Timeout() bool }
+	if errors.As(err, &timeoutErr) && timeoutErr.Timeout() {
+		return p.errors[retryErrorTimeout]
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) {
+		return p.errors[retryErrorConnection]
+	}
+
+	return false
+}
+
+// backoff returns the delay before the given retry, starting at 1. The delay
+// is randomized between 0 and the exponential backoff to spread retries.
+func (p *retryPolicy) backoff(retry int) time.Duration {
+	backoff := p.initialBackoff
+	for i := 1; i < retry && backoff < p.maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > p.maxBackoff {
+		backoff = p.maxBackoff
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(backoff) + 1))
+}
+
+// RequestWithRetry executes a GraphQL request, retrying it according to the
+// retry policy of the service. It must only be used for idempotent requests.
+func (c *GraphQLClient) RequestWithRetry(ctx context.Context, url string, request *Request, out interface{}) error {
+	policy := c.RetryPolicies.policy(url)
+	if settings := c.serviceSettings(url); settings != nil && settings.retry != nil {
+		policy = settings.retry
+	}
+	if policy == nil {
+		return c.Request(ctx, url, request, out)
+	}
+
+	// retries are labelled like the other service metrics, by the service
+	// name of the request labels, or by URL for requests sent without them
+	service := url
+	if labels, ok := ctx.Value(serviceRequestLabelsContextKey).(prometheus.Labels); ok {
+		service = labels["service"]
+	}
+
+	for attempt := 1; ; attempt++ {
+		// every attempt is decoded into a fresh value, so that the data of a
+		// failed attempt doesn't end up in the result of the next one
+		var data json.RawMessage
+		statusCode, err := c.request(ctx, url, request, &data)
+		if err == nil && policy.statusCodes[statusCode] {
+			err = fmt.Errorf("unexpected status code %d", statusCode)
+		}
+		if err == nil || attempt >= policy.maxAttempts || ctx.Err() != nil || !policy.retryable(statusCode, err) {
+			if len(data) > 0 && out != nil {
+				if decodeErr := json.Unmarshal(data, out); decodeErr != nil && err == nil {
+					err = fmt.Errorf("error decoding response: %w", decodeErr)
+				}
+			}
+			return err
+		}
+
+		promServiceRetryCounter.With(prometheus.Labels{
+			"service": service,
+		}).Inc()
+
+		timer := time.NewTimer(policy.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package bramble
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		policies, err := NewRetryPolicies(RetryConfig{Default: &RetryPolicy{}})
+		require.NoError(t, err)
+		policy := policies.policy("http://service")
+		require.NotNil(t, policy)
+		assert.Equal(t, defaultRetryMaxAttempts, policy.maxAttempts)
+		assert.True(t, policy.retryable(http.StatusServiceUnavailable, errors.New("error decoding response")))
+		assert.False(t, policy.retryable(http.StatusInternalServerError, errors.New("error decoding response")))
+		assert.False(t, policy.retryable(http.StatusOK, GraphqlErrors{{Message: "not found"}}))
+		assert.False(t, policy.retryable(0, &CircuitOpenError{ServiceURL: "http://service"}))
+	})
+
+	t.Run("service policies", func(t *testing.T) {
+		policies, err := NewRetryPolicies(RetryConfig{
+			Services: map[string]RetryPolicy{
+				"http://service": {MaxAttempts: 5, RetryableStatusCodes: []int{http.StatusInternalServerError}},
+			},
+		})
+		require.NoError(t, err)
+		assert.Nil(t, policies.policy("http://other-service"))
+		policy := policies.policy("http://service")
+		require.NotNil(t, policy)
+		assert.Equal(t, 5, policy.maxAttempts)
+		assert.True(t, policy.retryable(http.StatusInternalServerError, errors.New("error decoding response")))
+		assert.False(t, policy.retryable(http.StatusServiceUnavailable, errors.New("error decoding response")))
+	})
+
+	t.Run("invalid policies", func(t *testing.T) {
+		_, err := NewRetryPolicies(RetryConfig{Default: &RetryPolicy{InitialBackoff: "soon"}})
+		assert.Error(t, err)
+		_, err = NewRetryPolicies(RetryConfig{Default: &RetryPolicy{RetryableErrors: []string{"dns"}}})
+		assert.Error(t, err)
+	})
+
+	t.Run("backoff", func(t *testing.T) {
+		policy, err := newRetryPolicy(RetryPolicy{InitialBackoff: "100ms", MaxBackoff: "300ms"})
+		require.NoError(t, err)
+		for i := 0; i < 100; i++ {
+			assert.LessOrEqual(t, int64(policy.backoff(1)), int64(100*time.Millisecond))
+			assert.LessOrEqual(t, int64(policy.backoff(2)), int64(200*time.Millisecond))
+			assert.LessOrEqual(t, int64(policy.backoff(5)), int64(300*time.Millisecond))
+		}
+	})
+}
+
+func TestRequestWithRetry(t *testing.T) {
+	policies, err := NewRetryPolicies(RetryConfig{
+		Default: &RetryPolicy{MaxAttempts: 3, InitialBackoff: "1ms"},
+	})
+	require.NoError(t, err)
+	c := NewClient(WithRetryPolicies(policies))
+
+	t.Run("retries until success", func(t *testing.T) {
+		var attempts int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&attempts, 1) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte(`{"data": {"root": "value"}}`))
+		}))
+		defer srv.Close()
+
+		ctx := context.WithValue(context.Background(), serviceRequestLabelsContextKey, prometheus.Labels{
+			"service":        "retried",
+			"kind":           "root",
+			"operation_type": "query",
+		})
+		retries := promServiceRetryCounter.WithLabelValues("retried")
+		failures := promServiceRequestCounter.WithLabelValues("retried", "root", "query", "error")
+		successes := promServiceRequestCounter.WithLabelValues("retried", "root", "query", "success")
+		retryCount, failureCount, successCount := testutil.ToFloat64(retries), testutil.ToFloat64(failures), testutil.ToFloat64(successes)
+
+		var res struct{ Root string }
+		err := c.RequestWithRetry(ctx, srv.URL, &Request{}, &res)
+		require.NoError(t, err)
+		assert.Equal(t, "value", res.Root)
+		assert.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+		assert.Equal(t, retryCount+2, testutil.ToFloat64(retries), "retries are labelled by service name")
+		// every attempt is recorded
+		assert.Equal(t, failureCount+2, testutil.ToFloat64(failures))
+		assert.Equal(t, successCount+1, testutil.ToFloat64(successes))
+	})
+
+	t.Run("retries retryable status codes with a valid body", func(t *testing.T) {
+		var attempts int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&attempts, 1) < 2 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write([]byte(`{"data": {"stale": "value"}}`))
+				return
+			}
+			w.Write([]byte(`{"data": {"root": "value"}}`))
+		}))
+		defer srv.Close()
+
+		var res map[string]interface{}
+		err := c.RequestWithRetry(context.Background(), srv.URL, &Request{}, &res)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{"root": "value"}, res)
+		assert.Equal(t, int32(2), atomic.LoadInt32(&attempts))
+	})
+
+	t.Run("reports retryable status codes after max attempts", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"data": null}`))
+		}))
+		defer srv.Close()
+
+		err := c.RequestWithRetry(context.Background(), srv.URL, &Request{}, nil)
+		assert.EqualError(t, err, "unexpected status code 503")
+	})
+
+	t.Run("gives up after max attempts", func(t *testing.T) {
+		var attempts int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&attempts, 1)
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		defer srv.Close()
+
+		err := c.RequestWithRetry(context.Background(), srv.URL, &Request{}, nil)
+		assert.Error(t, err)
+		assert.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+	})
+
+	t.Run("does not retry GraphQL errors", func(t *testing.T) {
+		var attempts int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&attempts, 1)
+			w.Write([]byte(`{"errors": [{"message": "not found"}]}`))
+		}))
+		defer srv.Close()
+
+		err := c.RequestWithRetry(context.Background(), srv.URL, &Request{}, nil)
+		assert.EqualError(t, err, "not found")
+		assert.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+	})
+
+	t.Run("retries connection errors", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		srv.Close()
+
+		err := c.RequestWithRetry(context.Background(), srv.URL, &Request{}, nil)
+		assert.Error(t, err)
+		assert.Equal(t, 2.0, testutil.ToFloat64(promServiceRetryCounter.WithLabelValues(srv.URL)))
+	})
+
+	t.Run("does not retry without policy", func(t *testing.T) {
+		var attempts int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&attempts, 1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer srv.Close()
+
+		err := NewClient().RequestWithRetry(context.Background(), srv.URL, &Request{}, nil)
+		assert.Error(t, err)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+	})
+}
+
+func TestRetryQueryExecution(t *testing.T) {
+	var attempts int32
+	f := &queryExecutionFixture{
+		services: []testService{
+			{
+				schema: `type Query { movie: String! }
+				type Mutation { rateMovie: String! }`,
+				handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if atomic.AddInt32(&attempts, 1)%2 == 1 {
+						w.WriteHeader(http.StatusServiceUnavailable)
+						return
+					}
+					w.Write([]byte(`{"data": {"movie": "Fight Club", "rateMovie": "ok"}}`))
+				}),
+			},
+		},
+	}
+	es := f.setup(t)
+	policies, err := NewRetryPolicies(RetryConfig{Default: &RetryPolicy{InitialBackoff: "1ms"}})
+	require.NoError(t, err)
+	es.GraphqlClient.RetryPolicies = policies
+
+	execute := func(query string) *graphql.Response {
+		atomic.StoreInt32(&attempts, 0)
+		doc := gqlparser.MustLoadQuery(f.mergedSchema, query)
+		return es.ExecuteQuery(testContextWithVariables(nil, doc.Operations[0]))
+	}
+
+	t.Run("queries are retried", func(t *testing.T) {
+		resp := execute(`{ movie }`)
+		require.Empty(t, resp.Errors)
+		assert.JSONEq(t, `{"movie": "Fight Club"}`, string(resp.Data))
+		assert.Equal(t, int32(2), atomic.LoadInt32(&attempts))
+	})
+
+	t.Run("mutations are not retried", func(t *testing.T) {
+		resp := execute(`mutation { rateMovie }`)
+		require.NotEmpty(t, resp.Errors)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+	})
+}
@@ -17,6 +17,11 @@ const (
 	boundaryDirectiveName  = "boundary"
 	namespaceDirectiveName = "namespace"
 	skipMergeDirectiveName = "skipMerge"
+	deferDirectiveName     = "defer"
+	streamDirectiveName    = "stream"
+	costDirectiveName      = "cost"
+	listSizeDirectiveName  = "listSize"
+	sensitiveDirectiveName = "sensitive"
 
 	queryObjectName        = "Query"
 	mutationObjectName     = "Mutation"
@@ -29,6 +34,10 @@ func isGraphQLBuiltinName(s string) bool {
 	return strings.HasPrefix(s, "__")
 }
 
+func isRootObjectName(s string) bool {
+	return s == queryObjectName || s == mutationObjectName || s == subscriptionObjectName
+}
+
 func isIDType(t *ast.Type) bool {
 	return isNonNullableTypeNamed(t, "ID")
 }
@@ -0,0 +1,215 @@
+package bramble
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultBoundaryBatchSize   = 50
+	defaultBoundaryConcurrency = 4
+)
+
+// ServiceConfig is the configuration of a federated service. In the config
+// file a service is either its URL or an object with the URL and the
+// settings of the service.
+type ServiceConfig struct {
+	// Name overrides the name returned by the service
+	Name string `json:"name,omitempty"`
+	// URL is the URL of the service, required
+	URL string `json:"url"`
+	// Timeout of the requests to the service, overrides the timeout of the
+	// query HTTP client
+	Timeout string `json:"timeout,omitempty"`
+	// MaxResponseSize overrides max-service-response-size
+	MaxResponseSize int64 `json:"max-response-size,omitempty"`
+	// BoundaryBatchSize is the maximum number of ids queried in a single
+	// boundary lookup
+	BoundaryBatchSize int `json:"boundary-batch-size,omitempty"`
+	// BoundaryBatchSizes override BoundaryBatchSize for specific types, by
+	// type name
+	BoundaryBatchSizes map[string]int `json:"boundary-batch-sizes,omitempty"`
+	// BoundaryConcurrency is the maximum number of boundary lookup documents
+	// of a single query step sent to the service at the same time
+	BoundaryConcurrency int `json:"boundary-concurrency,omitempty"`
+	// Headers are static headers sent with every request to the service
+	Headers map[string]string `json:"headers,omitempty"`
+	// PollInterval overrides poll-interval
+	PollInterval string `json:"poll-interval,omitempty"`
+	// Retry overrides the retry policy of the service
+	Retry *RetryPolicy `json:"retry,omitempty"`
+}
+
+// UnmarshalJSON decodes either a URL or a service object
+func (s *ServiceConfig) UnmarshalJSON(data []byte) error {
+	if len(bytes.TrimSpace(data)) > 0 && bytes.TrimSpace(data)[0] == '"' {
+		*s = ServiceConfig{}
+		return json.Unmarshal(data, &s.URL)
+	}
+
+	type plain ServiceConfig
+	var cfg plain
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return err
+	}
+	if cfg.URL == "" {
+		return errors.New("service url is required")
+	}
+	*s = ServiceConfig(cfg)
+	return nil
+}
+
+// MarshalJSON encodes services without settings as their URL
+func (s ServiceConfig) MarshalJSON() ([]byte, error) {
+	type plain ServiceConfig
+	if isServiceURLOnly(s) {
+		return json.Marshal(s.URL)
+	}
+	return json.Marshal(plain(s))
+}
+
+func isServiceURLOnly(s ServiceConfig) bool {
+	return s.Name == "" &&
+		s.Timeout == "" &&
+		s.MaxResponseSize == 0 &&
+		s.BoundaryBatchSize == 0 &&
+		len(s.BoundaryBatchSizes) == 0 &&
+		s.BoundaryConcurrency == 0 &&
+		len(s.Headers) == 0 &&
+		s.PollInterval == "" &&
+		s.Retry == nil
+}
+
+// serviceSettings are the parsed settings of a service, zero values mean the
+// gateway defaults are used
+type serviceSettings struct {
+	timeout             time.Duration
+	maxResponseSize     int64
+	boundaryBatchSize   int
+	boundaryBatchSizes  map[string]int
+	boundaryConcurrency int
+	headers             http.Header
+	pollInterval        time.Duration
+	retry               *retryPolicy
+}
+
+func (s ServiceConfig) settings() (*serviceSettings, error) {
+	settings := &serviceSettings{
+		maxResponseSize:     s.MaxResponseSize,
+		boundaryBatchSize:   s.BoundaryBatchSize,
+		boundaryBatchSizes:  s.BoundaryBatchSizes,
+		boundaryConcurrency: s.BoundaryConcurrency,
+	}
+
+	var err error
+	if s.Timeout != "" {
+		settings.timeout, err = time.ParseDuration(s.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout: %w", err)
+		}
+	}
+	if s.PollInterval != "" {
+		settings.pollInterval, err = time.ParseDuration(s.PollInterval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid poll interval: %w", err)
+		}
+	}
+	if s.BoundaryBatchSize < 0 {
+		return nil, fmt.Errorf("invalid boundary batch size: %d", s.BoundaryBatchSize)
+	}
+	for typeName, size := range s.BoundaryBatchSizes {
+		if size <= 0 {
+			return nil, fmt.Errorf("invalid boundary batch size for type %q: %d", typeName, size)
+		}
+	}
+	if s.BoundaryConcurrency < 0 {
+		return nil, fmt.Errorf("invalid boundary concurrency: %d", s.BoundaryConcurrency)
+	}
+	if len(s.Headers) > 0 {
+		settings.headers = make(http.Header)
+		for name, value := range s.Headers {
+			settings.headers.Set(name, value)
+		}
+	}
+	if s.Retry != nil {
+		settings.retry, err = newRetryPolicy(*s.Retry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retry policy: %w", err)
+		}
+	}
+
+	return settings, nil
+}
+
+// SetServiceConfigs sets the settings used for requests to the services,
+// replacing the previous ones.
+func (c *GraphQLClient) SetServiceConfigs(services ...ServiceConfig) error {
+	settings := make(map[string]*serviceSettings, len(services))
+	for _, service := range services {
+		s, err := service.settings()
+		if err != nil {
+			return fmt.Errorf("invalid configuration for service %q: %w", service.URL, err)
+		}
+		settings[service.URL] = s
+	}
+
+	c.servicesMutex.Lock()
+	c.services = settings
+	c.servicesMutex.Unlock()
+	return nil
+}
+
+// serviceSettings returns the settings of the service, nil if the service
+// uses the client defaults.
+func (c *GraphQLClient) serviceSettings(serviceURL string) *serviceSettings {
+	c.servicesMutex.RLock()
+	defer c.servicesMutex.RUnlock()
+	return c.services[serviceURL]
+}
+
+// httpClient returns the HTTP client used for requests to the service
+func (c *GraphQLClient) httpClient(settings *serviceSettings) *http.Client {
+	if settings == nil || settings.timeout == 0 {
+		return c.HTTPClient
+	}
+	client := *c.HTTPClient
+	client.Timeout = settings.timeout
+	return &client
+}
+
+// maxResponseSize returns the maximum size of responses from the service
+func (c *GraphQLClient) maxResponseSize(settings *serviceSettings) int64 {
+	if settings == nil || settings.maxResponseSize == 0 {
+		return c.MaxResponseSize
+	}
+	return settings.maxResponseSize
+}
+
+// boundaryBatchSize returns the maximum number of ids in a boundary lookup
+// of the type to the service
+func (c *GraphQLClient) boundaryBatchSize(serviceURL, typeName string) int {
+	settings := c.serviceSettings(serviceURL)
+	if settings == nil {
+		return defaultBoundaryBatchSize
+	}
+	if size, ok := settings.boundaryBatchSizes[typeName]; ok {
+		return size
+	}
+	if settings.boundaryBatchSize > 0 {
+		return settings.boundaryBatchSize
+	}
+	return defaultBoundaryBatchSize
+}
+
+// boundaryConcurrency returns the maximum number of boundary lookup documents
+// of a query step sent to the service at the same time
+func (c *GraphQLClient) boundaryConcurrency(serviceURL string) int {
+	if settings := c.serviceSettings(serviceURL); settings != nil && settings.boundaryConcurrency > 0 {
+		return settings.boundaryConcurrency
+	}
+	return defaultBoundaryConcurrency
+}
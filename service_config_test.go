@@ -0,0 +1,358 @@
+package bramble
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServiceConfigJSON(t *testing.T) {
+	var cfg struct {
+		Services []ServiceConfig `json:"services"`
+	}
+	err := json.Unmarshal([]byte(`{
+		"services": [
+			"http://movies/query",
+			{
+				"name": "reports",
+				"url": "http://reports/query",
+				"timeout": "20s",
+				"max-response-size": 2048,
+				"boundary-batch-size": 20,
+				"boundary-batch-sizes": {"Report": 500},
+				"boundary-concurrency": 2,
+				"headers": {"X-Api-Key": "key"},
+				"poll-interval": "1m",
+				"retry": {"max-attempts": 2}
+			}
+		]
+	}`), &cfg)
+	require.NoError(t, err)
+	assert.Equal(t, []ServiceConfig{
+		{URL: "http://movies/query"},
+		{
+			Name:                "reports",
+			URL:                 "http://reports/query",
+			Timeout:             "20s",
+			MaxResponseSize:     2048,
+			BoundaryBatchSize:   20,
+			BoundaryBatchSizes:  map[string]int{"Report": 500},
+			BoundaryConcurrency: 2,
+			Headers:             map[string]string{"X-Api-Key": "key"},
+			PollInterval:        "1m",
+			Retry:               &RetryPolicy{MaxAttempts: 2},
+		},
+	}, cfg.Services)
+
+	data, err := json.Marshal(cfg.Services[0])
+	require.NoError(t, err)
+	assert.JSONEq(t, `"http://movies/query"`, string(data))
+	data, err = json.Marshal(cfg.Services[1])
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"boundary-batch-size":20`)
+
+	err = json.Unmarshal([]byte(`{"services": [{"name": "movies"}]}`), &cfg)
+	assert.EqualError(t, err, "service url is required")
+
+	_, err = ServiceConfig{URL: "http://movies/query", Timeout: "soon"}.settings()
+	assert.Error(t, err)
+	_, err = NewServiceWithConfig(ServiceConfig{URL: "http://movies/query", PollInterval: "often"})
+	assert.Error(t, err)
+	_, err = ServiceConfig{URL: "http://movies/query", BoundaryBatchSizes: map[string]int{"Movie": 0}}.settings()
+	assert.Error(t, err)
+}
+
+func TestServiceConfigClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Slow") != "" {
+			time.Sleep(100 * time.Millisecond)
+		}
+		fmt.Fprintf(w, `{"data": {"key": %q}}`, r.Header.Get("X-Api-Key"))
+	}))
+	defer srv.Close()
+
+	c := NewClient()
+	require.NoError(t, c.SetServiceConfigs(ServiceConfig{
+		URL:     srv.URL,
+		Timeout: "50ms",
+		Headers: map[string]string{"X-Api-Key": "key"},
+	}))
+
+	t.Run("static headers", func(t *testing.T) {
+		var res struct{ Key string }
+		req := NewRequest("{ key }").WithHeaders(http.Header{"X-Api-Key": []string{"client-key"}})
+		require.NoError(t, c.Request(context.Background(), srv.URL, req, &res))
+		assert.Equal(t, "key", res.Key)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		req := NewRequest("{ key }").WithHeaders(http.Header{"X-Slow": []string{"1"}})
+		err := c.Request(context.Background(), srv.URL, req, nil)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "Client.Timeout exceeded")
+		assert.Equal(t, 5*time.Second, c.HTTPClient.Timeout, "the default client is unchanged")
+	})
+
+	t.Run("max response size", func(t *testing.T) {
+		require.NoError(t, c.SetServiceConfigs(ServiceConfig{URL: srv.URL, MaxResponseSize: 10}))
+		err := c.Request(context.Background(), srv.URL, NewRequest("{ key }"), nil)
+		assert.EqualError(t, err, "response exceeded maximum size of 10 bytes")
+	})
+}
+
+func boundaryBatchFixture(handleBoundaryRequest func()) *queryExecutionFixture {
+	aliasRegexp := regexp.MustCompile(`(_\d+): movie\(id: "(\d+)"\)`)
+	return &queryExecutionFixture{
+		services: []testService{
+			{
+				schema: `directive @boundary on OBJECT
+				type Movie @boundary {
+					id: ID!
+				}
+
+				type Query {
+					movies: [Movie!]!
+				}`,
+				handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Write([]byte(`{"data": {"movies": [
+						{"_bramble_id": "1", "_bramble__typename": "Movie", "id": "1"},
+						{"_bramble_id": "2", "_bramble__typename": "Movie", "id": "2"},
+						{"_bramble_id": "3", "_bramble__typename": "Movie", "id": "3"},
+						{"_bramble_id": "4", "_bramble__typename": "Movie", "id": "4"},
+						{"_bramble_id": "5", "_bramble__typename": "Movie", "id": "5"}
+					]}}`))
+				}),
+			},
+			{
+				schema: `directive @boundary on OBJECT | FIELD_DEFINITION
+				type Movie @boundary {
+					id: ID!
+					title: String!
+				}
+
+				type Query {
+					movie(id: ID!): Movie @boundary
+				}`,
+				handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					handleBoundaryRequest()
+					var req Request
+					if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+						http.Error(w, err.Error(), http.StatusBadRequest)
+						return
+					}
+					var results []string
+					for _, match := range aliasRegexp.FindAllStringSubmatch(req.Query, -1) {
+						results = append(results, fmt.Sprintf(`%q: {"_bramble_id": %q, "_bramble__typename": "Movie", "title": "Movie %s"}`, match[1], match[2], match[2]))
+					}
+					fmt.Fprintf(w, `{"data": {%s}}`, strings.Join(results, ","))
+				}),
+			},
+		},
+		query: `{ movies { id title } }`,
+		expected: `{"movies": [
+			{"id": "1", "title": "Movie 1"},
+			{"id": "2", "title": "Movie 2"},
+			{"id": "3", "title": "Movie 3"},
+			{"id": "4", "title": "Movie 4"},
+			{"id": "5", "title": "Movie 5"}
+		]}`,
+	}
+}
+
+func TestServiceConfigBoundaryBatchSize(t *testing.T) {
+	t.Run("service batch size", func(t *testing.T) {
+		var boundaryRequests int32
+		f := boundaryBatchFixture(func() { atomic.AddInt32(&boundaryRequests, 1) })
+		es := f.setup(t)
+
+		var configs []ServiceConfig
+		for url := range es.Services {
+			configs = append(configs, ServiceConfig{URL: url, BoundaryBatchSize: 2})
+		}
+		require.NoError(t, es.GraphqlClient.SetServiceConfigs(configs...))
+
+		f.run(t, es)
+		assert.Equal(t, int32(3), atomic.LoadInt32(&boundaryRequests))
+	})
+
+	t.Run("type batch size", func(t *testing.T) {
+		var boundaryRequests int32
+		f := boundaryBatchFixture(func() { atomic.AddInt32(&boundaryRequests, 1) })
+		es := f.setup(t)
+
+		var configs []ServiceConfig
+		for url := range es.Services {
+			configs = append(configs, ServiceConfig{
+				URL:                url,
+				BoundaryBatchSize:  1,
+				BoundaryBatchSizes: map[string]int{"Movie": 4},
+			})
+		}
+		require.NoError(t, es.GraphqlClient.SetServiceConfigs(configs...))
+
+		f.run(t, es)
+		assert.Equal(t, int32(2), atomic.LoadInt32(&boundaryRequests))
+	})
+
+	t.Run("array boundary field", func(t *testing.T) {
+		var boundaryRequests int32
+		idRegexp := regexp.MustCompile(`"(\d+)"`)
+		f := boundaryBatchFixture(nil)
+		f.services[1] = testService{
+			schema: `directive @boundary on OBJECT | FIELD_DEFINITION
+			type Movie @boundary {
+				id: ID!
+				title: String!
+			}
+
+			type Query {
+				movies(ids: [ID!]!): [Movie]! @boundary
+			}`,
+			handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&boundaryRequests, 1)
+				var req Request
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				var results []string
+				for _, match := range idRegexp.FindAllStringSubmatch(req.Query, -1) {
+					results = append(results, fmt.Sprintf(`{"_bramble_id": %q, "_bramble__typename": "Movie", "title": "Movie %s"}`, match[1], match[1]))
+				}
+				fmt.Fprintf(w, `{"data": {"_result": [%s]}}`, strings.Join(results, ","))
+			}),
+		}
+		es := f.setup(t)
+
+		var configs []ServiceConfig
+		for url := range es.Services {
+			configs = append(configs, ServiceConfig{URL: url, BoundaryBatchSize: 2})
+		}
+		require.NoError(t, es.GraphqlClient.SetServiceConfigs(configs...))
+
+		f.run(t, es)
+		assert.Equal(t, int32(3), atomic.LoadInt32(&boundaryRequests), "the results of every batch are concatenated")
+	})
+}
+
+func TestServiceConfigBoundaryConcurrency(t *testing.T) {
+	var inFlight, maxInFlight int32
+	f := boundaryBatchFixture(func() {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	})
+	es := f.setup(t)
+
+	var configs []ServiceConfig
+	for url := range es.Services {
+		configs = append(configs, ServiceConfig{URL: url, BoundaryBatchSize: 1, BoundaryConcurrency: 2})
+	}
+	require.NoError(t, es.GraphqlClient.SetServiceConfigs(configs...))
+
+	f.run(t, es)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&maxInFlight))
+}
+
+func TestServicePollInterval(t *testing.T) {
+	var polls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&polls, 1)
+		w.Write([]byte(`{"data": {"service": {"name": "movies", "version": "1", "schema": "type Service { name: String! version: String! schema: String! } type Query { service: Service! movie: String }"}}}`))
+	}))
+	defer srv.Close()
+
+	svc, err := NewServiceWithConfig(ServiceConfig{Name: "films", URL: srv.URL, PollInterval: "1h"})
+	require.NoError(t, err)
+	es := NewExecutableSchema(nil, 50, nil, svc)
+	es.PollInterval = time.Millisecond
+
+	require.NoError(t, es.UpdateSchema(false))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&polls))
+	assert.Equal(t, "films", svc.Name, "the configured name overrides the service name")
+
+	// the service isn't due but its schema is kept
+	require.NoError(t, es.UpdateSchema(false))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&polls))
+	assert.NotNil(t, es.MergedSchema.Query.Fields.ForName("movie"))
+
+	// services are always polled when the schema is rebuilt
+	require.NoError(t, es.UpdateSchema(true))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&polls))
+
+	assert.True(t, svc.pollDue(svc.lastPoll.Add(55*time.Minute), 0), "a tenth of the interval is tolerated")
+	assert.False(t, svc.pollDue(svc.lastPoll.Add(50*time.Minute), 0))
+
+	assert.Equal(t, time.Millisecond, es.schemaPollInterval())
+	es.PollInterval = 2 * time.Hour
+	assert.Equal(t, time.Hour, es.schemaPollInterval(), "the shortest poll interval is used")
+}
+
+func TestConfigServices(t *testing.T) {
+	t.Setenv("BRAMBLE_SERVICE_LIST", "http://movies/query")
+	file := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(file, []byte(`{
+		"services": [
+			"http://movies/query",
+			{"url": "http://reports/query", "timeout": "20s"}
+		]
+	}`), 0o600))
+
+	cfg := newConfig()
+	cfg.configFiles = []string{file}
+	require.NoError(t, cfg.Load())
+	assert.ElementsMatch(t, []string{"http://movies/query", "http://reports/query"}, cfg.Services)
+	assert.ElementsMatch(t, []ServiceConfig{
+		{URL: "http://movies/query"},
+		{URL: "http://reports/query", Timeout: "20s"},
+	}, cfg.ServiceConfigs)
+
+	cfg = newConfig()
+	cfg.Services = []string{"http://reports/query"}
+	cfg.ServiceConfigs = []ServiceConfig{{URL: "http://reports/query", Timeout: "20s"}, {URL: "http://ignored/query"}}
+	services, err := cfg.buildServiceList()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []ServiceConfig{
+		{URL: "http://movies/query"},
+		{URL: "http://reports/query", Timeout: "20s"},
+	}, services)
+}
+
+func TestUpdateServiceList(t *testing.T) {
+	svc, err := NewServiceWithConfig(ServiceConfig{URL: "http://127.0.0.1:1/movies"})
+	require.NoError(t, err)
+	es := NewExecutableSchema(nil, 50, nil, svc)
+
+	// errors are expected as the services are unreachable
+	_ = es.UpdateServiceList([]ServiceConfig{
+		{URL: "http://127.0.0.1:1/movies"},
+		{URL: "http://127.0.0.1:1/reports", BoundaryBatchSize: 20},
+	})
+	assert.Same(t, svc, es.Services["http://127.0.0.1:1/movies"])
+	assert.Equal(t, 20, es.GraphqlClient.boundaryBatchSize("http://127.0.0.1:1/reports", "Report"))
+
+	_ = es.UpdateServiceList([]ServiceConfig{
+		{URL: "http://127.0.0.1:1/movies", Timeout: "1s"},
+	})
+	assert.NotSame(t, svc, es.Services["http://127.0.0.1:1/movies"], "services are recreated when their config changes")
+	assert.Len(t, es.Services, 1)
+	assert.Equal(t, defaultBoundaryBatchSize, es.GraphqlClient.boundaryBatchSize("http://127.0.0.1:1/reports", "Report"))
+}
@@ -0,0 +1,230 @@
+package bramble
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	log "github.com/sirupsen/logrus"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+const (
+	sseContentType = "text/event-stream"
+
+	defaultSSEHeartbeatInterval = 12 * time.Second
+)
+
+// SSETransport is a gqlgen transport streaming results as Server-Sent Events,
+// following the "distinct connections" mode of the GraphQL over SSE protocol
+// (https://github.com/enisdenjo/graphql-sse/blob/master/PROTOCOL.md).
+type SSETransport struct {
+	// HeartbeatInterval is the interval at which comments are sent to keep
+	// the connection alive, defaults to 12s.
+	HeartbeatInterval time.Duration
+}
+
+var _ graphql.Transport = SSETransport{}
+
+// Supports returns whether the request accepts an event stream
+func (t SSETransport) Supports(r *http.Request) bool {
+	if r.Header.Get("Upgrade") != "" {
+		return false
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		return false
+	}
+	return acceptsMediaType(r, sseContentType)
+}
+
+// acceptsMediaType returns whether the Accept header of the request contains
+// the given media type
+func acceptsMediaType(r *http.Request, mediaType string) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		acceptedType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err == nil && acceptedType == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// Do executes the operation and streams every result as a "next" event,
+// followed by a "complete" event.
+func (t SSETransport) Do(w http.ResponseWriter, r *http.Request, exec graphql.GraphExecutor) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	start := graphql.Now()
+	params, err := readRawParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	params.ReadTime = graphql.TraceTiming{
+		Start: start,
+		End:   graphql.Now(),
+	}
+
+	ctx, cancel := context.WithCancel(withIncrementalDelivery(r.Context()))
+	defer cancel()
+
+	rc, errs := exec.CreateOperationContext(ctx, params)
+	if errs == nil && isGETMutation(r, rc) {
+		rejectGETMutation(w)
+		return
+	}
+
+	// the stream can outlive the server write timeout
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	stream := &sseStream{w: w, flusher: flusher, cancel: cancel}
+
+	w.Header().Set("Content-Type", sseContentType+"; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	if errs != nil {
+		stream.next(exec.DispatchError(graphql.WithOperationContext(ctx, rc), errs))
+		stream.complete()
+		return
+	}
+
+	responses := make(chan *graphql.Response)
+	go func() {
+		defer close(responses)
+		defer func() {
+			if r := recover(); r != nil {
+				err := graphql.DefaultRecover(ctx, r)
+				select {
+				case responses <- &graphql.Response{Errors: gqlerror.List{{Message: err.Error()}}}:
+				case <-ctx.Done():
+				}
+			}
+		}()
+
+		handler, ctx := exec.DispatchOperation(ctx, rc)
+		for {
+			response := handler(ctx)
+			if response == nil {
+				return
+			}
+			select {
+			case responses <- response:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	heartbeatInterval := t.HeartbeatInterval
+	if heartbeatInterval == 0 {
+		heartbeatInterval = defaultSSEHeartbeatInterval
+	}
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeat.C:
+			stream.heartbeat()
+		case response, ok := <-responses:
+			if !ok {
+				stream.complete()
+				return
+			}
+			stream.next(response)
+		}
+	}
+}
+
+// readRawParams reads the operation parameters from the query string for GET
+// requests, or from the JSON body for POST requests.
+func readRawParams(r *http.Request) (*graphql.RawParams, error) {
+	params := &graphql.RawParams{}
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(r.Body).Decode(params); err != nil {
+			return nil, fmt.Errorf("json body could not be decoded: %w", err)
+		}
+		// consuming the body lets the server detect client disconnects
+		_, _ = io.Copy(io.Discard, r.Body)
+		return params, nil
+	}
+
+	query := r.URL.Query()
+	params.Query = query.Get("query")
+	params.OperationName = query.Get("operationName")
+	if variables := query.Get("variables"); variables != "" {
+		if err := json.Unmarshal([]byte(variables), &params.Variables); err != nil {
+			return nil, fmt.Errorf("variables could not be decoded: %w", err)
+		}
+	}
+	if extensions := query.Get("extensions"); extensions != "" {
+		if err := json.Unmarshal([]byte(extensions), &params.Extensions); err != nil {
+			return nil, fmt.Errorf("extensions could not be decoded: %w", err)
+		}
+	}
+	return params, nil
+}
+
+// isGETMutation returns whether the request is a GET request executing a
+// mutation. Mutations are only allowed over POST so that they can't be
+// triggered by cross-origin requests such as an EventSource.
+func isGETMutation(r *http.Request, rc *graphql.OperationContext) bool {
+	return r.Method == http.MethodGet && rc.Operation != nil && rc.Operation.Operation == ast.Mutation
+}
+
+// rejectGETMutation writes the response refusing a GET mutation
+func rejectGETMutation(w http.ResponseWriter) {
+	w.Header().Set("Allow", http.MethodPost)
+	http.Error(w, "mutations are only allowed over POST", http.StatusMethodNotAllowed)
+}
+
+type sseStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	cancel  context.CancelFunc
+}
+
+func (s *sseStream) next(response *graphql.Response) {
+	data, err := marshalResponse(response)
+	if err != nil {
+		log.WithError(err).Error("unable to encode event stream response")
+		s.cancel()
+		return
+	}
+	s.write("event: next\ndata: %s\n\n", data)
+}
+
+func (s *sseStream) complete() {
+	s.write("event: complete\ndata:\n\n")
+}
+
+func (s *sseStream) heartbeat() {
+	s.write(":\n\n")
+}
+
+// write writes to the stream, a failed write means the client went away and
+// cancels the execution.
+func (s *sseStream) write(format string, args ...interface{}) {
+	if _, err := fmt.Fprintf(s.w, format, args...); err != nil {
+		log.WithError(err).Debug("event stream write error")
+		s.cancel()
+		return
+	}
+	s.flusher.Flush()
+}
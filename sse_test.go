@@ -0,0 +1,150 @@
+package bramble
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newSSETestServer(t *testing.T, es *ExecutableSchema, transport SSETransport) *httptest.Server {
+	gateway := handler.New(es)
+	gateway.AddTransport(transport)
+	srv := httptest.NewServer(gateway)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestSSETransport(t *testing.T) {
+	t.Run("query", func(t *testing.T) {
+		f := &queryExecutionFixture{
+			services: []testService{
+				{
+					schema: `type Query { test: String }`,
+					handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						w.Write([]byte(`{ "data": { "test": "Hello" }}`))
+					}),
+				},
+			},
+		}
+		srv := newSSETestServer(t, f.setup(t), SSETransport{})
+
+		req, err := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader(`{"query": "{ test }"}`))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "text/event-stream")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, "text/event-stream; charset=utf-8", resp.Header.Get("Content-Type"))
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "event: next\ndata: {\"data\":{\"test\":\"Hello\"},\"extensions\":{\"complexity\":1}}\n\nevent: complete\ndata:\n\n", string(body))
+	})
+
+	t.Run("subscription", func(t *testing.T) {
+		f := &queryExecutionFixture{
+			services: []testService{
+				{
+					schema: `type Query { test: String }
+					type Subscription { counter: Int! }`,
+					handler: subscriptionServiceHandler(t,
+						"subscription { counter }",
+						`{"data": {"counter": 1}}`,
+						`{"data": {"counter": 2}}`,
+					),
+				},
+			},
+		}
+		srv := newSSETestServer(t, f.setup(t), SSETransport{})
+
+		req, err := http.NewRequest(http.MethodGet, srv.URL+"?query="+url.QueryEscape("subscription { counter }"), nil)
+		require.NoError(t, err)
+		req.Header.Set("Accept", "text/event-stream")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "event: next\ndata: {\"data\":{\"counter\":1},\"extensions\":{\"complexity\":1}}\n\nevent: next\ndata: {\"data\":{\"counter\":2},\"extensions\":{\"complexity\":1}}\n\nevent: complete\ndata:\n\n", string(body))
+	})
+
+	t.Run("mutation over GET is refused", func(t *testing.T) {
+		f := &queryExecutionFixture{
+			services: []testService{
+				{
+					schema: `type Query { test: String }
+					type Mutation { update: String }`,
+					handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						t.Error("the mutation was executed")
+					}),
+				},
+			},
+		}
+		srv := newSSETestServer(t, f.setup(t), SSETransport{})
+
+		req, err := http.NewRequest(http.MethodGet, srv.URL+"?query="+url.QueryEscape("mutation { update }"), nil)
+		require.NoError(t, err)
+		req.Header.Set("Accept", "text/event-stream")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+		assert.Equal(t, http.MethodPost, resp.Header.Get("Allow"))
+	})
+
+	t.Run("client disconnect cancels execution", func(t *testing.T) {
+		cancelled := make(chan struct{})
+		f := &queryExecutionFixture{
+			services: []testService{
+				{
+					schema: `type Query { test: String }`,
+					handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						// the request context is only cancelled on disconnect
+						// once the body was consumed
+						_, _ = io.Copy(io.Discard, r.Body)
+						select {
+						case <-r.Context().Done():
+							close(cancelled)
+						case <-time.After(5 * time.Second):
+						}
+					}),
+				},
+			},
+		}
+		srv := newSSETestServer(t, f.setup(t), SSETransport{HeartbeatInterval: 10 * time.Millisecond})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL, strings.NewReader(`{"query": "{ test }"}`))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "text/event-stream")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		heartbeat := make([]byte, 3)
+		_, err = io.ReadFull(resp.Body, heartbeat)
+		require.NoError(t, err)
+		assert.Equal(t, ":\n\n", string(heartbeat))
+
+		cancel()
+		resp.Body.Close()
+
+		select {
+		case <-cancelled:
+		case <-time.After(2 * time.Second):
+			t.Error("downstream request was not cancelled")
+		}
+	})
+}
@@ -0,0 +1,189 @@
+package bramble
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const (
+	tracerName              = "github.com/movio/bramble"
+	defaultTracingService   = "bramble"
+	tracingExporterOTLP     = "otlp"
+	tracingExporterOTLPFile = "otlp-file"
+	tracingExporterStdout   = "stdout"
+)
+
+// tracer creates the spans of the gateway. Spans are only recorded once a
+// tracer provider is configured, see NewTracerProvider.
+var tracer = otel.Tracer(tracerName)
+
+// TracingConfig is the configuration of the OpenTelemetry tracing
+type TracingConfig struct {
+	// Exporter is the span exporter, one of "otlp", "otlp-file" or "stdout"
+	Exporter string `json:"exporter"`
+	// Endpoint is the host and port of the OTLP/HTTP collector, defaults to
+	// the standard OTEL_EXPORTER_OTLP_* environment variables
+	Endpoint string `json:"endpoint"`
+	// Insecure disables TLS for the OTLP exporter
+	Insecure bool `json:"insecure"`
+	// File is the path of the file written by the "otlp-file" exporter, every
+	// line is an OTLP/JSON export request
+	File string `json:"file"`
+	// ServiceName is the service name set on the spans
+	ServiceName string `json:"service-name"`
+}
+
+// NewTracerProvider returns a tracer provider exporting spans as configured
+func NewTracerProvider(cfg TracingConfig) (*sdktrace.TracerProvider, error) {
+	exporter, err := newSpanExporter(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = defaultTracingService
+	}
+	res := resource.NewSchemaless(
+		attribute.String("service.name", serviceName),
+		attribute.String("service.version", Version),
+	)
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	), nil
+}
+
+func newSpanExporter(cfg TracingConfig) (sdktrace.SpanExporter, error) {
+	switch cfg.Exporter {
+	case tracingExporterOTLP:
+		var opts []otlptracehttp.Option
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		return otlptracehttp.New(context.Background(), opts...)
+	case tracingExporterOTLPFile:
+		if cfg.File == "" {
+			return nil, fmt.Errorf("the %q exporter requires a file", tracingExporterOTLPFile)
+		}
+		return otlptrace.New(context.Background(), &otlpFileClient{path: cfg.File})
+	case tracingExporterStdout:
+		return stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", cfg.Exporter)
+	}
+}
+
+// setupTracing installs the tracer provider and the W3C trace context
+// propagator
+func setupTracing(provider trace.TracerProvider) {
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+}
+
+// otlpFileClient writes the spans to a file in the OTLP/JSON format, one
+// export request per line
+type otlpFileClient struct {
+	path string
+
+	mutex sync.Mutex
+	file  *os.File
+}
+
+func (c *otlpFileClient) Start(_ context.Context) error {
+	file, err := os.OpenFile(c.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("error opening traces file: %w", err)
+	}
+	c.file = file
+	return nil
+}
+
+func (c *otlpFileClient) Stop(_ context.Context) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.file.Close()
+}
+
+func (c *otlpFileClient) UploadTraces(_ context.Context, protoSpans []*tracepb.ResourceSpans) error {
+	data, err := protojson.Marshal(&coltracepb.ExportTraceServiceRequest{ResourceSpans: protoSpans})
+	if err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	_, err = c.file.Write(append(data, '\n'))
+	return err
+}
+
+// tracingMiddleware starts the span of the request, as a child of the trace
+// context sent by the client if any
+func tracingMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method+" "+r.URL.Path, trace.WithSpanKind(trace.SpanKindServer))
+		defer span.End()
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// traceTiming records a span for a phase that already happened, such as
+// parsing and validation done by gqlgen
+func traceTiming(ctx context.Context, name string, timing graphql.TraceTiming) {
+	if timing.Start.IsZero() || timing.End.IsZero() {
+		return
+	}
+	_, span := tracer.Start(ctx, name, trace.WithTimestamp(timing.Start))
+	span.End(trace.WithTimestamp(timing.End))
+}
+
+// endSpan records the error, if any, and ends the span
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// traceID returns the id of the trace of the context, if it's recorded
+func traceID(ctx context.Context) (string, bool) {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.HasTraceID() || !spanContext.IsSampled() {
+		return "", false
+	}
+	return spanContext.TraceID().String(), true
+}
+
+// shutdownTracing flushes the spans and stops the exporter
+func shutdownTracing(provider *sdktrace.TracerProvider, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return provider.Shutdown(ctx)
+}
@@ -0,0 +1,196 @@
+package bramble
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+var (
+	spanRecorderOnce sync.Once
+	spanRecorder     *tracetest.SpanRecorder
+)
+
+// testSpanRecorder returns the recorder of the spans of the package tracer.
+// The global tracer provider can only be installed once, so the recorder is
+// shared by the tests.
+func testSpanRecorder() *tracetest.SpanRecorder {
+	spanRecorderOnce.Do(func() {
+		spanRecorder = tracetest.NewSpanRecorder()
+		setupTracing(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spanRecorder)))
+	})
+	return spanRecorder
+}
+
+func TestTracing(t *testing.T) {
+	recorder := testSpanRecorder()
+
+	var (
+		mutex        sync.Mutex
+		traceParents []string
+	)
+	recordTraceParent := func(r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		traceParents = append(traceParents, r.Header.Get("traceparent"))
+	}
+	f := &queryExecutionFixture{
+		services: []testService{
+			{
+				schema: `directive @boundary on OBJECT
+				type Movie @boundary {
+					id: ID!
+					title: String
+				}
+				type Query {
+					movie(id: ID!): Movie!
+				}`,
+				handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					recordTraceParent(r)
+					w.Write([]byte(`{"data": {"movie": {"_bramble_id": "1", "_bramble__typename": "Movie", "title": "Test title"}}}`))
+				}),
+			},
+			{
+				schema: `directive @boundary on OBJECT | FIELD_DEFINITION
+				type Movie @boundary {
+					id: ID!
+					release: Int
+				}
+				type Query {
+					movie(id: ID!): Movie @boundary
+				}`,
+				handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					recordTraceParent(r)
+					w.Write([]byte(`{"data": {"_0": {"_bramble_id": "1", "_bramble__typename": "Movie", "release": 2007}}}`))
+				}),
+			},
+		},
+	}
+	es := f.setup(t)
+
+	gateway := handler.New(es)
+	gateway.AddTransport(transport.POST{})
+	srv := httptest.NewServer(applyMiddleware(gateway, debugMiddleware, tracingMiddleware))
+	t.Cleanup(srv.Close)
+
+	req, err := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader(`{"query": "{ movie(id: \"1\") { title release } }"}`))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(debugHeader, "traceid")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var result struct {
+		Data       json.RawMessage
+		Extensions map[string]interface{}
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	assert.JSONEq(t, `{"movie": {"title": "Test title", "release": 2007}}`, string(result.Data))
+	traceID, _ := result.Extensions["traceid"].(string)
+	require.NotEmpty(t, traceID)
+
+	spanCounts := make(map[string]int)
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().TraceID().String() == traceID {
+			spanCounts[span.Name()]++
+		}
+	}
+	assert.Equal(t, map[string]int{
+		"POST /":             1,
+		"parse":              1,
+		"validate":           1,
+		"operation":          1,
+		"plan":               1,
+		"step":               2,
+		"downstream request": 2,
+		"merge":              1,
+		"bubble":             1,
+		"format":             1,
+	}, spanCounts)
+
+	require.Len(t, traceParents, 2)
+	for _, traceParent := range traceParents {
+		assert.Contains(t, traceParent, traceID, "the trace context is propagated to services")
+	}
+}
+
+func TestTracingSubscription(t *testing.T) {
+	testSpanRecorder()
+
+	traceParent := make(chan string, 1)
+	handler := subscriptionServiceHandler(t, "subscription { counter }", `{"data": {"counter": 1}}`)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceParent <- r.Header.Get("traceparent")
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	ctx, span := tracer.Start(context.Background(), "subscription")
+	defer span.End()
+	events, err := NewClient().Subscribe(ctx, srv.URL, NewRequest("subscription { counter }"))
+	require.NoError(t, err)
+	for range events {
+	}
+
+	assert.Contains(t, <-traceParent, span.SpanContext().TraceID().String(), "the trace context is propagated to the websocket handshake")
+}
+
+func TestTracingOTLPFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	provider, err := NewTracerProvider(TracingConfig{Exporter: "otlp-file", File: path, ServiceName: "gateway"})
+	require.NoError(t, err)
+
+	_, span := provider.Tracer(tracerName).Start(context.Background(), "operation")
+	span.End()
+	require.NoError(t, shutdownTracing(provider, time.Second))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var request struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []struct {
+					Key   string
+					Value struct {
+						StringValue string
+					}
+				}
+			}
+			ScopeSpans []struct {
+				Spans []struct {
+					Name    string
+					TraceID string
+				}
+			}
+		}
+	}
+	require.NoError(t, json.Unmarshal(data, &request))
+	require.Len(t, request.ResourceSpans, 1)
+	assert.Contains(t, request.ResourceSpans[0].Resource.Attributes, struct {
+		Key   string
+		Value struct{ StringValue string }
+	}{Key: "service.name", Value: struct{ StringValue string }{StringValue: "gateway"}})
+	require.Len(t, request.ResourceSpans[0].ScopeSpans, 1)
+	require.Len(t, request.ResourceSpans[0].ScopeSpans[0].Spans, 1)
+	assert.Equal(t, "operation", request.ResourceSpans[0].ScopeSpans[0].Spans[0].Name)
+
+	_, err = NewTracerProvider(TracingConfig{Exporter: "otlp-file"})
+	assert.Error(t, err)
+	_, err = NewTracerProvider(TracingConfig{Exporter: "zipkin"})
+	assert.Error(t, err)
+}
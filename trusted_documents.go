@@ -0,0 +1,195 @@
+package bramble
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/errcode"
+	log "github.com/sirupsen/logrus"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+const (
+	trustedDocumentsModeEnforce = "enforce"
+	trustedDocumentsModeLogOnly = "log-only"
+
+	errOperationNotTrustedCode = "OPERATION_NOT_TRUSTED"
+)
+
+// TrustedDocumentsConfig is the configuration of the trusted documents
+// allowlist
+type TrustedDocumentsConfig struct {
+	// Manifest is the path of the JSON manifest, an object mapping the
+	// sha256 hash of every trusted document to the document
+	Manifest string `json:"manifest"`
+	// Mode is either "enforce" (default), rejecting operations missing from
+	// the manifest, or "log-only"
+	Mode string `json:"mode"`
+}
+
+// TrustedDocuments is a gqlgen extension only allowing operations from a
+// manifest of trusted documents. Clients can send either the full document
+// or only its hash in the persistedQuery extension.
+type TrustedDocuments struct {
+	mutex     sync.RWMutex
+	config    TrustedDocumentsConfig
+	documents map[string]string
+}
+
+var _ interface {
+	graphql.OperationParameterMutator
+	graphql.HandlerExtension
+} = &TrustedDocuments{}
+
+// NewTrustedDocuments returns the allowlist described by the configuration
+// and loads its manifest
+func NewTrustedDocuments(cfg TrustedDocumentsConfig) (*TrustedDocuments, error) {
+	d := &TrustedDocuments{}
+	if err := d.Update(cfg); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Update replaces the configuration and reloads the manifest. The previous
+// configuration and manifest are kept on error.
+func (d *TrustedDocuments) Update(cfg TrustedDocumentsConfig) error {
+	switch cfg.Mode {
+	case "":
+		cfg.Mode = trustedDocumentsModeEnforce
+	case trustedDocumentsModeEnforce, trustedDocumentsModeLogOnly:
+	default:
+		return fmt.Errorf("invalid trusted documents mode %q", cfg.Mode)
+	}
+
+	documents, err := loadTrustedDocumentsManifest(cfg.Manifest)
+	if err != nil {
+		return err
+	}
+
+	d.mutex.Lock()
+	d.config = cfg
+	d.documents = documents
+	d.mutex.Unlock()
+	return nil
+}
+
+// Reload reloads the manifest
+func (d *TrustedDocuments) Reload() error {
+	return d.Update(d.Config())
+}
+
+// Config returns the current configuration
+func (d *TrustedDocuments) Config() TrustedDocumentsConfig {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	return d.config
+}
+
+func loadTrustedDocumentsManifest(path string) (map[string]string, error) {
+	if path == "" {
+		return nil, fmt.Errorf("missing trusted documents manifest")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read trusted documents manifest: %w", err)
+	}
+	var documents map[string]string
+	if err := json.Unmarshal(data, &documents); err != nil {
+		return nil, fmt.Errorf("error decoding trusted documents manifest %q: %w", path, err)
+	}
+	for hash, document := range documents {
+		if documentHash(document) != hash {
+			return nil, fmt.Errorf("trusted document %q does not match its hash", hash)
+		}
+	}
+	return documents, nil
+}
+
+func documentHash(document string) string {
+	hash := sha256.Sum256([]byte(document))
+	return hex.EncodeToString(hash[:])
+}
+
+// ExtensionName returns the name of the extension
+func (d *TrustedDocuments) ExtensionName() string {
+	return "TrustedDocuments"
+}
+
+// Validate validates the extension
+func (d *TrustedDocuments) Validate(schema graphql.ExecutableSchema) error {
+	return nil
+}
+
+// MutateOperationParameters checks the operation against the manifest, and
+// replaces a missing query with the trusted document matching its hash.
+func (d *TrustedDocuments) MutateOperationParameters(ctx context.Context, rawParams *graphql.RawParams) *gqlerror.Error {
+	d.mutex.RLock()
+	mode, documents := d.config.Mode, d.documents
+	d.mutex.RUnlock()
+
+	var hash string
+	var trusted bool
+	if rawParams.Query == "" {
+		hash = persistedQueryHash(rawParams.Extensions)
+		if document, ok := documents[hash]; ok && hash != "" {
+			rawParams.Query = document
+			trusted = true
+		}
+	} else {
+		hash = documentHash(rawParams.Query)
+		_, trusted = documents[hash]
+	}
+
+	AddFields(ctx, EventFields{
+		"operation.hash":    hash,
+		"operation.trusted": trusted,
+	})
+
+	if trusted {
+		return nil
+	}
+
+	if mode == trustedDocumentsModeLogOnly {
+		log.WithFields(log.Fields{
+			"hash":          hash,
+			"operationName": rawParams.OperationName,
+		}).Warn("operation is not a trusted document")
+		return nil
+	}
+
+	err := gqlerror.Errorf("operation is not a trusted document")
+	errcode.Set(err, errOperationNotTrustedCode)
+	return err
+}
+
+// persistedQueryHash returns the hash sent in the persistedQuery extension
+func persistedQueryHash(extensions map[string]interface{}) string {
+	persistedQuery, ok := extensions["persistedQuery"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	hash, _ := persistedQuery["sha256Hash"].(string)
+	return hash
+}
+
+// isTrustedDocumentsManifest returns whether the path is the manifest file,
+// or whether the manifest is a symlink that now points to another file.
+func isTrustedDocumentsManifest(path, manifest string, linkedManifest *string) bool {
+	if filepath.Clean(path) == filepath.Clean(manifest) {
+		return true
+	}
+	current, _ := filepath.EvalSymlinks(manifest)
+	if *linkedManifest != "" && current != *linkedManifest {
+		*linkedManifest = current
+		return true
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package bramble
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTrustedDocumentsManifest(t *testing.T, path string, documents ...string) {
+	manifest := make(map[string]string)
+	for _, document := range documents {
+		manifest[documentHash(document)] = document
+	}
+	data, err := json.Marshal(manifest)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+}
+
+func TestTrustedDocuments(t *testing.T) {
+	trustedQuery := "query { test }"
+	manifest := filepath.Join(t.TempDir(), "manifest.json")
+	writeTrustedDocumentsManifest(t, manifest, trustedQuery)
+
+	t.Run("enforce", func(t *testing.T) {
+		documents, err := NewTrustedDocuments(TrustedDocumentsConfig{Manifest: manifest})
+		require.NoError(t, err)
+
+		ctx, event := startEvent(context.Background(), "request")
+		assert.Nil(t, documents.MutateOperationParameters(ctx, &graphql.RawParams{Query: trustedQuery}))
+		assert.Equal(t, true, event.fields["operation.trusted"])
+		assert.Equal(t, documentHash(trustedQuery), event.fields["operation.hash"])
+
+		ctx, event = startEvent(context.Background(), "request")
+		gqlErr := documents.MutateOperationParameters(ctx, &graphql.RawParams{Query: "query { other }"})
+		require.NotNil(t, gqlErr)
+		assert.Equal(t, "operation is not a trusted document", gqlErr.Message)
+		assert.Equal(t, errOperationNotTrustedCode, gqlErr.Extensions["code"])
+		assert.Equal(t, false, event.fields["operation.trusted"])
+	})
+
+	t.Run("hash only", func(t *testing.T) {
+		documents, err := NewTrustedDocuments(TrustedDocumentsConfig{Manifest: manifest})
+		require.NoError(t, err)
+
+		params := &graphql.RawParams{
+			Extensions: map[string]interface{}{
+				"persistedQuery": map[string]interface{}{"version": 1, "sha256Hash": documentHash(trustedQuery)},
+			},
+		}
+		assert.Nil(t, documents.MutateOperationParameters(context.Background(), params))
+		assert.Equal(t, trustedQuery, params.Query)
+
+		assert.NotNil(t, documents.MutateOperationParameters(context.Background(), &graphql.RawParams{}))
+	})
+
+	t.Run("log-only", func(t *testing.T) {
+		documents, err := NewTrustedDocuments(TrustedDocumentsConfig{Manifest: manifest, Mode: "log-only"})
+		require.NoError(t, err)
+
+		ctx, event := startEvent(context.Background(), "request")
+		assert.Nil(t, documents.MutateOperationParameters(ctx, &graphql.RawParams{Query: "query { other }"}))
+		assert.Equal(t, false, event.fields["operation.trusted"])
+	})
+
+	t.Run("reload", func(t *testing.T) {
+		manifest := filepath.Join(t.TempDir(), "manifest.json")
+		writeTrustedDocumentsManifest(t, manifest, trustedQuery)
+		documents, err := NewTrustedDocuments(TrustedDocumentsConfig{Manifest: manifest})
+		require.NoError(t, err)
+
+		writeTrustedDocumentsManifest(t, manifest, "query { other }")
+		require.NoError(t, documents.Reload())
+		assert.Nil(t, documents.MutateOperationParameters(context.Background(), &graphql.RawParams{Query: "query { other }"}))
+		assert.NotNil(t, documents.MutateOperationParameters(context.Background(), &graphql.RawParams{Query: trustedQuery}))
+
+		// an invalid manifest keeps the previous documents
+		require.NoError(t, os.WriteFile(manifest, []byte(`{"invalid": "query { test }"}`), 0o644))
+		assert.Error(t, documents.Reload())
+		assert.Nil(t, documents.MutateOperationParameters(context.Background(), &graphql.RawParams{Query: "query { other }"}))
+	})
+
+	t.Run("invalid configuration", func(t *testing.T) {
+		_, err := NewTrustedDocuments(TrustedDocumentsConfig{Manifest: manifest, Mode: "strict"})
+		assert.Error(t, err)
+		_, err = NewTrustedDocuments(TrustedDocumentsConfig{Manifest: filepath.Join(t.TempDir(), "missing.json")})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,314 @@
+package bramble
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+// Message types of the graphql-transport-ws protocol, see
+// https://github.com/enisdenjo/graphql-ws/blob/master/PROTOCOL.md
+const (
+	graphqlTransportWSProtocol = "graphql-transport-ws"
+
+	wsConnectionInitMsg = "connection_init" // Client -> Server
+	wsConnectionAckMsg  = "connection_ack"  // Server -> Client
+	wsPingMsg           = "ping"            // bidirectional
+	wsPongMsg           = "pong"            // bidirectional
+	wsSubscribeMsg      = "subscribe"       // Client -> Server
+	wsNextMsg           = "next"            // Server -> Client
+	wsErrorMsg          = "error"           // Server -> Client
+	wsCompleteMsg       = "complete"        // bidirectional
+)
+
+// Close codes of the graphql-transport-ws protocol
+const (
+	wsCloseBadRequest         = 4400
+	wsCloseUnauthorized       = 4401
+	wsCloseForbidden          = 4403
+	wsCloseSubprotocol        = 4406
+	wsCloseInitTimeout        = 4408
+	wsCloseSubscriberExists   = 4409
+	wsCloseTooManyInitRequest = 4429
+)
+
+const defaultConnectionInitWaitTimeout = 3 * time.Second
+
+type wsMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// WebsocketInitFunc is called with the connection_init payload of a websocket
+// connection. It can be used to authenticate the connection, returning an
+// error closes the connection.
+type WebsocketInitFunc func(ctx context.Context, payload map[string]interface{}) (context.Context, error)
+
+// WebsocketTransport is a gqlgen transport serving operations (and in
+// particular subscriptions) over the graphql-transport-ws protocol.
+type WebsocketTransport struct {
+	Upgrader websocket.Upgrader
+	InitFunc WebsocketInitFunc
+	// ConnectionInitWaitTimeout is the time allowed for the client to send
+	// the connection_init message after the connection was opened.
+	ConnectionInitWaitTimeout time.Duration
+	// KeepAlivePingInterval is the interval at which ping messages are sent
+	// to the client, 0 disables keep alive pings.
+	KeepAlivePingInterval time.Duration
+}
+
+var _ graphql.Transport = WebsocketTransport{}
+
+// Supports returns whether the request is a websocket upgrade request
+func (t WebsocketTransport) Supports(r *http.Request) bool {
+	return r.Header.Get("Upgrade") != ""
+}
+
+// Do upgrades the connection and serves the graphql-transport-ws protocol
+// until the connection is closed.
+func (t WebsocketTransport) Do(w http.ResponseWriter, r *http.Request, exec graphql.GraphExecutor) {
+	upgrader := t.Upgrader
+	upgrader.Subprotocols = []string{graphqlTransportWSProtocol}
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader already replied with an HTTP error
+		log.WithError(err).Debug("unable to upgrade websocket connection")
+		return
+	}
+
+	conn := &wsConnection{
+		WebsocketTransport: t,
+		conn:               ws,
+		exec:               exec,
+		active:             make(map[string]context.CancelFunc),
+		initialized:        make(chan struct{}),
+	}
+
+	if ws.Subprotocol() != graphqlTransportWSProtocol {
+		conn.close(wsCloseSubprotocol, "subprotocol not acceptable")
+		return
+	}
+
+	conn.run(withIncrementalDelivery(r.Context()))
+}
+
+type wsConnection struct {
+	WebsocketTransport
+	conn *websocket.Conn
+	exec graphql.GraphExecutor
+
+	writeLock   sync.Mutex
+	activeLock  sync.Mutex
+	active      map[string]context.CancelFunc
+	initialized chan struct{}
+}
+
+func (c *wsConnection) run(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer func() {
+		cancel()
+		c.conn.Close()
+	}()
+
+	initTimeout := c.ConnectionInitWaitTimeout
+	if initTimeout == 0 {
+		initTimeout = defaultConnectionInitWaitTimeout
+	}
+	go func() {
+		select {
+		case <-c.initialized:
+		case <-ctx.Done():
+		case <-time.After(initTimeout):
+			c.close(wsCloseInitTimeout, "connection initialisation timeout")
+		}
+	}()
+
+	if c.KeepAlivePingInterval > 0 {
+		go c.keepAlive(ctx)
+	}
+
+	for {
+		var msg wsMessage
+		if err := c.conn.ReadJSON(&msg); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.WithError(err).Debug("websocket read error")
+			}
+			return
+		}
+
+		switch msg.Type {
+		case wsConnectionInitMsg:
+			if c.isInitialized() {
+				c.close(wsCloseTooManyInitRequest, "too many initialisation requests")
+				return
+			}
+			var payload map[string]interface{}
+			if len(msg.Payload) > 0 {
+				if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+					c.close(wsCloseBadRequest, "invalid connection_init payload")
+					return
+				}
+			}
+			if c.InitFunc != nil {
+				initCtx, err := c.InitFunc(ctx, payload)
+				if err != nil {
+					c.close(wsCloseForbidden, err.Error())
+					return
+				}
+				ctx = initCtx
+			}
+			close(c.initialized)
+			c.write(wsMessage{Type: wsConnectionAckMsg})
+		case wsPingMsg:
+			c.write(wsMessage{Type: wsPongMsg})
+		case wsPongMsg:
+		case wsSubscribeMsg:
+			if !c.isInitialized() {
+				c.close(wsCloseUnauthorized, "unauthorized")
+				return
+			}
+			if !c.subscribe(ctx, msg) {
+				return
+			}
+		case wsCompleteMsg:
+			c.complete(msg.ID)
+		default:
+			c.close(wsCloseBadRequest, fmt.Sprintf("unexpected message type %q", msg.Type))
+			return
+		}
+	}
+}
+
+// subscribe starts the execution of the operation, it returns false if the
+// connection was closed.
+func (c *wsConnection) subscribe(ctx context.Context, msg wsMessage) bool {
+	if msg.ID == "" {
+		c.close(wsCloseBadRequest, "missing subscription id")
+		return false
+	}
+
+	var params graphql.RawParams
+	if err := json.Unmarshal(msg.Payload, &params); err != nil {
+		c.close(wsCloseBadRequest, "invalid subscribe payload")
+		return false
+	}
+	params.ReadTime = graphql.TraceTiming{
+		Start: graphql.Now(),
+		End:   graphql.Now(),
+	}
+
+	c.activeLock.Lock()
+	if _, exists := c.active[msg.ID]; exists {
+		c.activeLock.Unlock()
+		c.close(wsCloseSubscriberExists, fmt.Sprintf("subscriber for %s already exists", msg.ID))
+		return false
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	c.active[msg.ID] = cancel
+	c.activeLock.Unlock()
+
+	go func() {
+		defer func() {
+			// an error message terminates the operation, complete is only
+			// sent for operations that ended normally
+			if r := recover(); r != nil && c.complete(msg.ID) {
+				err := graphql.DefaultRecover(ctx, r)
+				c.sendErrors(msg.ID, gqlerror.List{{Message: err.Error()}})
+			}
+			if c.complete(msg.ID) {
+				c.write(wsMessage{ID: msg.ID, Type: wsCompleteMsg})
+			}
+		}()
+
+		rc, errs := c.exec.CreateOperationContext(ctx, &params)
+		if errs != nil {
+			resp := c.exec.DispatchError(graphql.WithOperationContext(ctx, rc), errs)
+			if c.complete(msg.ID) {
+				c.sendErrors(msg.ID, resp.Errors)
+			}
+			return
+		}
+
+		responses, ctx := c.exec.DispatchOperation(ctx, rc)
+		for {
+			response := responses(ctx)
+			if response == nil {
+				return
+			}
+			payload, err := marshalResponse(response)
+			if err != nil {
+				panic(err)
+			}
+			c.write(wsMessage{ID: msg.ID, Type: wsNextMsg, Payload: payload})
+		}
+	}()
+
+	return true
+}
+
+// complete removes the subscription from the active subscriptions. It returns
+// false if the subscription was already completed by the client.
+func (c *wsConnection) complete(id string) bool {
+	c.activeLock.Lock()
+	defer c.activeLock.Unlock()
+	cancel, ok := c.active[id]
+	if ok {
+		delete(c.active, id)
+		cancel()
+	}
+	return ok
+}
+
+func (c *wsConnection) sendErrors(id string, errs gqlerror.List) {
+	payload, err := json.Marshal(errs)
+	if err != nil {
+		panic(err)
+	}
+	c.write(wsMessage{ID: id, Type: wsErrorMsg, Payload: payload})
+}
+
+func (c *wsConnection) keepAlive(ctx context.Context) {
+	ticker := time.NewTicker(c.KeepAlivePingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.write(wsMessage{Type: wsPingMsg})
+		}
+	}
+}
+
+func (c *wsConnection) isInitialized() bool {
+	select {
+	case <-c.initialized:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *wsConnection) write(msg wsMessage) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	if err := c.conn.WriteJSON(msg); err != nil {
+		log.WithError(err).Debug("websocket write error")
+	}
+}
+
+func (c *wsConnection) close(code int, reason string) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	_ = c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason))
+	_ = c.conn.Close()
+}
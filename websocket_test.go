@@ -0,0 +1,163 @@
+package bramble
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// subscriptionServiceHandler serves the graphql-transport-ws protocol and
+// sends the given events before completing the subscription
+func subscriptionServiceHandler(t *testing.T, expectedQuery string, events ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{Subprotocols: []string{graphqlTransportWSProtocol}}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		var msg wsMessage
+		require.NoError(t, conn.ReadJSON(&msg))
+		require.Equal(t, wsConnectionInitMsg, msg.Type)
+		require.NoError(t, conn.WriteJSON(wsMessage{Type: wsConnectionAckMsg}))
+
+		require.NoError(t, conn.ReadJSON(&msg))
+		require.Equal(t, wsSubscribeMsg, msg.Type)
+		var req Request
+		require.NoError(t, json.Unmarshal(msg.Payload, &req))
+		assert.Equal(t, expectedQuery, strings.Join(strings.Fields(req.Query), " "))
+
+		for _, event := range events {
+			require.NoError(t, conn.WriteJSON(wsMessage{ID: msg.ID, Type: wsNextMsg, Payload: json.RawMessage(event)}))
+		}
+		require.NoError(t, conn.WriteJSON(wsMessage{ID: msg.ID, Type: wsCompleteMsg}))
+	})
+}
+
+func TestSubscription(t *testing.T) {
+	f := &queryExecutionFixture{
+		services: []testService{
+			{
+				schema: `directive @boundary on OBJECT
+				type Movie @boundary {
+					id: ID!
+					title: String!
+				}
+				type Query {
+					movie(id: ID!): Movie!
+				}
+				type Subscription {
+					movieReleased: Movie!
+				}`,
+				handler: subscriptionServiceHandler(t,
+					"subscription { movieReleased { title _bramble_id: id _bramble__typename: __typename } }",
+					`{"data": {"movieReleased": {"_bramble_id": "1", "_bramble__typename": "Movie", "title": "Movie 1"}}}`,
+					`{"data": {"movieReleased": {"_bramble_id": "2", "_bramble__typename": "Movie", "title": "Movie 2"}}}`,
+				),
+			},
+			{
+				schema: `directive @boundary on OBJECT | FIELD_DEFINITION
+				type Movie @boundary {
+					id: ID!
+					rating: Int!
+				}
+				type Query {
+					movie(id: ID!): Movie @boundary
+				}`,
+				handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					var req Request
+					require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+					id := "1"
+					if strings.Contains(req.Query, `"2"`) {
+						id = "2"
+					}
+					w.Write([]byte(`{"data": {"_0": {"_bramble_id": "` + id + `", "_bramble__typename": "Movie", "rating": ` + id + `}}}`))
+				}),
+			},
+		},
+	}
+
+	es := f.setup(t)
+	gateway := handler.New(es)
+	gateway.AddTransport(WebsocketTransport{})
+	srv := httptest.NewServer(gateway)
+	t.Cleanup(srv.Close)
+
+	dialer := websocket.Dialer{Subprotocols: []string{graphqlTransportWSProtocol}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+	require.NoError(t, conn.WriteJSON(wsMessage{Type: wsConnectionInitMsg}))
+	var msg wsMessage
+	require.NoError(t, conn.ReadJSON(&msg))
+	require.Equal(t, wsConnectionAckMsg, msg.Type)
+
+	payload, _ := json.Marshal(map[string]string{"query": "subscription { movieReleased { title rating } }"})
+	require.NoError(t, conn.WriteJSON(wsMessage{ID: "sub", Type: wsSubscribeMsg, Payload: payload}))
+
+	for _, expected := range []string{
+		`{"data": {"movieReleased": {"title": "Movie 1", "rating": 1}}, "extensions": {"complexity": 3}}`,
+		`{"data": {"movieReleased": {"title": "Movie 2", "rating": 2}}, "extensions": {"complexity": 3}}`,
+	} {
+		require.NoError(t, conn.ReadJSON(&msg))
+		require.Equal(t, wsNextMsg, msg.Type)
+		assert.Equal(t, "sub", msg.ID)
+		assert.JSONEq(t, expected, string(msg.Payload))
+	}
+
+	require.NoError(t, conn.ReadJSON(&msg))
+	assert.Equal(t, wsCompleteMsg, msg.Type)
+	assert.Equal(t, "sub", msg.ID)
+}
+
+func TestWebsocketTransport(t *testing.T) {
+	es := NewExecutableSchema(nil, 50, nil)
+	gateway := handler.New(es)
+	gateway.AddTransport(WebsocketTransport{ConnectionInitWaitTimeout: 50 * time.Millisecond})
+	srv := httptest.NewServer(gateway)
+	t.Cleanup(srv.Close)
+
+	dial := func(t *testing.T) *websocket.Conn {
+		dialer := websocket.Dialer{Subprotocols: []string{graphqlTransportWSProtocol}}
+		conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		return conn
+	}
+
+	t.Run("closes the connection if not initialized", func(t *testing.T) {
+		conn := dial(t)
+		var msg wsMessage
+		err := conn.ReadJSON(&msg)
+		assert.True(t, websocket.IsCloseError(err, wsCloseInitTimeout))
+	})
+
+	t.Run("rejects subscribe before initialization", func(t *testing.T) {
+		conn := dial(t)
+		require.NoError(t, conn.WriteJSON(wsMessage{ID: "1", Type: wsSubscribeMsg, Payload: json.RawMessage(`{}`)}))
+		var msg wsMessage
+		err := conn.ReadJSON(&msg)
+		assert.True(t, websocket.IsCloseError(err, wsCloseUnauthorized))
+	})
+
+	t.Run("answers pings", func(t *testing.T) {
+		conn := dial(t)
+		require.NoError(t, conn.WriteJSON(wsMessage{Type: wsConnectionInitMsg}))
+		var msg wsMessage
+		require.NoError(t, conn.ReadJSON(&msg))
+		require.Equal(t, wsConnectionAckMsg, msg.Type)
+		require.NoError(t, conn.WriteJSON(wsMessage{Type: wsPingMsg}))
+		require.NoError(t, conn.ReadJSON(&msg))
+		require.Equal(t, wsPongMsg, msg.Type)
+	})
+}